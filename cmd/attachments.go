@@ -0,0 +1,450 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cheggaaa/pb/v3"
+	"github.com/deskpro/dputils/util"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const (
+	issueMissing      = "missing"
+	issueEmpty        = "empty"
+	issueHashMismatch = "hash_mismatch"
+	issueOrphan       = "orphan"
+)
+
+func init() {
+	attachmentsAuditCmd.Flags().String(
+		"format",
+		"table",
+		`
+			Output format, "table" or "json"
+		`,
+	)
+
+	attachmentsAuditCmd.Flags().String(
+		"fix-orphans",
+		"",
+		`
+			Move files which don't have a blob record out of the attachments dir, keeping their relative path.
+			Example: --fix-orphans=move-to:/var/backups/orphaned-attachments
+		`,
+	)
+
+	attachmentsMigrateCmd.Flags().String(
+		"from",
+		"",
+		`
+			The storage to move attachments from: "fs", "db" or "s3"
+		`,
+	)
+
+	attachmentsMigrateCmd.Flags().String(
+		"to",
+		"",
+		`
+			The storage to move attachments to: "fs", "db" or "s3"
+		`,
+	)
+
+	attachmentsMigrateCmd.Flags().String(
+		"s3",
+		"",
+		`
+			The bucket and prefix used for "s3" storage, in the same form as S3 backup targets:
+				s3://bucket/attachments?region=eu-west-1&aws_access_key_id=xxx&aws_access_key_secret=xxx
+
+			S3 compatible storages (e.g. MinIO) can be used with the endpoint=http://localhost:9000 and
+			path_style=true query parameters.
+		`,
+	)
+
+	attachmentsMigrateCmd.Flags().Int(
+		"batch-size",
+		100,
+		`
+			How many attachments are moved in a single database transaction
+		`,
+	)
+
+	attachmentsMigrateCmd.Flags().Bool(
+		"keep-source",
+		false,
+		`
+			Don't delete attachments from the source storage after they are moved
+		`,
+	)
+
+	attachmentsCmd.AddCommand(attachmentsAuditCmd)
+	attachmentsCmd.AddCommand(attachmentsMigrateCmd)
+	rootCmd.AddCommand(attachmentsCmd)
+}
+
+var attachmentsCmd = &cobra.Command{
+	Use:   "attachments",
+	Short: "Check attachments and move them between storages",
+}
+
+var attachmentsAuditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Check attachment files against the blobs table",
+	Long: `
+		Compares the blobs stored in the filesystem (storage_loc = 'fs') with the attachments dir and reports
+		missing files, empty files, files which MD5 doesn't match the blob hash and orphaned files which don't
+		have a blob record.
+
+		Exits with code 5 (attachment_error) if any problem is found, except orphans moved with --fix-orphans.
+	`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		return runAttachmentsAudit(cmd)
+	},
+}
+
+func runAttachmentsAudit(cmd *cobra.Command) error {
+	format, _ := cmd.Flags().GetString("format")
+	if format != "table" && format != "json" {
+		return util.NewError(util.ConfigError, "Wrong --format option, you may specify either \"table\" or \"json\"", nil)
+	}
+
+	fixOrphans, _ := cmd.Flags().GetString("fix-orphans")
+	orphansDir := ""
+	if fixOrphans != "" {
+		if !strings.HasPrefix(fixOrphans, "move-to:") || len(fixOrphans) == len("move-to:") {
+			return util.NewError(util.ConfigError, "Wrong --fix-orphans option, use --fix-orphans=move-to:<dir>", nil)
+		}
+		orphansDir, _ = filepath.Abs(strings.TrimPrefix(fixOrphans, "move-to:"))
+	}
+
+	// the JSON output must not be mixed with the config summary
+	var dpConfig map[string]string
+	var err error
+	if format == "json" {
+		if dpConfig, err = Config.GetDeskproConfig(); err != nil {
+			return util.NewError(util.ConfigError, "We failed to read the Deskpro config files", err)
+		}
+	} else if dpConfig, err = Config.LoadDeskproConfig(cmd); err != nil {
+		return err
+	}
+
+	db, err := util.GetMysqlConnectionFromConfig(dpConfig, "database")
+	if err != nil {
+		return util.NewError(util.ConfigError, "Failed to connect to the Deskpro database", err)
+	}
+	defer db.Close()
+
+	attachPath := util.AttachmentsPath(dpConfig, Config.DpPath())
+	audit, err := auditAttachments(db, attachPath)
+	if err != nil {
+		return util.NewError(util.AttachmentError, "Attachments audit failed", err)
+	}
+
+	if orphansDir != "" {
+		audit.moveOrphans(orphansDir)
+	}
+
+	if format == "json" {
+		out, _ := json.MarshalIndent(audit, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		audit.printTable()
+	}
+
+	if audit.failed() {
+		return util.NewError(util.AttachmentError, "The attachments audit found problems", nil)
+	}
+
+	return nil
+}
+
+var attachmentsMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Move attachments between the filesystem, the database and S3",
+	Long: `
+		Moves the content of attachments from one storage to another and updates storage_loc and save_path of
+		the blobs table. Every copy is checked against the blob hash before the blob is switched to the new
+		storage, blobs which fail are left in the source storage.
+
+		Attachments are moved in batches, each batch is a single database transaction. If the migration is
+		interrupted, run it again to move the remaining attachments.
+
+		Examples:
+			dputils attachments migrate --from fs --to db
+			dputils attachments migrate --from fs --to s3 --s3 "s3://bucket/attachments?region=eu-west-1"
+	`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		return runAttachmentsMigrate(cmd)
+	},
+}
+
+func runAttachmentsMigrate(cmd *cobra.Command) error {
+	from, _ := cmd.Flags().GetString("from")
+	to, _ := cmd.Flags().GetString("to")
+	if from == to {
+		return util.NewError(util.ConfigError, "--from and --to must be different storages", nil)
+	}
+
+	batchSize, _ := cmd.Flags().GetInt("batch-size")
+	if batchSize < 1 {
+		return util.NewError(util.ConfigError, "--batch-size must be at least 1", nil)
+	}
+
+	dpConfig, err := Config.LoadDeskproConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	fromStore, err := getBlobStore(cmd, dpConfig, "from", from)
+	if err != nil {
+		return err
+	}
+	toStore, err := getBlobStore(cmd, dpConfig, "to", to)
+	if err != nil {
+		return err
+	}
+
+	db, err := util.GetMysqlConnectionFromConfig(dpConfig, "database")
+	if err != nil {
+		return util.NewError(util.ConfigError, "Failed to connect to the Deskpro database", err)
+	}
+	defer db.Close()
+
+	var total int64
+	if err = db.QueryRow("SELECT COUNT(*) FROM blobs WHERE storage_loc = ?", from).Scan(&total); err != nil {
+		return util.NewError(util.AttachmentError, "Failed to count attachments", err)
+	}
+
+	fmt.Println("==========================================================================================")
+	fmt.Printf("Moving %d attachments from %s to %s\n", total, from, to)
+	fmt.Println("==========================================================================================")
+
+	keepSource, _ := cmd.Flags().GetBool("keep-source")
+	migration := &util.BlobMigration{Db: db, From: fromStore, To: toStore, BatchSize: batchSize, KeepSource: keepSource}
+
+	bar := pb.ProgressBarTemplate(`{{ blue "Attachments:" }} {{counters . }} {{bar . | green}} {{speed . | blue }}`).Start64(total)
+	result, err := migration.Run(func(batch util.BlobMigrationResult) {
+		bar.Add64(batch.Migrated + batch.Failed)
+	})
+	bar.Finish()
+
+	for _, message := range result.Errors {
+		log.Warning("Attachment migration: ", message)
+		fmt.Println("\t" + message)
+	}
+	fmt.Printf("Moved %d attachments (%d bytes), %d failed\n", result.Migrated, result.Bytes, result.Failed)
+
+	if err != nil {
+		return util.NewError(util.AttachmentError, "Attachment migration failed, run the command again to continue", err)
+	}
+	if result.Failed > 0 {
+		return util.NewError(util.AttachmentError, fmt.Sprintf("%d attachments failed to move, they're left in the %s storage", result.Failed, from), nil)
+	}
+
+	return nil
+}
+
+func getBlobStore(cmd *cobra.Command, dpConfig map[string]string, flag string, location string) (util.BlobStore, error) {
+	switch location {
+	case "fs":
+		return &util.FsBlobStore{Dir: util.AttachmentsPath(dpConfig, Config.DpPath())}, nil
+	case "db":
+		return &util.DbBlobStore{}, nil
+	case "s3":
+		target, _ := cmd.Flags().GetString("s3")
+		if target == "" {
+			return nil, util.NewError(util.ConfigError, "Specify the bucket with --s3 to use the s3 storage", nil)
+		}
+		store, err := util.NewS3BlobStore(target)
+		if err != nil {
+			return nil, util.NewError(util.ConfigError, "Wrong --s3 option", err)
+		}
+		return store, nil
+	}
+
+	return nil, util.NewError(util.ConfigError, "Wrong --"+flag+" option, you may specify \"fs\", \"db\" or \"s3\"", nil)
+}
+
+// attachmentAudit is the result of comparing the blobs table with the attachments dir
+type attachmentAudit struct {
+	Path         string            `json:"path"`
+	CheckedBlobs int64             `json:"checked_blobs"`
+	CheckedFiles int64             `json:"checked_files"`
+	Issues       []attachmentIssue `json:"issues"`
+}
+
+type attachmentIssue struct {
+	Type   string `json:"type"`
+	BlobId int64  `json:"blob_id,omitempty"`
+	Path   string `json:"path"`
+	Detail string `json:"detail,omitempty"`
+	Fixed  bool   `json:"fixed,omitempty"`
+}
+
+func auditAttachments(db *sql.DB, attachPath string) (*attachmentAudit, error) {
+	audit := &attachmentAudit{Path: attachPath, Issues: []attachmentIssue{}}
+	known := map[string]bool{}
+
+	var nextStartId int64
+	for {
+		batch, err := util.NextBlobBatch(context.Background(), db, nextStartId)
+		if err != nil {
+			return nil, err
+		}
+		if batch == nil {
+			break
+		}
+
+		for _, blob := range batch {
+			audit.CheckedBlobs++
+			known[filepath.ToSlash(filepath.Clean(blob.SavePath))] = true
+			if issue := auditBlob(attachPath, blob); issue != nil {
+				audit.Issues = append(audit.Issues, *issue)
+			}
+		}
+		nextStartId = batch[len(batch)-1].Id
+	}
+
+	err := filepath.Walk(attachPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(attachPath, path)
+		if err != nil {
+			return err
+		}
+		audit.CheckedFiles++
+
+		if !known[filepath.ToSlash(rel)] {
+			audit.Issues = append(audit.Issues, attachmentIssue{Type: issueOrphan, Path: filepath.ToSlash(rel)})
+		}
+
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return audit, nil
+}
+
+func auditBlob(attachPath string, blob util.Blob) *attachmentIssue {
+	path := filepath.Join(attachPath, filepath.FromSlash(blob.SavePath))
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return &attachmentIssue{Type: issueMissing, BlobId: blob.Id, Path: blob.SavePath}
+	}
+	if err != nil {
+		return &attachmentIssue{Type: issueMissing, BlobId: blob.Id, Path: blob.SavePath, Detail: err.Error()}
+	}
+
+	if info.Size() == 0 {
+		return &attachmentIssue{Type: issueEmpty, BlobId: blob.Id, Path: blob.SavePath}
+	}
+
+	hash, err := util.FileMd5(path)
+	if err != nil {
+		return &attachmentIssue{Type: issueHashMismatch, BlobId: blob.Id, Path: blob.SavePath, Detail: err.Error()}
+	}
+	if hash != blob.Hash {
+		return &attachmentIssue{Type: issueHashMismatch, BlobId: blob.Id, Path: blob.SavePath, Detail: "expected " + blob.Hash + ", got " + hash}
+	}
+
+	return nil
+}
+
+// moveOrphans moves orphaned files into dir, keeping their path relative to the attachments dir
+func (a *attachmentAudit) moveOrphans(dir string) {
+	for i := range a.Issues {
+		issue := &a.Issues[i]
+		if issue.Type != issueOrphan {
+			continue
+		}
+
+		src := filepath.Join(a.Path, filepath.FromSlash(issue.Path))
+		dst := filepath.Join(dir, filepath.FromSlash(issue.Path))
+		if err := moveFile(src, dst); err != nil {
+			issue.Detail = "failed to move: " + err.Error()
+			continue
+		}
+
+		issue.Fixed = true
+		issue.Detail = "moved to " + dst
+	}
+}
+
+// moveFile renames a file, or copies and removes it if the destination is on a different filesystem
+func moveFile(src string, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	if err := util.CopyFile(src, dst); err != nil {
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+func (a *attachmentAudit) failed() bool {
+	for _, issue := range a.Issues {
+		if !issue.Fixed {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *attachmentAudit) printTable() {
+	fmt.Println("==========================================================================================")
+	fmt.Println("Attachments audit of " + a.Path)
+	fmt.Println("==========================================================================================")
+
+	counts := map[string]int{}
+	for _, issue := range a.Issues {
+		counts[issue.Type]++
+	}
+
+	if len(a.Issues) > 0 {
+		sort.SliceStable(a.Issues, func(i, j int) bool {
+			return a.Issues[i].Type < a.Issues[j].Type
+		})
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "TYPE\tBLOB ID\tPATH\tDETAIL")
+		for _, issue := range a.Issues {
+			blobId := "-"
+			if issue.BlobId > 0 {
+				blobId = fmt.Sprintf("%d", issue.BlobId)
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", issue.Type, blobId, issue.Path, issue.Detail)
+		}
+		_ = w.Flush()
+		fmt.Println("")
+	}
+
+	fmt.Printf("Checked %d blobs and %d files\n", a.CheckedBlobs, a.CheckedFiles)
+	fmt.Printf("\tMissing files: %d\n", counts[issueMissing])
+	fmt.Printf("\tEmpty files: %d\n", counts[issueEmpty])
+	fmt.Printf("\tHash mismatches: %d\n", counts[issueHashMismatch])
+	fmt.Printf("\tOrphaned files: %d\n", counts[issueOrphan])
+}
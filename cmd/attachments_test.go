@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/deskpro/dputils/util"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_auditAttachments(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	dir := t.TempDir()
+	_ = os.MkdirAll(filepath.Join(dir, "a"), 0755)
+	_ = os.MkdirAll(filepath.Join(dir, "b"), 0755)
+	_ = os.WriteFile(filepath.Join(dir, "a", "ok"), []byte("hello"), 0644)
+	_ = os.WriteFile(filepath.Join(dir, "a", "empty"), []byte{}, 0644)
+	_ = os.WriteFile(filepath.Join(dir, "a", "bad"), []byte("changed"), 0644)
+	_ = os.WriteFile(filepath.Join(dir, "b", "orphan"), []byte("orphan"), 0644)
+
+	expectedSql := `SELECT id, save_path, blob_hash FROM blobs WHERE id > \? AND storage_loc = 'fs' ORDER BY id ASC LIMIT 100`
+	rows := sqlmock.NewRows([]string{"id", "save_path", "blob_hash"}).
+		AddRow(1, "a/ok", "5d41402abc4b2a76b9719d911017c592").
+		AddRow(2, "a/missing", "5d41402abc4b2a76b9719d911017c592").
+		AddRow(3, "a/empty", "d41d8cd98f00b204e9800998ecf8427e").
+		AddRow(4, "a/bad", "5d41402abc4b2a76b9719d911017c592")
+	mock.ExpectQuery(expectedSql).WithArgs(0).WillReturnRows(rows)
+	mock.ExpectQuery(expectedSql).WithArgs(4).WillReturnRows(sqlmock.NewRows([]string{"id", "save_path", "blob_hash"}))
+
+	audit, err := auditAttachments(db, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issues := map[string]string{}
+	for _, issue := range audit.Issues {
+		issues[issue.Path] = issue.Type
+	}
+	expected := map[string]string{"a/missing": issueMissing, "a/empty": issueEmpty, "a/bad": issueHashMismatch, "b/orphan": issueOrphan}
+	if len(issues) != len(expected) {
+		t.Errorf("Unexpected issues %v", audit.Issues)
+	}
+	for path, issueType := range expected {
+		if issues[path] != issueType {
+			t.Errorf("Expected %s to be %s, got %q", path, issueType, issues[path])
+		}
+	}
+	if audit.CheckedBlobs != 4 || audit.CheckedFiles != 4 {
+		t.Errorf("Unexpected counts %d blobs, %d files", audit.CheckedBlobs, audit.CheckedFiles)
+	}
+
+	orphans := t.TempDir()
+	audit.moveOrphans(orphans)
+	if _, err = os.Stat(filepath.Join(orphans, "b", "orphan")); err != nil {
+		t.Error("Orphan wasn't moved")
+	}
+	if !audit.failed() {
+		t.Error("Audit with missing files should fail")
+	}
+}
+
+func Test_getBlobStoreWrongOptions(t *testing.T) {
+	if _, err := getBlobStore(attachmentsMigrateCmd, map[string]string{}, "to", "ftp"); util.ErrorKindOf(err) != util.ConfigError {
+		t.Errorf("Expected a config error for an unknown storage, was %v", err)
+	}
+	if _, err := getBlobStore(attachmentsMigrateCmd, map[string]string{}, "to", "s3"); util.ErrorKindOf(err) != util.ConfigError {
+		t.Errorf("Expected a config error for s3 without --s3, was %v", err)
+	}
+	if store, err := getBlobStore(attachmentsMigrateCmd, map[string]string{}, "to", "db"); err != nil || store == nil {
+		t.Errorf("Expected the db storage, was %v, %v", store, err)
+	}
+}
@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/deskpro/dputils/pkg/compare"
+	"github.com/deskpro/dputils/util"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	compareCmd.Flags().String(
+		"source",
+		"",
+		`
+				The database to compare with: a user:password@host/database URI like --mysql-direct of the
+				restore command, or the path of an SQL dump. Only the schema and row counts of a dump are compared.
+		`,
+	)
+
+	compareCmd.Flags().String(
+		"dest",
+		"config",
+		`
+				The database to check: "config" for the database of this Deskpro instance, or a
+				user:password@host/database URI.
+		`,
+	)
+
+	compareCmd.Flags().String(
+		"checksum",
+		compare.ChecksumNone,
+		`
+				none    - only compare the schema and row counts
+				table   - also compare the CHECKSUM TABLE of every table
+				chunked - also hash the rows in ranges of the primary key, which tells which rows differ
+		`,
+	)
+
+	compareCmd.Flags().Int64(
+		"chunk-size",
+		10000,
+		`
+				How many primary key values are hashed at once with --checksum=chunked.
+		`,
+	)
+
+	compareCmd.Flags().String(
+		"output",
+		outputText,
+		`
+				text - a table with the comparison of every table
+				json - the comparison as a JSON object on stdout, other output is printed to stderr
+		`,
+	)
+
+	addTableFilterFlags(compareCmd)
+
+	rootCmd.AddCommand(compareCmd)
+}
+
+var compareCmd = &cobra.Command{
+	Use:   "compare",
+	Short: "Compare the tables of two Deskpro databases, e.g. after a restore",
+	Long: `
+		Compares the schema and the row count of every table of the --dest database with the --source
+		database or dump, and optionally their checksums. Prints the tables which differ and how.
+
+		Exits with a non-zero code if any table differs.
+	`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		return runCompare(cmd)
+	},
+}
+
+func runCompare(cmd *cobra.Command) error {
+	output, _ := cmd.Flags().GetString("output")
+	if output != outputText && output != outputJson {
+		return util.NewError(util.ConfigError, "Wrong --output option, you may specify either \"text\" or \"json\"", nil)
+	}
+	tables, err := getTableFilter(cmd)
+	if err != nil {
+		return err
+	}
+
+	out := io.Writer(os.Stdout)
+	if output == outputJson {
+		// anything printed while the config is read mustn't break the JSON
+		os.Stdout = os.Stderr
+	}
+
+	opts := compare.Options{Tables: tables, Out: os.Stdout}
+	opts.Checksum, _ = cmd.Flags().GetString("checksum")
+	opts.ChunkSize, _ = cmd.Flags().GetInt64("chunk-size")
+
+	source, _ := cmd.Flags().GetString("source")
+	if source == "" {
+		return util.NewError(util.ConfigError, "You must specify the database or dump to compare with --source", nil)
+	}
+	if info, err := os.Stat(source); err == nil && !info.IsDir() {
+		opts.SourceDump = source
+	} else {
+		if opts.Source, err = connectCompareDatabase("--source", source, util.SourceError); err != nil {
+			return err
+		}
+		defer opts.Source.Conn.Close()
+	}
+
+	dest, _ := cmd.Flags().GetString("dest")
+	if dest == "config" {
+		dpConfig, err := Config.LoadDeskproConfig(cmd)
+		if err != nil {
+			return err
+		}
+		murl := util.GetMysqlUrlFromConfig(dpConfig, "database")
+		conn, err := util.GetMysqlConnection(murl)
+		if err != nil {
+			return util.NewError(util.ConfigError, "Failed to connect to the database of this Deskpro instance", err)
+		}
+		opts.Dest = util.MysqlConn{MysqlUrl: murl, Conn: conn}
+	} else if opts.Dest, err = connectCompareDatabase("--dest", dest, util.ConfigError); err != nil {
+		return err
+	}
+	defer opts.Dest.Conn.Close()
+
+	fmt.Println("==========================================================================================")
+	fmt.Println("Comparing " + describeCompareSource(opts) + " with " + describeMysqlConn(opts.Dest))
+	fmt.Println("==========================================================================================")
+
+	ctx, stop := interruptContext()
+	report, err := compare.Run(ctx, opts)
+	stop()
+	if err != nil {
+		return err
+	}
+
+	if output == outputJson {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		if err = encoder.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		printCompareReport(out, report)
+	}
+
+	if differences := report.Differences(); differences > 0 {
+		return util.NewError(util.GeneralError, fmt.Sprintf("%d of %d tables differ", differences, len(report.Tables)), nil)
+	}
+
+	return nil
+}
+
+// connectCompareDatabase connects to the database given as a URI with the option, a failed connection is an
+// error of the kind
+func connectCompareDatabase(option string, uri string, kind util.ErrorKind) (util.MysqlConn, error) {
+	murl, err := util.GetMysqlUrlFromOption(option, uri)
+	if err != nil {
+		return util.MysqlConn{}, err
+	}
+	conn, err := util.GetMysqlConnection(murl)
+	if err != nil {
+		return util.MysqlConn{}, util.NewError(kind, "Failed to connect to the "+option+" database", err)
+	}
+
+	return util.MysqlConn{MysqlUrl: murl, Conn: conn}, nil
+}
+
+func describeCompareSource(opts compare.Options) string {
+	if opts.SourceDump != "" {
+		return opts.SourceDump
+	}
+
+	return describeMysqlConn(opts.Source)
+}
+
+func describeMysqlConn(conn util.MysqlConn) string {
+	return conn.MysqlUrl.User.Username() + "@" + conn.MysqlUrl.Host + conn.MysqlUrl.Path
+}
+
+// printCompareReport prints the tables which don't match and their differences, then a summary
+func printCompareReport(out io.Writer, report *compare.Report) {
+	differences := report.Differences()
+	if differences == 0 {
+		fmt.Fprintf(out, "All %d tables match\n", len(report.Tables))
+		return
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tTABLE\tSOURCE ROWS\tDEST ROWS\tCHECKSUM")
+	for _, table := range report.Tables {
+		if table.Status == compare.StatusMatch {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n", strings.ToUpper(string(table.Status)), table.Name, table.SourceRows, table.DestRows, table.Checksum)
+	}
+	_ = w.Flush()
+
+	for _, table := range report.Tables {
+		if len(table.Differences) == 0 {
+			continue
+		}
+		fmt.Fprintln(out, "\n"+table.Name+":")
+		for _, difference := range table.Differences {
+			fmt.Fprintln(out, "\t"+difference)
+		}
+	}
+
+	fmt.Fprintf(out, "\n%d of %d tables match\n", len(report.Tables)-differences, len(report.Tables))
+}
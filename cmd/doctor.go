@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/deskpro/dputils/pkg/doctor"
+	"github.com/deskpro/dputils/util"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	doctorCmd.Flags().String(
+		"output",
+		outputText,
+		`
+				text - a table with the status of every check
+				json - the checks as a JSON object on stdout, other output is printed to stderr
+		`,
+	)
+
+	doctorCmd.Flags().String(
+		"min-php-version",
+		"",
+		`
+				Fail the PHP check if PHP is older than this version, e.g. 8.1.
+		`,
+	)
+
+	doctorCmd.Flags().StringSlice(
+		"php-extensions",
+		doctor.DefaultPhpExtensions,
+		`
+				The PHP extensions which must be loaded.
+		`,
+	)
+
+	doctorCmd.Flags().String(
+		"min-free-space",
+		"1G",
+		`
+				Warn if the attachments dir or the --tmpdir has less free disk space, e.g. 20G.
+		`,
+	)
+
+	doctorCmd.Flags().String(
+		"tmpdir",
+		"",
+		`
+				The dir restores download sources to, its free disk space is checked.
+		`,
+	)
+
+	rootCmd.AddCommand(doctorCmd)
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check this server and Deskpro instance have everything the other commands need",
+	Long: `
+		Checks the PHP version and extensions, bin/console, the Deskpro config, every configured database
+		connection (default and database_advanced.*), the mysql and mysqldump binaries, the attachments dir
+		and free disk space, and that var/logs is writable. Prints the status of every check.
+
+		Exits with a non-zero code if any check fails, warnings don't fail the command.
+	`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		return runDoctor(cmd)
+	},
+}
+
+func runDoctor(cmd *cobra.Command) error {
+	output, _ := cmd.Flags().GetString("output")
+	if output != outputText && output != outputJson {
+		return util.NewError(util.ConfigError, "Wrong --output option, you may specify either \"text\" or \"json\"", nil)
+	}
+	freeSpace, _ := cmd.Flags().GetString("min-free-space")
+	minFreeSpace, err := util.ParseSize(freeSpace)
+	if err != nil {
+		return util.NewError(util.ConfigError, "Wrong --min-free-space option", err)
+	}
+
+	out := io.Writer(os.Stdout)
+	if output == outputJson {
+		// anything printed while the config is read mustn't break the JSON
+		os.Stdout = os.Stderr
+	}
+
+	opts := doctor.Options{
+		DeskproPath:  Config.DpPath(),
+		PhpPath:      Config.PhpPath(),
+		MinFreeSpace: minFreeSpace,
+	}
+	opts.MinPhpVersion, _ = cmd.Flags().GetString("min-php-version")
+	opts.PhpExtensions, _ = cmd.Flags().GetStringSlice("php-extensions")
+	opts.TmpDir, _ = cmd.Flags().GetString("tmpdir")
+
+	ctx, stop := interruptContext()
+	report := doctor.Run(ctx, opts)
+	stop()
+
+	if output == outputJson {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		if err = encoder.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		printDoctorReport(out, report)
+	}
+
+	if failed := report.Failed(); failed > 0 {
+		return util.NewError(util.ConfigError, fmt.Sprintf("%d of %d checks failed", failed, len(report.Checks)), nil)
+	}
+
+	return nil
+}
+
+func printDoctorReport(out io.Writer, report *doctor.Report) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tCHECK\tDETAIL")
+	for _, check := range report.Checks {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", strings.ToUpper(string(check.Status)), check.Name, check.Detail)
+	}
+	_ = w.Flush()
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/deskpro/dputils/util"
+	"github.com/spf13/cobra"
+)
+
+func addDumpFlags(cmd *cobra.Command) {
+	cmd.Flags().String(
+		"engine",
+		util.EngineMysqldump,
+		`
+			How to dump and import databases:
+
+			mysqldump - use mysqldump and mysql binaries configured in Deskpro (paths.mysqldump_path
+			            and paths.mysql_path)
+			native    - use the built-in engine, which doesn't need MySQL client binaries on this server
+		`,
+	)
+
+	cmd.Flags().String(
+		"dump-profile",
+		util.ProfileConsistent,
+		`
+			Options used to dump databases:
+
+			consistent - dump all tables from a single InnoDB snapshot (--single-transaction) so a live
+			             helpdesk can be dumped safely. Routines, triggers and events are included, binary
+			             data is dumped as hex and, if binary logging is enabled, the binary log coordinates
+			             are recorded in the dump and in the backup manifest (--source-data=2). Recording the
+			             coordinates requires the RELOAD and REPLICATION CLIENT privileges, without them
+			             they're skipped with a warning.
+			legacy     - the plain mysqldump options used by older versions of dputils
+		`,
+	)
+
+	cmd.Flags().String(
+		"mysqldump-opts",
+		"",
+		`
+			Extra options passed to mysqldump, e.g. --mysqldump-opts="--set-gtid-purged=OFF --max-allowed-packet=1G".
+			Only used with --engine=mysqldump.
+		`,
+	)
+
+	addTableFilterFlags(cmd)
+}
+
+func getDumpOptions(cmd *cobra.Command) (util.DumpOptions, error) {
+	engine, _ := cmd.Flags().GetString("engine")
+	if engine != util.EngineMysqldump && engine != util.EngineNative {
+		return util.DumpOptions{}, util.NewError(util.ConfigError, "Wrong --engine option, you may specify either \"mysqldump\" or \"native\"", nil)
+	}
+
+	profile, _ := cmd.Flags().GetString("dump-profile")
+	if profile != util.ProfileConsistent && profile != util.ProfileLegacy {
+		return util.DumpOptions{}, util.NewError(util.ConfigError, "Wrong --dump-profile option, you may specify either \"consistent\" or \"legacy\"", nil)
+	}
+
+	extraOpts, _ := cmd.Flags().GetString("mysqldump-opts")
+	if extraOpts != "" && engine != util.EngineMysqldump {
+		return util.DumpOptions{}, util.NewError(util.ConfigError, "--mysqldump-opts can only be used with --engine=mysqldump", nil)
+	}
+
+	tables, err := getTableFilter(cmd)
+	if err != nil {
+		return util.DumpOptions{}, err
+	}
+
+	return util.DumpOptions{Engine: engine, Profile: profile, ExtraArgs: strings.Fields(extraOpts), Tables: tables}, nil
+}
+
+// addTableFilterFlags adds --include-tables and --exclude-tables, they're read with getTableFilter
+func addTableFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSlice(
+		"include-tables",
+		nil,
+		`
+			Only dump, restore or compare the tables matching these glob patterns, e.g. --include-tables="ticket*,people".
+			When restoring, only the selected tables are dropped and replaced, the other tables are left as they are.
+		`,
+	)
+
+	cmd.Flags().StringSlice(
+		"exclude-tables",
+		nil,
+		`
+			Skip the tables matching these glob patterns, e.g. --exclude-tables="ticket_search*,*_log".
+		`,
+	)
+}
+
+func getTableFilter(cmd *cobra.Command) (util.TableFilter, error) {
+	var tables util.TableFilter
+	tables.Include, _ = cmd.Flags().GetStringSlice("include-tables")
+	tables.Exclude, _ = cmd.Flags().GetStringSlice("exclude-tables")
+	if err := tables.Validate(); err != nil {
+		return util.TableFilter{}, util.NewError(util.ConfigError, "Wrong --include-tables or --exclude-tables option", err)
+	}
+
+	return tables, nil
+}
@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+	"github.com/deskpro/dputils/util"
+	"github.com/spf13/cobra"
+)
+
+const (
+	outputText = "text"
+	outputJson = "json"
+)
+
+// event is a line of the --output=json stream
+type event struct {
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	Command    string    `json:"command"`
+	Phase      string    `json:"phase,omitempty"`
+	Code       string    `json:"code,omitempty"`
+	Message    string    `json:"message,omitempty"`
+	Files      int64     `json:"files,omitempty"`
+	Bytes      int64     `json:"bytes,omitempty"`
+	TotalFiles int64     `json:"total_files,omitempty"`
+	TotalBytes int64     `json:"total_bytes,omitempty"`
+}
+
+// eventStream writes the events of a backup or restore for orchestration tools. With --output=json the events
+// are written to stdout and everything printed for humans is moved to stderr, otherwise events are discarded.
+type eventStream struct {
+	mu      sync.Mutex
+	out     io.Writer
+	command string
+	phase   string
+}
+
+var events = &eventStream{}
+
+func addOutputFlag(cmd *cobra.Command) {
+	cmd.Flags().String(
+		"output",
+		outputText,
+		`
+			text - human readable progress
+			json - one JSON event per line on stdout (phase_started, phase_finished, progress, warning and error),
+			       human readable progress is printed to stderr
+		`,
+	)
+}
+
+// startEventStream switches to the JSON event stream if --output=json is set
+func startEventStream(cmd *cobra.Command) error {
+	output, _ := cmd.Flags().GetString("output")
+	if output != outputText && output != outputJson {
+		return util.NewError(util.ConfigError, "Wrong --output option, you may specify either \"text\" or \"json\"", nil)
+	}
+	if output == outputText {
+		return nil
+	}
+
+	events.mu.Lock()
+	events.out = os.Stdout
+	events.command = cmd.Name()
+	events.mu.Unlock()
+
+	os.Stdout = os.Stderr
+	// nobody answers prompts when the output is read by a program
+	noInput = true
+	util.NoInput = true
+
+	return nil
+}
+
+func (s *eventStream) emit(e event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.out == nil {
+		return
+	}
+
+	e.Time = time.Now().UTC()
+	e.Command = s.command
+	if e.Phase == "" {
+		e.Phase = s.phase
+	}
+	data, _ := json.Marshal(e)
+	_, _ = s.out.Write(append(data, '\n'))
+}
+
+func (s *eventStream) phaseStarted(phase string) {
+	s.mu.Lock()
+	s.phase = phase
+	s.mu.Unlock()
+
+	s.emit(event{Event: "phase_started", Phase: phase})
+}
+
+func (s *eventStream) phaseFinished(phase string) {
+	s.emit(event{Event: "phase_finished", Phase: phase})
+
+	s.mu.Lock()
+	s.phase = ""
+	s.mu.Unlock()
+}
+
+func (s *eventStream) progress(files int64, bytes int64, totalFiles int64, totalBytes int64) {
+	s.emit(event{Event: "progress", Files: files, Bytes: bytes, TotalFiles: totalFiles, TotalBytes: totalBytes})
+}
+
+func (s *eventStream) warning(message string) {
+	s.emit(event{Event: "warning", Message: message})
+}
+
+// error reports a failure, the command either stops after it or continues without the failed step
+func (s *eventStream) error(code string, message string) {
+	s.emit(event{Event: "error", Code: code, Message: message})
+}
+
+// failed reports the error a command failed with, the code of the event is the kind of the error
+func (s *eventStream) failed(err error) {
+	s.error(util.ErrorKindOf(err).String(), err.Error())
+}
+
+// progressHook returns the Progress callback for the backup and restore options. The events are forwarded into
+// the stream and the progress of attachments is drawn as a progress bar, finish stops the bar.
+func (s *eventStream) progressHook() (hook func(e util.Event), finish func()) {
+	var (
+		mu  sync.Mutex
+		bar *pb.ProgressBar
+	)
+
+	finish = func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if bar != nil {
+			bar.Finish()
+			bar = nil
+		}
+	}
+
+	hook = func(e util.Event) {
+		switch e.Type {
+		case util.EventPhaseStarted:
+			finish()
+			s.phaseStarted(e.Phase)
+		case util.EventPhaseFinished:
+			finish()
+			s.phaseFinished(e.Phase)
+		case util.EventWarning:
+			s.warning(e.Message)
+		case util.EventProgress:
+			s.emit(event{Event: "progress", Phase: e.Phase, Files: e.Files, Bytes: e.Bytes, TotalFiles: e.TotalFiles, TotalBytes: e.TotalBytes})
+
+			mu.Lock()
+			if bar == nil {
+				bar = pb.ProgressBarTemplate(`{{ blue "Attachments:" }} {{counters . }} {{bar . | green}} {{string . "bytes" | blue }}`).Start64(e.TotalFiles)
+			}
+			bar.SetCurrent(e.Files)
+			bar.Set("bytes", fmt.Sprintf("%d bytes", e.Bytes))
+			mu.Unlock()
+		}
+	}
+
+	return hook, finish
+}
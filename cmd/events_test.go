@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/deskpro/dputils/util"
+)
+
+func TestEventStream(t *testing.T) {
+	out := &bytes.Buffer{}
+	stream := &eventStream{out: out, command: "restore"}
+
+	stream.phaseStarted("attachments")
+	stream.progress(10, 2048, 100, 20480)
+	stream.warning("3 attachments failed to copy")
+	stream.phaseFinished("attachments")
+	stream.failed(util.NewError(util.UpgradeError, "Deskpro upgrade failed", errors.New("exit status 1")))
+
+	var got []event
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		var e event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("Event %s isn't valid JSON: %s", scanner.Text(), err)
+		}
+		got = append(got, e)
+	}
+
+	expected := []event{
+		{Event: "phase_started", Command: "restore", Phase: "attachments"},
+		{Event: "progress", Command: "restore", Phase: "attachments", Files: 10, Bytes: 2048, TotalFiles: 100, TotalBytes: 20480},
+		{Event: "warning", Command: "restore", Phase: "attachments", Message: "3 attachments failed to copy"},
+		{Event: "phase_finished", Command: "restore", Phase: "attachments"},
+		{Event: "error", Command: "restore", Code: "upgrade_error", Message: "Deskpro upgrade failed: exit status 1"},
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d events, got %d", len(expected), len(got))
+	}
+	for i := range expected {
+		got[i].Time = expected[i].Time
+		if got[i] != expected[i] {
+			t.Errorf("Expected event %+v, got %+v", expected[i], got[i])
+		}
+	}
+}
+
+func TestEventStreamProgressHook(t *testing.T) {
+	out := &bytes.Buffer{}
+	stream := &eventStream{out: out, command: "backup"}
+	hook, finish := stream.progressHook()
+
+	hook(util.Event{Type: util.EventPhaseStarted, Phase: "attachments"})
+	hook(util.Event{Type: util.EventProgress, Phase: "attachments", Files: 5, Bytes: 100})
+	hook(util.Event{Type: util.EventPhaseFinished, Phase: "attachments"})
+	finish()
+
+	var got []string
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		var e event
+		_ = json.Unmarshal(scanner.Bytes(), &e)
+		got = append(got, e.Event+":"+e.Phase)
+	}
+
+	expected := []string{"phase_started:attachments", "progress:attachments", "phase_finished:attachments"}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected events %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Expected events %v, got %v", expected, got)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// interruptContext returns a context which is canceled on the first Ctrl-C or SIGTERM, so the backup or restore
+// can stop its dumps and copies and clean up. The signal handler is removed after the first signal, a second
+// Ctrl-C quits immediately. stop must be called once the command is done.
+func interruptContext() (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-signals:
+			signal.Stop(signals)
+			log.Warning("Received ", sig, ", stopping")
+			fmt.Fprintln(os.Stderr, "Interrupted, stopping... Press Ctrl-C again to quit immediately")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
@@ -1,29 +1,17 @@
 package cmd
 
 import (
-	"bytes"
-	"crypto/md5"
-	"database/sql"
 	"fmt"
+	"github.com/deskpro/dputils/pkg/restore"
 	"github.com/deskpro/dputils/util"
-	"github.com/hashicorp/go-getter"
 	"github.com/manifoldco/promptui"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	"io"
-	"io/ioutil"
 	"net/url"
 	"os"
-	"os/exec"
-	"path/filepath"
-	"regexp"
 	"strconv"
-	"strings"
-	"sync"
-	"time"
 )
 
-import urlhelper "github.com/hashicorp/go-getter/helper/url"
 import _ "github.com/go-sql-driver/mysql"
 
 func init() {
@@ -44,6 +32,22 @@ func init() {
 			 Path to a ZIP containing a database.sql file and an attachments/ folder.
 			 You generate this from any existing Deskpro server by using the 'dputils backup' command.
 			 This can be a filesystem path, a HTTP URL, or a S3 URL.
+
+			 For a backup split with --volume-size, provide the first volume (deskpro-backup.DATE.001.zip),
+			 the other volumes are read from the same place.
+		`,
+	)
+
+	restoreCmd.Flags().StringArray(
+		"incremental",
+		[]string{},
+		`
+			 Path to an incremental backup archive created with 'dputils backup --incremental-since'.
+			 Used together with --full-backup pointing to the base archive. Repeat the option to apply
+			 a chain of incremental archives, in the order they were created.
+
+			 Attachments from every archive are restored, the database is restored from the most
+			 recent archive which contains a database dump.
 		`,
 	)
 
@@ -132,6 +136,24 @@ func init() {
 		`,
 	)
 
+	restoreCmd.Flags().Int(
+		"attachment-workers",
+		4,
+		`
+			How many attachments are copied at the same time. Increase it for remote sources like HTTP or S3,
+			decrease it if the source server can't handle the load.
+		`,
+	)
+
+	restoreCmd.Flags().Int(
+		"attachment-retries",
+		3,
+		`
+			How many times to retry copying an attachment before it's reported as failed. The delay between
+			retries doubles every time, starting with half a second.
+		`,
+	)
+
 	restoreCmd.Flags().String(
 		"mysql-dump",
 		"",
@@ -157,6 +179,25 @@ func init() {
 		`,
 	)
 
+	restoreCmd.Flags().String(
+		"until",
+		"",
+		`
+			Point-in-time recovery: after the database is restored from the --full-backup, replay the binary logs
+			archived with 'dputils binlog archive' up to this local time, e.g. "2026-10-18 09:30:00", or up to and
+			including the transaction with this GTID, e.g. "3e11fa47-71ca-11e1-9e33-c80aa9429562:23". The backup
+			must be created with --dump-profile=consistent on a server with binary logging enabled.
+		`,
+	)
+
+	restoreCmd.Flags().String(
+		"binlogs",
+		"",
+		`
+			The dir the binary logs were archived to for --until, a local dir or an s3:// or sftp:// dir.
+		`,
+	)
+
 	restoreCmd.Flags().String(
 		"tmpdir",
 		"",
@@ -166,6 +207,38 @@ func init() {
 		`,
 	)
 
+	restoreCmd.Flags().Bool(
+		"plan",
+		false,
+		`
+			Validate the options and print what the restore would do without changing anything: the tables which
+			would be dropped, the size of the source database, how many attachments would be copied, which
+			additional databases would be restored and the steps run after the restore. Backup archives and dumps
+			are still downloaded into the --tmpdir to validate them, they're removed after the plan is printed.
+		`,
+	)
+
+	restoreCmd.Flags().Bool(
+		"safety-snapshot",
+		false,
+		`
+			Before anything is changed, dump the databases of this Deskpro instance and copy its config files into
+			the --tmpdir. If the restore or the upgrade after it fails, put everything back with
+			'dputils rollback --snapshot <id>'. Make sure the --tmpdir has enough space for the dumps.
+		`,
+	)
+
+	restoreCmd.Flags().Bool(
+		"resume",
+		false,
+		`
+			Continue a restore which was interrupted, e.g. by a network failure or a failed import. Completed
+			phases (downloading the sources, restoring databases) are skipped and attachments are copied from
+			the last copied attachment. The progress is saved in a checkpoint file in the --tmpdir, so use the
+			same --tmpdir and options as for the interrupted restore.
+		`,
+	)
+
 	restoreCmd.Flags().Bool(
 		"skip-upgrade",
 		false,
@@ -190,6 +263,29 @@ func init() {
 		`,
 	)
 
+	restoreCmd.Flags().Bool(
+		"sanitize",
+		false,
+		`
+			Replace personal data and secrets of the restored database with fake values, see 'dputils sanitize'.
+			Use it together with --as-test-instance to create a test instance from a production backup.
+		`,
+	)
+
+	restoreCmd.Flags().Bool(
+		"force",
+		false,
+		`
+			Start the restore even if the downloaded and extracted sources, the attachments and the safety
+			snapshot don't seem to fit on the disks of the --tmpdir and the attachments dir.
+		`,
+	)
+
+	addSanitizeFlags(restoreCmd)
+
+	addDumpFlags(restoreCmd)
+	addOutputFlag(restoreCmd)
+
 	rootCmd.AddCommand(restoreCmd)
 }
 
@@ -202,149 +298,124 @@ var restoreCmd = &cobra.Command{
 
 		Any option that accepts a remote URI supports the following protocols: http, https, s3.
 	`,
-	Run: func(cmd *cobra.Command, args []string) {
-		tmpdir, _ := cmd.Flags().GetString("tmpdir")
-		if len(tmpdir) < 1 {
-			tmpdir = os.TempDir()
-		}
-
-		log.Info("tmpdir: ", tmpdir)
-
-		isInteractive, _ := cmd.Flags().GetBool("interactive")
-		if isInteractive {
-			interactiveGatherOptions(cmd)
-		}
-
-		dpConfig := Config.ValidateDeskproConfig(cmd)
-		destinationMysqlConn := validateDeskpro("database", dpConfig)
-
-		var (
-			moveAttachments bool
-			attachUri string
-			dbDumpLocal string
-			sourceMysqlConn util.MysqlConn
-		)
-
-		fullBackup, backupDir  := checkFullBackup(cmd, tmpdir)
-
-		if !fullBackup {
-			// this one needed to insure we have at least 1 default source connection or dump
-			dbDumpLocal, sourceMysqlConn = validateDeskproSource(cmd, tmpdir)
-			attachUri, moveAttachments = validateAttachments(cmd, sourceMysqlConn.Conn, tmpdir)
-		} else {
-			moveAttachments = true
-			attachUri = transformAttachUri(filepath.Join(backupDir, "attachments"))
-			dbDumpLocal = getFullBackupDump(backupDir, "database")
-		}
-
-		restoreDatabase(destinationMysqlConn, sourceMysqlConn, dpConfig, dbDumpLocal, tmpdir)
-
-		if !fullBackup {
-			// now let's check we have additional connections like audit, system or voice
-			restoreDatabaseAdvanced(cmd, dpConfig, "audit")
-			restoreDatabaseAdvanced(cmd, dpConfig, "voice")
-			restoreDatabaseAdvanced(cmd, dpConfig, "system")
-		} else {
-			restoreDatabaseAdvancedDump(backupDir, dpConfig, "audit", tmpdir)
-			restoreDatabaseAdvancedDump(backupDir, dpConfig, "voice", tmpdir)
-			restoreDatabaseAdvancedDump(backupDir, dpConfig, "system", tmpdir)
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		if err := startEventStream(cmd); err != nil {
+			return err
 		}
 
-		lastId := getLastBlobId(destinationMysqlConn.Conn)
-		restoreAttachments(destinationMysqlConn, attachUri, moveAttachments, lastId)
-
-		doUpgrade(cmd)
-		doElasticReset(cmd, destinationMysqlConn)
-		markAsTestInstance(cmd, destinationMysqlConn)
-
-		fmt.Println("==========================================================================================")
-		fmt.Println("Finished restoring your Deskpro instance. Thank you for using Deskpro.")
-		fmt.Println("==========================================================================================")
+		return runRestore(cmd)
 	},
 }
 
-func getFullBackupDump(backupDir string, fileName string) string {
-	dir, _ := filepath.Abs(backupDir)
-	files, err := ioutil.ReadDir(dir)
-	if err != nil {
-		log.Warning("Failed to get full backup dump file ", err)
-		fmt.Println("Failed to get full backup dump file")
-		fmt.Println(err)
-		os.Exit(1)
+// runRestore gathers the restore options from the flags, or interactively with --interactive, and runs the restore
+func runRestore(cmd *cobra.Command) error {
+	isInteractive, _ := cmd.Flags().GetBool("interactive")
+	if isInteractive && noInput {
+		return util.NewError(util.ConfigError, "--interactive can't be used together with --no-input", nil)
 	}
-
-	for _, f := range files {
-		if strings.HasPrefix(f.Name(), fileName + ".") {
-			dumpPath := filepath.Join(dir, "dump" + fmt.Sprintf("%d", time.Now().Unix()) + ".sql")
-			err := getter.GetFile(dumpPath, filepath.Join(dir, f.Name()))
-			if err != nil {
-				log.Warning("Failed to get full backup dump file ", err)
-				fmt.Println("Failed to get full backup dump file")
-				fmt.Println(err)
-				os.Exit(1)
-			}
-
-			return dumpPath
+	if isInteractive {
+		if err := interactiveGatherOptions(cmd); err != nil {
+			return err
 		}
 	}
 
-	return ""
-}
-
-func checkFullBackup(cmd *cobra.Command, tmpdir string) (bool, string) {
+	opts, err := getRestoreOptions(cmd)
+	if err != nil {
+		return err
+	}
 
-	var (
-		backupUri string
-		err error
-	)
-	backupUri, _ = cmd.Flags().GetString("full-backup")
-	if backupUri != "" {
-		_, err = url.ParseRequestURI(backupUri)
-		if err != nil {
-			// not an URL with a scheme, so assume a filesystem path
-			if backupUri, err = filepath.Abs(backupUri); err != nil {
-				log.Error("Can't find a full path to dump", backupUri)
-				fmt.Println("Backup path is wrong, please check the path for the backup archive carefully")
-				fmt.Println(err)
-			}
-		}
+	log.Info("tmpdir: ", opts.TmpDir)
 
-		fmt.Println("==========================================================================================")
-		fmt.Println("Detected a full backup flag. Restoring from the full backup archive")
-		fmt.Println("==========================================================================================")
-		fakename := "backup_archive" + fmt.Sprintf("%d", time.Now().Unix())
-		err := getter.GetAny(filepath.Join(tmpdir, fakename), backupUri)
-		if err != nil {
-			log.Warning("Failed to get full backup archive ", err)
-			fmt.Println("Failed to get full backup archive")
-			fmt.Println("If using an URL, remember to include the scheme (http:// or https://)")
-			fmt.Println(err)
-			os.Exit(1)
-		}
-		if _, err := os.Stat(filepath.Join(tmpdir, fakename, "attachments")); os.IsNotExist(err) {
-			log.Error("can't find attachments subdir backup archive", err)
-			fmt.Println("We can't find attachments subdir in your backup archive")
-			os.Exit(1)
-		}
+	var finish func()
+	opts.Progress, finish = events.progressHook()
+	ctx, stop := interruptContext()
+	plan, _ := cmd.Flags().GetBool("plan")
+	if plan {
+		err = restore.Plan(ctx, opts)
+	} else {
+		err = restore.Run(ctx, opts)
+	}
+	stop()
+	finish()
+	if err != nil || plan {
+		return err
+	}
 
-		dumpExists := false
-		files, err := ioutil.ReadDir(filepath.Join(tmpdir, fakename))
-		for _, f := range files {
-			if strings.HasPrefix(f.Name(), "database" + ".") {
-				dumpExists = true
-			}
-		}
+	fmt.Println("==========================================================================================")
+	fmt.Println("Finished restoring your Deskpro instance. Thank you for using Deskpro.")
+	fmt.Println("==========================================================================================")
 
-		if !dumpExists {
-			log.Error("can't find database dump file in backup archive", err)
-			fmt.Println("We can't find database dump file in your backup archive")
-			os.Exit(1)
-		}
+	return nil
+}
 
-		return true, filepath.Join(tmpdir, fakename)
+func getRestoreOptions(cmd *cobra.Command) (restore.Options, error) {
+	dumpOpts, err := getDumpOptions(cmd)
+	if err != nil {
+		return restore.Options{}, err
 	}
-
-	return false, ""
+	workers, _ := cmd.Flags().GetInt("attachment-workers")
+	retries, _ := cmd.Flags().GetInt("attachment-retries")
+	if workers < 1 || retries < 0 {
+		return restore.Options{}, util.NewError(util.ConfigError, "--attachment-workers must be at least 1 and --attachment-retries can't be negative", nil)
+	}
+	sanitizer, sanitizeRules, err := getRestoreSanitizer(cmd)
+	if err != nil {
+		return restore.Options{}, err
+	}
+	dpConfig, err := Config.LoadDeskproConfig(cmd)
+	if err != nil {
+		return restore.Options{}, err
+	}
+
+	opts := restore.Options{
+		DeskproPath:       Config.DpPath(),
+		PhpPath:           Config.PhpPath(),
+		DeskproConfig:     dpConfig,
+		SourceDatabases:   map[string]url.URL{},
+		DumpOptions:       dumpOpts,
+		AttachmentWorkers: workers,
+		AttachmentRetries: retries,
+		Sanitizer:         sanitizer,
+		SanitizeRules:     sanitizeRules,
+		Out:               os.Stdout,
+	}
+	opts.TmpDir, _ = cmd.Flags().GetString("tmpdir")
+	opts.FullBackup, _ = cmd.Flags().GetString("full-backup")
+	opts.Incrementals, _ = cmd.Flags().GetStringArray("incremental")
+	opts.Dump, _ = cmd.Flags().GetString("mysql-dump")
+	opts.Attachments, _ = cmd.Flags().GetString("attachments")
+	opts.AttachmentsArchive, _ = cmd.Flags().GetBool("attachments-archive")
+	opts.MoveAttachments, _ = cmd.Flags().GetBool("move-attachments")
+	opts.Resume, _ = cmd.Flags().GetBool("resume")
+	opts.SafetySnapshot, _ = cmd.Flags().GetBool("safety-snapshot")
+	opts.SkipUpgrade, _ = cmd.Flags().GetBool("skip-upgrade")
+	opts.ReindexElastic, _ = cmd.Flags().GetBool("reindex-elastic")
+	opts.AsTestInstance, _ = cmd.Flags().GetBool("as-test-instance")
+	opts.Force, _ = cmd.Flags().GetBool("force")
+	opts.Binlogs, _ = cmd.Flags().GetString("binlogs")
+	if until, _ := cmd.Flags().GetString("until"); until != "" {
+		if opts.Until, err = util.ParseBinlogStop(until); err != nil {
+			return restore.Options{}, util.NewError(util.ConfigError, "Wrong --until option", err)
+		}
+	}
+
+	for dbType, flag := range map[string]string{
+		"default": "mysql-direct",
+		"audit":   "mysql-direct-audit",
+		"voice":   "mysql-direct-voice",
+		"system":  "mysql-direct-system",
+	} {
+		if !cmd.Flags().Changed(flag) {
+			continue
+		}
+		mysqlUri, _ := cmd.Flags().GetString(flag)
+		if opts.SourceDatabases[dbType], err = util.GetMysqlUrlFromUriString(mysqlUri); err != nil {
+			return restore.Options{}, err
+		}
+	}
+
+	return opts, nil
 }
 
 type menuitem struct {
@@ -353,7 +424,7 @@ type menuitem struct {
 	Help string
 }
 
-func interactiveGatherOptions(cmd *cobra.Command) {
+func interactiveGatherOptions(cmd *cobra.Command) error {
 
 	log.Info("Interactive mode")
 	attachUrl := ""
@@ -381,9 +452,7 @@ func interactiveGatherOptions(cmd *cobra.Command) {
 	}).Run()
 
 	if err != nil {
-		log.Error("restore method prompt failed: ", err)
-		fmt.Printf("Invalid input: %v\n", err)
-		os.Exit(1)
+		return util.NewError(util.ConfigError, "Invalid input", err)
 	}
 
 	restoreMethod := restoreOptions[restoreMethodIdx].Id
@@ -394,7 +463,7 @@ func interactiveGatherOptions(cmd *cobra.Command) {
 	case "direct":
 		mysqlUri, err := interactivePromptMysqlUri()
 		if err != nil {
-			os.Exit(1)
+			return err
 		}
 		_ = cmd.Flags().Set("mysql-direct", mysqlUri)
 
@@ -404,8 +473,7 @@ func interactiveGatherOptions(cmd *cobra.Command) {
 		}).Run()
 
 		if err != nil {
-			fmt.Printf("Invalid input: %v\n", err)
-			os.Exit(1)
+			return util.NewError(util.ConfigError, "Invalid input", err)
 		}
 
 		log.Info("dump url: ", dumpUrl)
@@ -417,8 +485,7 @@ func interactiveGatherOptions(cmd *cobra.Command) {
 		}).Run()
 
 		if err != nil {
-			fmt.Printf("Invalid input: %v\n", err)
-			os.Exit(1)
+			return util.NewError(util.ConfigError, "Invalid input", err)
 		}
 
 		log.Info("backup url: ", backupUrl)
@@ -439,9 +506,7 @@ func interactiveGatherOptions(cmd *cobra.Command) {
 		}).Run()
 
 		if err != nil {
-			log.Error("attach method prompt failed: ", err)
-			fmt.Printf("Invalid input: %v\n", err)
-			return
+			return util.NewError(util.ConfigError, "Invalid input", err)
 		}
 
 		attachMethod := attachOptions[attachMethodIdx].Id
@@ -455,8 +520,7 @@ func interactiveGatherOptions(cmd *cobra.Command) {
 			}).Run()
 
 			if err != nil {
-				fmt.Printf("Invalid input: %v\n", err)
-				os.Exit(1)
+				return util.NewError(util.ConfigError, "Invalid input", err)
 			}
 
 			log.Info("attachments-archive url: ", attachUrl)
@@ -468,8 +532,7 @@ func interactiveGatherOptions(cmd *cobra.Command) {
 			}).Run()
 
 			if err != nil {
-				fmt.Printf("Invalid input: %v\n", err)
-				os.Exit(1)
+				return util.NewError(util.ConfigError, "Invalid input", err)
 			}
 
 			log.Info("attachments url: ", attachUrl)
@@ -480,6 +543,8 @@ func interactiveGatherOptions(cmd *cobra.Command) {
 			_ = cmd.Flags().Set("attachments", "none")
 		}
 	}
+
+	return nil
 }
 
 func interactivePromptMysqlUri() (string, error) {
@@ -566,822 +631,26 @@ func interactivePromptMysqlUri() (string, error) {
 	log.Error("interactivePromptMysqlUri MySQL URI: ", mysqlUriLog)
 	fmt.Println("Testing connection...")
 
-	mysqlUrl := util.GetMysqlUrlFromUriString(mysqlUri)
-	conn, err := util.GetMysqlConnection(mysqlUrl)
-
+	mysqlUrl, err := util.GetMysqlUrlFromUriString(mysqlUri)
 	if err != nil {
-		log.Error("interactivePromptMysqlUri DB conn failed: ", err)
-		fmt.Println("Failed to connect to remote database: ", err)
 		return "", err
 	}
-	fmt.Println("\tOK")
-	_ = conn.Close()
-
-	return mysqlUri, nil
-}
-
-func restoreAttachments(destinationMysqlConn util.MysqlConn, attachUri string, moveAttachments bool, lastId int64) {
-	realAttachPath := filepath.Join(Config.DpPath(), "attachments")
-	if attachUri != "none" {
-		fmt.Println("==========================================================================================")
-		fmt.Println("Restore Attachments")
-		fmt.Println("==========================================================================================")
-
-		var (
-			err error
-			nextStartId int64 = 1
-			batch []blobrec
-			wg = new(sync.WaitGroup)
-		)
-
-		for nextStartId < lastId {
-
-			fmt.Println("Batch starting ", nextStartId, "...")
-			batch = getNextBlobBatch(destinationMysqlConn.Conn, nextStartId)
-			if batch != nil {
-				b := batch[len(batch)-1]
-				nextStartId = b.id
-				wg.Add(1)
-				go func(batch []blobrec) {
-					defer wg.Done()
-					for _, blob := range batch {
-
-						blobPath := strings.Replace(attachUri, "%PATH%", blob.path, 1)
-						targetPath := filepath.Join(realAttachPath, filepath.FromSlash(blob.path))
-						doSkip := false
-
-						// already exists, check hash
-						if _, err := os.Stat(targetPath); !os.IsNotExist(err) {
-							doSkip = compareFileHash(targetPath, blob.hash)
-						}
-
-						if !doSkip {
-							if moveAttachments {
-								if _, err := os.Stat(filepath.Dir(targetPath)); os.IsNotExist(err) {
-									err := os.MkdirAll(filepath.Dir(targetPath), 0755)
-									if err != nil {
-										fmt.Println("Failed to create dir for blob: ", blobPath)
-										continue
-									}
-								}
-								err = os.Rename(
-									blobPath,
-									targetPath,
-								)
-							} else {
-								err = getter.GetFile(
-									targetPath,
-									blobPath,
-								)
-							}
-						}
-
-						if err != nil {
-							fmt.Println("Failed to download blob: ", blobPath)
-						}
-					}
-				}(batch)
-			}
-		}
-		wg.Wait()
-
-		fmt.Println("Done all blobs")
-	}
-}
-
-func restoreDatabaseAdvancedDump(backupDir string, dpConfig map[string]string, dbType string, tmpdir string) {
-
-	var prefix string
-	prefix = "database_advanced." + dbType
-	dbDumpLocal := getFullBackupDump(backupDir, "database_" + dbType)
-
-	if len(dbDumpLocal) > 1 {
-		fmt.Println("Trying to restore database from advanced dump: " + dbType)
-		destinationAdvancedMysqlUrl := util.GetMysqlUrlFromConfig(dpConfig, prefix)
-		if destinationAdvancedMysqlUrl.User.Username() == "" {
-			log.Error("No connection config for database: " + dbType)
-			fmt.Println("No connection config for database")
-			os.Exit(1)
-		}
-		destinationAdvancedMysqlConn, err := util.GetMysqlConnectionFromConfig(dpConfig, prefix)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-		destinationMysqlConn := util.MysqlConn{destinationAdvancedMysqlUrl, destinationAdvancedMysqlConn}
-
-		restoreDatabase(destinationMysqlConn, util.MysqlConn{}, dpConfig, dbDumpLocal, tmpdir)
-	}
-}
-
-func restoreDatabaseAdvanced(cmd *cobra.Command, dpConfig map[string]string, dbType string) {
-
-	var (
-		flag string
-		prefix string
-	)
-	flag = "mysql-direct-" + dbType
-
-	if cmd.Flags().Changed(flag) {
-		prefix = "database_advanced." + dbType
-
-		fmt.Println("Trying to restore database from advanced config: " + dbType)
-
-		advancedSourceConnection := validateDeskproSourceDirect(cmd, flag)
-		destinationAdvancedMysqlUrl := util.GetMysqlUrlFromConfig(dpConfig, prefix)
-		destinationAdvancedMysqlConn, err := util.GetMysqlConnectionFromConfig(dpConfig, prefix)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-		destinationMysqlConn := util.MysqlConn{destinationAdvancedMysqlUrl, destinationAdvancedMysqlConn}
-		restoreDatabase(destinationMysqlConn, advancedSourceConnection, dpConfig, "", "")
-	}
-}
-
-func markAsTestInstance(cmd *cobra.Command, destinationMysqlConn util.MysqlConn) {
-	asTestInstance, _ := cmd.Flags().GetBool("as-test-instance")
-	if asTestInstance {
-		fmt.Println("==========================================================================================")
-		fmt.Println("Marking your new Deskpro instance as test instance (email accounts)")
-		fmt.Println("==========================================================================================")
-		fmt.Println("Disabling email accounts")
-		_, err := destinationMysqlConn.Conn.Exec("UPDATE `email_accounts` SET `is_enabled` = 0")
-		if err != nil {
-			fmt.Println("\tFailed to disable accounts")
-		} else {
-			fmt.Println("\tOK")
-		}
-		fmt.Println("Disable url corrections and outgoing emails")
-
-		var re = regexp.MustCompile(`(\$SETTINGS\['disable_url_corrections'\]\s*=)\s*(true|false)(;)`)
-
-		configPath := filepath.Join(Config.DpPath(), "config", "advanced", "config.settings.php")
-
-		bytesRead, err := ioutil.ReadFile(configPath)
-		if err != nil {
-			fmt.Println("\tCan't read config file.")
-			return
-		}
-
-
-		s := string(bytesRead)
-
-		if strings.Contains(s, "disable_url_corrections") && strings.Contains(s, "disable_outgoing_email") {
-			s = re.ReplaceAllString(s, "$1 true$3")
-
-			re = regexp.MustCompile(`(\$SETTINGS\['disable_outgoing_email'\]\s*=)\s*(true|false)(;)`)
-			s = re.ReplaceAllString(s, "$1 true$3")
-
-			err = ioutil.WriteFile(configPath, []byte(s), 0644)
-			if err != nil {
-				fmt.Println("\tCan't disable url corrections and outgoing email.")
-				return
-			}
-		} else {
-			if file, err := os.OpenFile(configPath, os.O_APPEND|os.O_WRONLY, 0644); err != nil {
-				fmt.Println("\tCan't disable url corrections and outgoing email: can not open config file")
-			} else {
-				if _, err = file.Write([]byte("\r$SETTINGS['disable_url_corrections'] = true;")); err != nil {
-					fmt.Println("\tCan't disable url corrections and outgoing email: can't write config file")
-					return
-				}
-				if _, err = file.Write([]byte("\r$SETTINGS['disable_outgoing_email'] = true;")); err != nil {
-					fmt.Println("\tCan't disable url corrections and outgoing email: can't write config file")
-					return
-				}
-			}
-		}
-
-		fmt.Println("\tOK")
-	}
-}
-
-func doElasticReset(cmd *cobra.Command, destinationMysqlConn util.MysqlConn) {
-	reindexElastic, _ := cmd.Flags().GetBool("reindex-elastic")
-	if reindexElastic {
-		fmt.Println("==========================================================================================")
-		fmt.Println("Scheduling Elasticsearch indexation")
-		fmt.Println("==========================================================================================")
-		fmt.Println("Setting elastic.requires_reset flag")
-		_, err := destinationMysqlConn.Conn.Exec("INSERT INTO `settings` (`name`, `value`) VALUES ('elastica.requires_reset', 1) ON DUPLICATE KEY UPDATE `value` = 1")
-		if err != nil {
-			fmt.Println("\tFailed to set flag")
-		} else {
-			fmt.Println("\tOK")
-		}
-		fmt.Println("Updating Elastic indexer status")
-		_, err2 := destinationMysqlConn.Conn.Exec("DELETE FROM `datastore` WHERE `name` = 'sys.es_indexer'")
-		if err2 != nil {
-			fmt.Println("\tFailed to reset indexer status")
-		} else {
-			fmt.Println("\tOK")
-		}
-
-		if err != nil || err2 != nil {
-			fmt.Println("Failed to schedule Elastic reindex")
-		} else {
-			fmt.Println("Scheduled Elasticsearch reindexation for next cron start")
-		}
-	}
-}
-
-func doUpgrade(cmd *cobra.Command) {
-	skipUpgrade, _ := cmd.Flags().GetBool("skip-upgrade")
-
-	fmt.Println("==========================================================================================")
-	fmt.Println("Running Deskpro upgrade")
-	fmt.Println("==========================================================================================")
-
-	if skipUpgrade {
-		fmt.Println("Skipping upgrade, --skip-upgrade flag specified")
-		return
-	}
-
-	phpPath := Config.PhpPath()
-	upgradeCmd := exec.Command(
-		phpPath,
-		filepath.Join(Config.DpPath(), "bin", "console"),
-		"dp:upgrade",
-	)
-
-	var buff bytes.Buffer
-	upgradeCmd.Stdout = &buff
-	upgradeCmd.Stderr = &buff
-
-	_ = upgradeCmd.Start()
-
-	err := upgradeCmd.Wait()
-
-	if err != nil {
-		fmt.Println("Deskpro upgrade failed!")
-		fmt.Println(buff.String())
-		fmt.Println(err)
-	} else {
-		fmt.Println("Deskpro upgrade success")
-		fmt.Println(buff.String())
-	}
-}
-
-
-
-func validateDeskpro(prefix string, dpConfig map[string]string) util.MysqlConn {
-	var (
-		localDbConn          *sql.DB
-		localDbUrl           url.URL
-		destinationMysqlConn util.MysqlConn
-	)
-
-	localDbUrl = util.GetMysqlUrlFromConfig(dpConfig, prefix)
-	localDbConn, err := util.GetMysqlConnectionFromConfig(dpConfig, prefix)
-	if err != nil {
-		log.Error("Failed to connect to db ", err)
-		fmt.Println("The database details contained in config.database.php do not work. This is the error:")
-		fmt.Println(err)
-		fmt.Println("Please correct the database configuration and then try again.")
-		os.Exit(1)
-	}
-
-	destinationMysqlConn = util.MysqlConn{localDbUrl, localDbConn}
-
-	res, err := localDbConn.Query("SHOW TABLES")
-	if err != nil {
-		log.Warning("Failed to SHOW TABLES on local db ", err)
-		fmt.Println("The database details contained in config.database.php do not work. This is the error:")
-		fmt.Println(err)
-		fmt.Println("Please correct the database configuration and then try again.")
-		os.Exit(1)
-	}
-
-	if res.Next() {
-		log.Info("local db has tables")
-
-		// this checks for a count of settings matches the settings that get set upon install
-		// if these arent there, then we can assume its a new instance that hasnt even been configured yet
-		res, err = localDbConn.Query("SELECT COUNT(*) FROM settings WHERE name IN ('admin_has_loaded', 'core.license', 'core.setup_initial')")
-		settingCount := 0
-
-		if err != nil {
-			log.Warning("scanning for settings failed ", err)
-			// an error (i.e. maybe tbale didnt exist) lets just use same handling as if its an install
-			settingCount = 3
-		} else {
-			if res.Next() {
-				_ = res.Scan(&settingCount)
-			}
-		}
-
-		if settingCount == 3 {
-			log.Info("found some records that indicate real install")
-			fmt.Println("The local db already contains tables. If this is a new server, then it might simply be the default demo installation.")
-			fmt.Println("You can wipe the installation with the following command: ")
-			fmt.Println("")
-			fmt.Println(Config.PhpPath(), " ", filepath.Join(Config.DpPath(), "bin", "console"), " install:clean --keep-config")
-			fmt.Println("")
-			os.Exit(1)
-		}
-	}
-
-
-	return destinationMysqlConn
-}
-
-func validateDeskproSource(cmd *cobra.Command, tmpdir string) (string, util.MysqlConn) {
-	//------------------------------
-	// Database conn or dump
-	//------------------------------
-	fmt.Println("After you have wiped the existing installation, try the restore again.")
-
-	fmt.Println("")
-	fmt.Println("==========================================================================================")
-	fmt.Println("Your existing database to restore")
-	fmt.Println("==========================================================================================")
-
-	var (
-		dbDumpLocal string
-		sourceConn util.MysqlConn
-	)
-	if cmd.Flags().Changed("mysql-direct") {
-		sourceConn = validateDeskproSourceDirect(cmd, "mysql-direct")
-	} else if cmd.Flags().Changed("mysql-dump") {
-		dbDumpLocal = validateDeskproSourceDump(cmd, tmpdir)
-	} else {
-		log.Info("no --mysql-direct or --mysql-dump specified")
-		fmt.Println("We need a way to get the database. You can use either --mysql-direct or --mysql-dump. Check --help for more information.")
-		os.Exit(1)
-	}
-
-	return dbDumpLocal, sourceConn
-}
-
-// validateDeskproDestination checks if destination database is ready to accept database dump which may be either
-// a file or a direct mysql connection to dump all tables with mysqldump
-func validateDeskproSourceDirect(cmd *cobra.Command, flag string) util.MysqlConn {
-
-	//var attachLocal string
-	var sourceConn util.MysqlConn
-
-	mysqlUrl, conn := doValidateDeskproSource(cmd, flag)
-	sourceConn = util.MysqlConn{mysqlUrl, conn}
-
-	return sourceConn
-}
-
-func validateDeskproSourceDump(cmd *cobra.Command, tmpdir string) (string) {
-	var (
-		dbDumpLocal string
-		err error
-	)
-
-	dumpUri, _ := cmd.Flags().GetString("mysql-dump")
-
-	if dumpUri, err = filepath.Abs(dumpUri); err != nil {
-		log.Error("Can't find a full path to dump", dumpUri)
-		fmt.Println("Can't find a full path to dump, please check your --mysql-dump option carefully")
-		fmt.Println(err)
-		os.Exit(1)
-	}
-
-	log.Info("--mysql-dump = ", dumpUri)
-
-	fmt.Println("Using database dump from: ", dumpUri)
-
-	dbDumpLocal = filepath.Join(tmpdir, "db.sql")
-	log.Info("save to", dbDumpLocal)
-
-	fmt.Println("Downloading to temp file: ", dbDumpLocal)
-
-	err = getter.GetFile(dbDumpLocal, dumpUri)
-	if err != nil {
-		log.Warning("download dump failed: ", err)
-		fmt.Println("Failed to download database dump: ", err)
-		os.Exit(1)
-	}
-
-	fmt.Println("\tOK")
-
-	return dbDumpLocal
-}
-
-func doValidateDeskproSource(cmd *cobra.Command, flag string) (url.URL, *sql.DB) {
-	var mysqlUrl url.URL
-	var conn *sql.DB
-	var err error
-
-	mysqlUri, _ := cmd.Flags().GetString(flag)
-
-	log.Info("--mysq-client = ", mysqlUri)
-
-	fmt.Println("Using direct MySQL connection to: ", mysqlUri)
-	fmt.Println("Testing connection...")
-
-	mysqlUrl = util.GetMysqlUrlFromUriString(mysqlUri)
-	conn, err = util.GetMysqlConnection(mysqlUrl)
-
-	if err != nil {
-		fmt.Println("Failed to connect to remote database")
-		os.Exit(1)
-	}
-	fmt.Println("\tOK")
-
-	return mysqlUrl, conn
-}
-
-// validateAttachments will perform general attachments validation and will return attachUri string which indicates
-// where attachments are stored
-func validateAttachments(cmd *cobra.Command, conn *sql.DB, tmpdir string) (string, bool) {
-	//------------------------------
-	// Attachments
-	//------------------------------
-
-	fmt.Println("==========================================================================================")
-	fmt.Println("Attachments")
-	fmt.Println("==========================================================================================")
-
-	attachUri, _ := cmd.Flags().GetString("attachments")
-	if len(attachUri) < 1 {
-		log.Info("no --attachments specified")
-		fmt.Println("You must specify a path for attachments with --attachments. See --help for more information.")
-		os.Exit(1)
-	}
-
-
-	if attachUri == "none" {
-		fmt.Println("none -- skipping attachments")
-		return "", false
-	}
-
-	var err error
-
-
-	if attachUri, err = filepath.Abs(attachUri); err != nil {
-		log.Error("Can't find a full path to dump", attachUri)
-		fmt.Println("Can't find a full path to attachments, please check your --attachments option carefully")
-		fmt.Println(err)
-		os.Exit(1)
-	}
-
-	aUrl, err := url.Parse(attachUri)
-	if err != nil {
-		log.Info("--attachments contains wrong URI")
-		fmt.Println("You must specify a correct path for attachments with --attachments. See --help for more information.")
-		os.Exit(1)
-	}
-	moveAttachments := false
-	if aUrl.Scheme == "" || aUrl.Scheme == "file" {
-		attachUri = aUrl.Path
-		moveAttachments, _ = cmd.Flags().GetBool("move-attachments")
-	}
-
-	archive, _ := cmd.Flags().GetBool("attachments-archive")
-
-	if !archive {
-		// try to detect archive from attachUri
-		// that was copy-pasted directly from go-getter
-		archive = detectArchive(attachUri, tmpdir)
-	}
-
-	if archive {
-		fakename := "attachments" + fmt.Sprintf("%d", time.Now().Unix())
-		err := getter.GetAny(filepath.Join(tmpdir, fakename), attachUri)
-		if err != nil {
-			log.Info("failed to load attachments archive: ", err)
-			fmt.Println("Trying to download attachments archive failed: ", err)
-			os.Exit(1)
-		}
-		attachUri = filepath.Join(tmpdir, fakename)
-		// just to save space
-		// anyway we're going to download/copy archive in temp, so why keep files there?
-		moveAttachments = true
-	}
-
-	if _, err := os.Stat(filepath.Join(attachUri, "attachments")); !os.IsNotExist(err) {
-		attachUri = filepath.Join(attachUri, "attachments")
-	}
-
-	attachUri = transformAttachUri(attachUri)
-	log.Info("--attachments is ", attachUri)
-
-	// Verify a file just to validate
-	if conn != nil && attachUri != "none" {
-		res, err := conn.Query("SELECT save_path FROM blobs WHERE storage_loc = 'fs' ORDER BY id DESC LIMIT 1")
-		if err != nil {
-			log.Info("failed blob select: ", err)
-			fmt.Println("Trying to select an attachment record from the database failed: ", err)
-			os.Exit(1)
-		}
-
-		var savePath string
-
-		if res.Next() {
-			if err := res.Scan(&savePath); err != nil {
-				log.Info("failed blob scan: ", err)
-				fmt.Println("Trying to select an attachment record from the database failed: ", err)
-				os.Exit(1)
-			}
-		}
-
-		_ = res.Close()
-
-		// if there are no fs blobs, then there are no attachments
-		if len(savePath) < 1 {
-			log.Info("no fs blobs, attachUri = none")
-			fmt.Println("We detected no filesystem attachments in the database, so there are no attachments to copy over.")
-			fmt.Println("You can use --attachments=none to skip this step in future.")
-			attachUri = "none"
-
-			// try to download it
-		} else {
-			fmt.Println("Testing attachments option...")
-
-			expectFile := strings.Replace(attachUri, "%PATH%", savePath, 1)
-			tmpFile := filepath.Join(tmpdir, "test_file_dl")
-
-			err := getter.GetFile(tmpFile, expectFile)
-			if err != nil {
-				log.Info("Failed to download test file: ", err, ". Expected: ", expectFile)
-				fmt.Println("Failed to download test file: ", err, ". Expected: ", expectFile)
-				os.Exit(1)
-			}
-
-			fmt.Println("\tOK")
-		}
-	}
-
-	return attachUri, moveAttachments
-}
-
-func transformAttachUri(attachUri string) string {
-	// turns a path into a suitable uri with placeholder string
-	// e.g. C:\foo\bar?some_option=value -> C:/foo/bar/%PATH%?some_option
-	// so we can have a single string and get the path easily with a string replace
-
-	fmt.Println("Attachments will be loaded from: ", attachUri)
-
-	attachUri = strings.Replace(attachUri, "\\", "/", -1)
-
-	if !strings.Contains(attachUri, "%PATH%") {
-		re := regexp.MustCompile("/?(\\?.*)?$")
-		attachUri = re.ReplaceAllString(attachUri, "/%PATH%$1")
-	}
-
-	return attachUri
-}
-
-func detectArchive(uri string, tmpdir string) bool{
-	c := getter.Client{
-		Src:     uri,
-		Dst:     filepath.Join(tmpdir, "fake"),
-		Mode:    getter.ClientModeAny,
-	}
-	c.Decompressors = getter.Decompressors
+	conn, err := util.GetMysqlConnection(mysqlUrl)
 
-	u, err := urlhelper.Parse(uri)
 	if err != nil {
-		return false
-	}
-	archive := ""
-	matchingLen := 0
-	for k := range c.Decompressors {
-		if strings.HasSuffix(u.Path, "."+k) && len(k) > matchingLen {
-			archive = k
-			matchingLen = len(k)
-		}
-	}
-
-	return archive != ""
-}
-
-// restoreDatabse performs actual database restore from remote db to local db
-// returns nothing
-func restoreDatabase(destinationMysqlConn util.MysqlConn, sourceMysqlConn util.MysqlConn, dpConfig map[string]string, dbDumpLocal string, tmpdir string) {
-	fmt.Println("==========================================================================================")
-	fmt.Println("Restore Database")
-	fmt.Println("==========================================================================================")
-
-	fmt.Println("Clearing existing database...")
-
-	tableList, _ := destinationMysqlConn.Conn.Query("SHOW TABLES")
-
-	_, _ = destinationMysqlConn.Conn.Exec("SET FOREIGN_KEY_CHECKS = 0")
-	for tableList.Next() {
-		var tableName string
-		_ = tableList.Scan(tableName)
-
-		if len(tableName) > 0 {
-			_, _ = destinationMysqlConn.Conn.Exec("DROP TABLE `" + tableName + "`")
-		}
-	}
-	_, _ = destinationMysqlConn.Conn.Exec("SET FOREIGN_KEY_CHECKS = 1")
-
-	fmt.Println("\tOK")
-
-	localMysqlPass, _ := destinationMysqlConn.MysqlUrl.User.Password()
-	localMysqlPort := destinationMysqlConn.MysqlUrl.Port()
-	if len(localMysqlPort) < 1 {
-		localMysqlPort = "3306"
-	}
-
-	mysqlBin := dpConfig["paths.mysql_path"]
-	mysqlDumpBin := dpConfig["paths.mysqldump_path"]
-
-	localArgs := []string{
-		"-h", destinationMysqlConn.MysqlUrl.Hostname(),
-		"--port", localMysqlPort,
-		"-u", destinationMysqlConn.MysqlUrl.User.Username(),
-	}
-	if localMysqlPass != "" {
-		localArgs = append(localArgs, "--password="+localMysqlPass)
-	}
-	archive := detectArchive(dbDumpLocal, tmpdir)
-	if archive {
-		newPath := filepath.Join(tmpdir, "deskpro_database.sql" + fmt.Sprintf("%d", time.Now().Unix()))
-		err := getter.GetFile(newPath, dbDumpLocal)
-		if err != nil {
-			log.Warning("Failed to unarchive backup file", err)
-			fmt.Println("Failed to unarchive backup file")
-			fmt.Println(err)
-			os.Exit(1)
-		}
-		dbDumpLocal = newPath
-	}
-	localArgs = append(localArgs, strings.TrimLeft(destinationMysqlConn.MysqlUrl.Path, "/"))
-
-	if len(dbDumpLocal) > 1 {
-
-		dumpFile, err := os.Open(dbDumpLocal)
-		defer dumpFile.Close()
-		if err != nil {
-			log.Error("Couldn't open dump file: ", err)
-			fmt.Println("Couldn't open dump file")
-			fmt.Println(err)
-			os.Exit(1)
-		}
-		b := make([]byte, 1024*100)
-		_, err = dumpFile.Read(b)
-		if err != nil {
-			log.Error("Couldn't read dump file: ", err)
-			fmt.Println("Couldn't read dump file")
-			fmt.Println(err)
-			os.Exit(1)
-		}
-		if !strings.Contains(string(b), "agent_activity") {
-			log.Error("The dump file seems to be broken")
-			fmt.Println("The dump file seems to be broken, we can't find correct SQL dump for Deskpro tables")
-			fmt.Println(err)
-			os.Exit(1)
-		}
-
-		fmt.Println("Restoring from database dump (this may take a while)...")
-
-		localArgs = append(localArgs, "-e", "source " + dbDumpLocal)
-
-		out, err := exec.Command(
-			mysqlBin,
-			localArgs...,
-		).CombinedOutput()
-		if err != nil {
-			fmt.Println(string(out))
-			fmt.Println("Failed to restore mysql dump: ", err)
-			os.Exit(1)
-		}
-	} else {
-		fmt.Println("Restoring from mysqldump (this may take a while)...")
-
-		remoteMysqlPass, _ := sourceMysqlConn.MysqlUrl.User.Password()
-		remoteMysqlPort := sourceMysqlConn.MysqlUrl.Port()
-		if len(remoteMysqlPort) < 1 {
-			remoteMysqlPort = "3306"
-		}
-
-		remoteArgs := []string{
-			"-h", sourceMysqlConn.MysqlUrl.Hostname(),
-			"--port", remoteMysqlPort,
-			"-u", sourceMysqlConn.MysqlUrl.User.Username(),
-			"-C",
-		}
-		if remoteMysqlPass != "" {
-			remoteArgs = append(remoteArgs, "--password="+remoteMysqlPass)
-		}
-		remoteArgs = append(remoteArgs, strings.TrimLeft(sourceMysqlConn.MysqlUrl.Path, "/"))
-
-		reader, writer, err := os.Pipe()
-		if err != nil {
-			fmt.Println(err)
-			fmt.Println("IO error -- failed to get pipes: ", err)
-			os.Exit(1)
-		}
-
-		dumpCmd := exec.Command(
-			mysqlDumpBin,
-			remoteArgs...
-		)
-
-		importCmd := exec.Command(
-			mysqlBin,
-			localArgs...
-		)
-
-		var (
-			importBuff bytes.Buffer
-			dumpBuff bytes.Buffer
-		)
-
-		dumpCmd.Stdout = writer
-		dumpCmd.Stderr = &dumpBuff
-
-		importCmd.Stdin = reader
-		importCmd.Stdout = &importBuff
-		importCmd.Stderr = &importBuff
-
-		_ = dumpCmd.Start()
-		_ = importCmd.Start()
-		_ = dumpCmd.Wait()
-		_ = writer.Close()
-		_ = reader.Close()
-		err = importCmd.Wait()
-
-		if err != nil {
-			fmt.Println("Failed to restore mysql dump: ", err)
-			fmt.Println("Import command error output: ")
-			fmt.Println(importBuff.String())
-			fmt.Println("Dump command error output: ")
-			fmt.Println(dumpBuff.String())
-			os.Exit(1)
-		}
+		return "", util.NewError(util.SourceError, "Failed to connect to remote database", err)
 	}
-
 	fmt.Println("\tOK")
-}
-
-type blobrec struct {
-	id   int64
-	path string
-	hash string
-}
-
-
-
-func compareFileHash(filePath string, expectHash string) bool {
-	f, err := os.Open(filePath)
-	if err != nil {
-		return false
-	}
-	defer f.Close()
-
-	h := md5.New()
-	if _, err := io.Copy(h, f); err != nil {
-		return false
-	}
-
-	return fmt.Sprintf("%x", md5.Sum(nil)) == expectHash
-}
-
-func getLastBlobId(db *sql.DB) int64 {
-	res, err := db.Query("SELECT id FROM blobs WHERE storage_loc = 'fs' ORDER BY id DESC LIMIT 1 ")
-
-	if err != nil {
-		panic(err)
-	}
-
-	defer res.Close()
-
-	if res.Next() {
-		var id int64
-		err = res.Scan(&id)
-		if err != nil {
-			panic(err)
-		}
+	_ = conn.Close()
 
-		return id
-	} else {
-		return 0
-	}
+	return mysqlUri, nil
 }
 
-func getNextBlobBatch(db *sql.DB, startId int64) []blobrec {
-
-	res, err := db.Query(`SELECT id, save_path, blob_hash FROM blobs WHERE id > ? AND storage_loc = 'fs' ORDER BY id ASC LIMIT 100`, startId)
-
-	defer res.Close()
-
-	if err != nil {
-		panic(err)
-	}
-
-	var recs []blobrec
-
-	for res.Next() {
-		var r blobrec
-		err = res.Scan(&r.id, &r.path, &r.hash)
-		if err != nil {
-			panic(err)
-		}
-
-		recs = append(recs, r)
+// getRestoreSanitizer validates the sanitize options before anything is restored, it returns nil without --sanitize
+func getRestoreSanitizer(cmd *cobra.Command) (*util.Sanitizer, *util.SanitizeRules, error) {
+	if sanitize, _ := cmd.Flags().GetBool("sanitize"); !sanitize {
+		return nil, nil, nil
 	}
 
-	return recs
+	return getSanitizer(cmd)
 }
-
-
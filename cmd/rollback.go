@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/deskpro/dputils/pkg/restore"
+	"github.com/deskpro/dputils/util"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rollbackCmd.Flags().String(
+		"snapshot",
+		"",
+		`
+			The id of the safety snapshot taken by 'dputils restore --safety-snapshot', or the path to the snapshot dir.
+			Run the command without this option to list the snapshots in the --tmpdir.
+		`,
+	)
+
+	rollbackCmd.Flags().String(
+		"tmpdir",
+		"",
+		`
+			The --tmpdir used by the restore which took the snapshot.
+		`,
+	)
+
+	addDumpFlags(rollbackCmd)
+
+	rootCmd.AddCommand(rollbackCmd)
+}
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Put back the databases and config files saved before a restore",
+	Long: `
+		Restores a safety snapshot taken by 'dputils restore --safety-snapshot'. The Deskpro config files are
+		copied back, then every database in the snapshot is wiped and imported from the snapshot dump.
+
+		Use it when a restore or the Deskpro upgrade after it fails.
+	`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		return runRollback(cmd)
+	},
+}
+
+func runRollback(cmd *cobra.Command) error {
+	tmpdir, _ := cmd.Flags().GetString("tmpdir")
+	if len(tmpdir) < 1 {
+		tmpdir = os.TempDir()
+	}
+
+	dumpOpts, err := getDumpOptions(cmd)
+	if err != nil {
+		return err
+	}
+	if !dumpOpts.Tables.IsZero() {
+		return util.NewError(util.ConfigError, "--include-tables and --exclude-tables can't be used with rollback, every table of the snapshot is restored", nil)
+	}
+
+	id, _ := cmd.Flags().GetString("snapshot")
+	if id == "" {
+		ids := restore.ListSnapshots(tmpdir)
+		if len(ids) == 0 {
+			fmt.Println("There are no safety snapshots in " + tmpdir)
+		} else {
+			fmt.Println("Safety snapshots in " + tmpdir + ": " + strings.Join(ids, ", "))
+		}
+		return util.NewError(util.ConfigError, "Specify the snapshot to roll back to with --snapshot", nil)
+	}
+
+	snapshot, err := restore.ReadSnapshot(tmpdir, id)
+	if err != nil {
+		return util.NewError(util.ConfigError, "Can't read the safety snapshot "+id+" in "+tmpdir, err)
+	}
+
+	if snapshot.DeskproPath != Config.DpPath() {
+		return util.NewError(util.ConfigError, "The snapshot was taken from Deskpro in "+snapshot.DeskproPath+", but Deskpro is in "+Config.DpPath(), nil)
+	}
+
+	fmt.Println("==========================================================================================")
+	fmt.Println("Rolling back to the safety snapshot " + snapshot.Id)
+	fmt.Println("==========================================================================================")
+
+	fmt.Println("Restoring config files...")
+	if err = snapshot.RestoreConfigFiles(); err != nil {
+		return util.NewError(util.GeneralError, "Failed to restore config files", err)
+	}
+	fmt.Println("\tOK")
+
+	// the config is read after the config files are put back
+	dpConfig, err := Config.LoadDeskproConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	for _, db := range snapshot.Databases {
+		fmt.Println("Restoring the " + db.Type + " database...")
+		if err = snapshot.RollbackDatabase(ctx, dpConfig, dumpOpts, db); err != nil {
+			if ctx.Err() != nil {
+				return util.NewError(util.CanceledError, "The rollback was interrupted, the "+db.Type+" database is incomplete. Run it again", ctx.Err())
+			}
+			return util.NewError(util.DumpError, "Failed to roll back the "+db.Type+" database", err)
+		}
+		fmt.Println("\tOK")
+	}
+
+	fmt.Println("==========================================================================================")
+	fmt.Println("Finished rolling back. The snapshot is kept in " + snapshot.Dir())
+	fmt.Println("==========================================================================================")
+
+	return nil
+}
@@ -10,13 +10,16 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"strings"
 )
 
 var (
-	cfgFile string
-	phpPath string
-	dpPath  string
-	Config  util.Config
+	cfgFile   string
+	phpPath   string
+	dpPath    string
+	noInput   bool
+	runConfig *util.RunConfig
+	Config    util.Config
 )
 
 
@@ -24,18 +27,47 @@ var (
 var rootCmd = &cobra.Command{
 	Use:   "dputils",
 	Short: "Deskpro tools and utilities for working with helpdesk instances",
+	Long: `
+		Deskpro tools and utilities for working with helpdesk instances
+
+		Exit codes:
+		  0 - success
+		  1 - general error
+		  2 - wrong options, Deskpro config or local database (config_error)
+		  3 - the database or files to restore from can't be reached (source_error)
+		  4 - a database dump or import failed (dump_error)
+		  5 - attachments failed to copy (attachment_error)
+		  6 - the Deskpro upgrade after a restore failed (upgrade_error)
+		  7 - interrupted by a signal (canceled)
+	`,
+	SilenceErrors: true,
 	Run: func(cmd *cobra.Command, args []string) { },
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		applyRunConfig(cmd)
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		exitWithError(err)
 	}
 }
 
+// exitWithError logs and prints the error and exits with the exit code of its kind
+func exitWithError(err error) {
+	log.Error(err)
+	events.failed(err)
+	fmt.Println(err)
+	os.Exit(util.ErrorKindOf(err).ExitCode())
+}
+
+// exitConfigError is exitWithError for wrong options found before the command runs, they're a ConfigError
+func exitConfigError(message string, err error) {
+	exitWithError(util.NewError(util.ConfigError, message, err))
+}
+
 func init() {
 	log.SetFormatter(&log.JSONFormatter{})
 	log.SetLevel(log.ErrorLevel)
@@ -43,10 +75,14 @@ func init() {
 	cobra.OnInitialize(initConfig)
 	rootCmd.PersistentFlags().StringVar(&dpPath, "deskpro", "", "Path to Deskpro on the current server")
 	rootCmd.PersistentFlags().StringVar(&phpPath, "php", "", "Path to PHP")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "YAML file with the options of the command, see README.md")
+	rootCmd.PersistentFlags().BoolVar(&noInput, "no-input", false, "Fail instead of prompting when an option is missing")
 }
 
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
+	readRunConfig()
+
 	if len(phpPath) > 1 {
 		log.Info("Using PHP path specified on CLI: ", phpPath)
 	} else {
@@ -55,26 +91,19 @@ func initConfig() {
 		if len(phpPath) > 1 {
 			log.Info("Using detected PHP path: ", phpPath)
 		} else {
+			if noInput {
+				exitConfigError("Failed to detect the path to PHP, specify it with --php or in the --config file", nil)
+			}
 			log.Info("Failed to detect PHP path, prompting user")
 			fmt.Println("This tool requires PHP to operate correctly. Please enter the path to PHP.")
 			prompt := promptui.Prompt{
 				Label:    "PHP Path",
-				Validate: func(input string) error {
-					if _, err := os.Stat(input); os.IsNotExist(err) {
-						return errors.New("path to PHP is incorrect")
-					}
-					_, err := exec.Command(input + " --version").Output()
-					if err == nil {
-						return errors.New("path to PHP is incorrect")
-					}
-					return nil
-				},
+				Validate: validatePhpPath,
 			}
 			result, err := prompt.Run()
 
 			if len(result) < 1 || err != nil {
-				fmt.Println("Failed to get path to PHP")
-				os.Exit(1)
+				exitConfigError("Failed to get path to PHP", err)
 			}
 
 			phpPath = result
@@ -90,6 +119,9 @@ func initConfig() {
 		if len(dpPath) > 1 {
 			log.Info("Using detected Deskpro path: ", dpPath)
 		} else {
+			if noInput {
+				exitConfigError("Failed to detect the path to Deskpro, specify it with --deskpro or in the --config file", nil)
+			}
 			fmt.Println("This tool uses Deskpro source files. You can run the tool from within the Deskpro directory, or supply a path here.")
 			prompt := promptui.Prompt{
 				Label:    "Deskpro Path",
@@ -103,8 +135,7 @@ func initConfig() {
 			result, err := prompt.Run()
 
 			if len(result) < 1 || err != nil {
-				fmt.Println("Failed to get path to Deskpro")
-				os.Exit(1)
+				exitConfigError("Failed to get path to Deskpro", err)
 			}
 
 			dpPath = result
@@ -123,4 +154,63 @@ func initConfig() {
 
 	Config = util.Config{}
 	Config.SetPhpPath(phpPath).SetDpPath(dpPath)
-}
\ No newline at end of file
+}
+
+// validatePhpPath checks the PHP path entered at the prompt, the binary must exist and run "php --version"
+func validatePhpPath(input string) error {
+	if _, err := os.Stat(input); os.IsNotExist(err) {
+		return errors.New("path to PHP is incorrect")
+	}
+	if _, err := exec.Command(input, "--version").Output(); err != nil {
+		return errors.New("path to PHP is incorrect")
+	}
+
+	return nil
+}
+
+// readRunConfig loads the --config file, options given on the command line take precedence over the file
+func readRunConfig() {
+	if cfgFile == "" {
+		util.NoInput = noInput
+		return
+	}
+
+	var err error
+	runConfig, err = util.ReadRunConfig(cfgFile)
+	if err != nil {
+		exitConfigError("Failed to read the config file "+cfgFile, err)
+	}
+
+	if phpPath == "" {
+		phpPath = runConfig.Php
+	}
+	if dpPath == "" {
+		dpPath = runConfig.Deskpro
+	}
+	if !rootCmd.PersistentFlags().Changed("no-input") {
+		noInput = runConfig.NoInput
+	}
+	util.NoInput = noInput
+}
+
+// applyRunConfig sets the options of the command from its section of the --config file
+func applyRunConfig(cmd *cobra.Command) {
+	if runConfig == nil {
+		return
+	}
+
+	section := strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" ")
+	names, options := runConfig.CommandOptions(section)
+	for _, name := range names {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil {
+			exitConfigError("Unknown option "+name+" in the "+section+" section of "+cfgFile, nil)
+		}
+		if flag.Changed {
+			continue
+		}
+		if err := cmd.Flags().Set(name, options[name]); err != nil {
+			exitConfigError("Invalid option "+name+" in the "+section+" section of "+cfgFile, err)
+		}
+	}
+}
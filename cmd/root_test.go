@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func Test_validatePhpPath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake PHP binaries are shell scripts")
+	}
+	dir := t.TempDir()
+
+	php := filepath.Join(dir, "php")
+	if err := os.WriteFile(php, []byte("#!/bin/sh\n[ \"$1\" = \"--version\" ] && echo \"PHP 8.1.0 (cli)\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := validatePhpPath(php); err != nil {
+		t.Errorf("A PHP binary which runs --version should be accepted: %s", err)
+	}
+
+	broken := filepath.Join(dir, "broken")
+	if err := os.WriteFile(broken, []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := validatePhpPath(broken); err == nil {
+		t.Error("A binary which fails to run --version should be rejected")
+	}
+
+	if err := validatePhpPath(filepath.Join(dir, "missing")); err == nil {
+		t.Error("A path which doesn't exist should be rejected")
+	}
+}
@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/deskpro/dputils/util"
+	"github.com/manifoldco/promptui"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const defaultSanitizeGroups = "people,secrets"
+
+func init() {
+	addSanitizeFlags(sanitizeCmd)
+
+	sanitizeCmd.Flags().Bool(
+		"yes",
+		false,
+		`
+			Don't ask for confirmation before the data is changed
+		`,
+	)
+
+	rootCmd.AddCommand(sanitizeCmd)
+}
+
+func addSanitizeFlags(cmd *cobra.Command) {
+	cmd.Flags().String(
+		"sanitize-rules",
+		"",
+		`
+			Path to a JSON file with the sanitize rules, used instead of the built-in rules. Every rule names a
+			table, its unique key column, the group of the rule, an optional where condition and the columns to
+			replace with a fake value (email, first_name, last_name, name, company, phone, text, secret, url
+			or empty):
+
+			{"tables": [{"table": "people_emails", "key": "id", "group": "people", "columns": {"email": "email"}}]}
+		`,
+	)
+
+	cmd.Flags().String(
+		"sanitize-groups",
+		defaultSanitizeGroups,
+		`
+			Comma separated groups of rules to apply. The built-in rules have the following groups:
+
+			people  - names, emails and phone numbers of people and organization names
+			secrets - API keys, OAuth tokens, webhook URLs and app secrets
+			tickets - ticket subjects and message bodies
+		`,
+	)
+
+	cmd.Flags().String(
+		"sanitize-salt",
+		"",
+		`
+			Fake values are derived from the original values and this salt, so the same email gets the same fake
+			email everywhere. A random salt is used by default, specify it to get the same fake values every time.
+		`,
+	)
+}
+
+var sanitizeCmd = &cobra.Command{
+	Use:   "sanitize",
+	Short: "Replace personal data and secrets with fake values",
+	Long: `
+		Rewrites people emails, names and phone numbers, API keys, OAuth tokens, webhook URLs and app secrets
+		(and optionally ticket subjects and messages) of the Deskpro database with fake values, so a copy of a
+		production helpdesk can be used as a test instance.
+
+		The data is changed in place and can't be recovered, never run it against a production database.
+	`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		sanitizer, rules, err := getSanitizer(cmd)
+		if err != nil {
+			return err
+		}
+
+		dpConfig, err := Config.LoadDeskproConfig(cmd)
+		if err != nil {
+			return err
+		}
+		db, err := util.GetMysqlConnectionFromConfig(dpConfig, "database")
+		if err != nil {
+			return util.NewError(util.ConfigError, "Failed to connect to the Deskpro database", err)
+		}
+		defer db.Close()
+
+		yes, _ := cmd.Flags().GetBool("yes")
+		if !yes && noInput {
+			return util.NewError(util.ConfigError, "Confirm that the Deskpro database can be changed with --yes", nil)
+		}
+		if !yes {
+			_, err = (&promptui.Prompt{
+				Label:     "Personal data and secrets in the Deskpro database will be replaced with fake values. Continue",
+				IsConfirm: true,
+			}).Run()
+			if err != nil {
+				return util.NewError(util.GeneralError, "Aborted", nil)
+			}
+		}
+
+		return runSanitizer(sanitizer, rules, db)
+	},
+}
+
+// getSanitizer validates the sanitize options, the database connection is set by the caller
+func getSanitizer(cmd *cobra.Command) (*util.Sanitizer, *util.SanitizeRules, error) {
+	rules := util.DefaultSanitizeRules()
+	if rulesPath, _ := cmd.Flags().GetString("sanitize-rules"); rulesPath != "" {
+		var err error
+		rules, err = util.ReadSanitizeRules(rulesPath)
+		if err != nil {
+			return nil, nil, util.NewError(util.ConfigError, "Failed to read the sanitize rules from "+rulesPath, err)
+		}
+	}
+
+	groupsList, _ := cmd.Flags().GetString("sanitize-groups")
+	var groups []string
+	for _, group := range strings.Split(groupsList, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	if len(groups) == 0 {
+		return nil, nil, util.NewError(util.ConfigError, "Specify at least one group with --sanitize-groups", nil)
+	}
+
+	salt, _ := cmd.Flags().GetString("sanitize-salt")
+	if salt == "" {
+		random := make([]byte, 16)
+		if _, err := rand.Read(random); err != nil {
+			return nil, nil, util.NewError(util.GeneralError, "Failed to generate the sanitize salt", err)
+		}
+		salt = hex.EncodeToString(random)
+	}
+
+	return &util.Sanitizer{Salt: salt, Groups: groups, BatchSize: 500}, rules, nil
+}
+
+func runSanitizer(sanitizer *util.Sanitizer, rules *util.SanitizeRules, db *sql.DB) error {
+	fmt.Println("==========================================================================================")
+	fmt.Println("Replacing personal data and secrets with fake values (" + strings.Join(sanitizer.Groups, ", ") + ")")
+	fmt.Println("==========================================================================================")
+
+	sanitizer.Db = db
+	_, err := sanitizer.Run(rules, func(result util.SanitizeResult) {
+		if result.Skipped != "" {
+			log.Warning("Sanitize skipped table ", result.Table, ": ", result.Skipped)
+			fmt.Printf("\t%s: skipped, %s\n", result.Table, result.Skipped)
+			return
+		}
+		fmt.Printf("\t%s: %d rows\n", result.Table, result.Rows)
+	})
+	if err != nil {
+		return util.NewError(util.GeneralError, "Failed to sanitize the database", err)
+	}
+	fmt.Println("\tOK")
+
+	return nil
+}
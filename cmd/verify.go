@@ -0,0 +1,283 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/alexmullins/zip"
+	"github.com/deskpro/dputils/util"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	verifyCmd.Flags().StringP(
+		"archive",
+		"a",
+		"",
+		`
+				Path to a backup archive created with the 'dputils backup' command.
+		`,
+	)
+
+	verifyCmd.Flags().String(
+		"migration-secret",
+		"",
+		`
+				The secret the archive was encrypted with, if it was created with --migration-secret.
+		`,
+	)
+
+	verifyCmd.Flags().StringP(
+		"backup",
+		"b",
+		"",
+		`
+				Provide "database" or "attachments" if the archive was created with the same --backup
+				option. If not specified, both the database dump and attachments are expected.
+		`,
+	)
+
+	rootCmd.AddCommand(verifyCmd)
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify a backup archive is complete and readable",
+	Long: `
+		Opens a backup archive created with the 'dputils backup' command, reads every entry and checks
+		that the database dumps were not truncated and all attachments can be extracted.
+
+		Exits with code 3 (source_error) if any check fails.
+	`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		return runVerify(cmd)
+	},
+}
+
+func runVerify(cmd *cobra.Command) error {
+	archive, _ := cmd.Flags().GetString("archive")
+	if archive == "" {
+		return util.NewError(util.ConfigError, "You must specify a backup archive to verify with --archive", nil)
+	}
+
+	what, _ := cmd.Flags().GetString("backup")
+	if what != "attachments" && what != "database" && what != "" {
+		return util.NewError(util.ConfigError, "Wrong --backup options, you may specify either \"attachments\" or \"database\" or omit the option to verify both", nil)
+	}
+
+	secret, _ := cmd.Flags().GetString("migration-secret")
+
+	fmt.Println("==========================================================================================")
+	fmt.Println("Verifying " + archive)
+	fmt.Println("==========================================================================================")
+
+	checks, err := verifyArchive(archive, secret, what)
+	if err != nil {
+		return util.NewError(util.SourceError, "Failed to open backup archive", err)
+	}
+
+	failed := 0
+	for _, check := range checks {
+		status := " OK "
+		if !check.Ok {
+			status = "FAIL"
+			failed++
+		}
+		fmt.Printf("[%s] %s: %s\n", status, check.Name, check.Detail)
+	}
+
+	if failed > 0 {
+		return util.NewError(util.SourceError, fmt.Sprintf("Backup archive is NOT valid: %d of %d checks failed", failed, len(checks)), nil)
+	}
+
+	fmt.Println("Backup archive is valid")
+
+	return nil
+}
+
+type verifyCheck struct {
+	Name   string
+	Ok     bool
+	Detail string
+}
+
+var advancedDumpName = regexp.MustCompile(`^database_advanced\.[a-z]+\.sql$`)
+
+// verifyArchive reads every entry of a backup archive and returns the list of performed checks
+func verifyArchive(archivePath string, secret string, what string) ([]verifyCheck, error) {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	manifest, err := util.ReadZipManifest(&reader.Reader)
+	if err != nil {
+		return []verifyCheck{{util.ManifestName, false, "manifest is not valid JSON: " + err.Error()}}, nil
+	}
+
+	var (
+		checks         []verifyCheck
+		hasDump        bool
+		hasAttachments bool
+		attachCount    int
+		attachBytes    int64
+		attachFailures []string
+	)
+
+	for _, f := range reader.File {
+		name := strings.Replace(f.Name, "\\", "/", -1)
+
+		switch {
+		case name == "database.sql" || advancedDumpName.MatchString(name):
+			if name == "database.sql" {
+				hasDump = true
+			}
+			checks = append(checks, verifyDumpEntry(f, name, secret, manifest))
+
+		case name == "v5_metadata.json":
+			checks = append(checks, verifyMetadataEntry(f, name, secret, manifest))
+
+		case strings.HasPrefix(name, "attachments/"):
+			hasAttachments = true
+			if strings.HasSuffix(name, "/") {
+				continue
+			}
+			n, err := readZipEntry(f, secret, manifest, ioutil.Discard)
+			attachBytes += n
+			attachCount++
+			if err != nil {
+				attachFailures = append(attachFailures, name+" ("+err.Error()+")")
+			}
+		}
+	}
+
+	if what != "attachments" && !hasDump {
+		checks = append(checks, verifyCheck{"database.sql", false, "database dump is missing"})
+	}
+
+	if what != "database" {
+		if !hasAttachments {
+			checks = append(checks, verifyCheck{"attachments/", false, "attachments directory is missing"})
+		} else if len(attachFailures) > 0 {
+			detail := fmt.Sprintf("%d of %d files are unreadable: %s", len(attachFailures), attachCount, attachFailures[0])
+			if len(attachFailures) > 1 {
+				detail += fmt.Sprintf(" and %d more", len(attachFailures)-1)
+			}
+			checks = append(checks, verifyCheck{"attachments/", false, detail})
+		} else {
+			checks = append(checks, verifyCheck{"attachments/", true, fmt.Sprintf("%d files, %d bytes", attachCount, attachBytes)})
+		}
+	}
+
+	if manifest != nil {
+		checks = append([]verifyCheck{verifyManifest(reader, manifest)}, checks...)
+	}
+
+	return checks, nil
+}
+
+// verifyManifest makes sure every entry listed in the manifest is present in the archive
+func verifyManifest(reader *zip.ReadCloser, manifest *util.Manifest) verifyCheck {
+	present := map[string]bool{}
+	for _, f := range reader.File {
+		present[f.Name] = true
+	}
+
+	var missing []string
+	for _, entry := range manifest.Entries {
+		if !present[entry.Name] {
+			missing = append(missing, entry.Name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return verifyCheck{util.ManifestName, false, fmt.Sprintf("%d listed entries are missing: %s", len(missing), strings.Join(missing, ", "))}
+	}
+
+	return verifyCheck{util.ManifestName, true, fmt.Sprintf("created by dputils %s on %s, %d entries", manifest.Version, manifest.Host, len(manifest.Entries))}
+}
+
+func verifyDumpEntry(f *zip.File, name string, secret string, manifest *util.Manifest) verifyCheck {
+	pr, pw := io.Pipe()
+	result := make(chan util.DumpInfo)
+	go func() {
+		info, err := util.ScanDump(pr)
+		_ = pr.CloseWithError(err)
+		result <- info
+	}()
+
+	_, err := readZipEntry(f, secret, manifest, pw)
+	_ = pw.CloseWithError(err)
+	info := <-result
+
+	if err != nil {
+		return verifyCheck{name, false, "failed to read dump: " + err.Error()}
+	}
+	if !info.Header {
+		return verifyCheck{name, false, "mysqldump header is missing"}
+	}
+	if !info.Completed {
+		return verifyCheck{name, false, fmt.Sprintf("dump is truncated after %d bytes (no \"Dump completed\" footer)", info.Size)}
+	}
+	if info.Tables == 0 {
+		return verifyCheck{name, false, "dump does not contain any tables"}
+	}
+
+	return verifyCheck{name, true, fmt.Sprintf("%d tables, %d bytes", info.Tables, info.Size)}
+}
+
+func verifyMetadataEntry(f *zip.File, name string, secret string, manifest *util.Manifest) verifyCheck {
+	var buf strings.Builder
+	if _, err := readZipEntry(f, secret, manifest, &buf); err != nil {
+		return verifyCheck{name, false, "failed to read metadata: " + err.Error()}
+	}
+
+	var metadata interface{}
+	if err := json.Unmarshal([]byte(buf.String()), &metadata); err != nil {
+		return verifyCheck{name, false, "metadata is not valid JSON: " + err.Error()}
+	}
+
+	return verifyCheck{name, true, "valid JSON"}
+}
+
+// readZipEntry copies the content of an archive entry into the writer. Reading an entry to the end makes the zip
+// reader validate its CRC32 checksum (or the authentication code for AES encrypted entries). If the archive has
+// a manifest, the content is also compared with the recorded SHA-256 checksum.
+func readZipEntry(f *zip.File, secret string, manifest *util.Manifest, w io.Writer) (int64, error) {
+	if f.IsEncrypted() {
+		if secret == "" {
+			return 0, fmt.Errorf("entry is encrypted, please provide --migration-secret")
+		}
+		f.SetPassword(secret)
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+
+	var entry *util.ManifestEntry
+	if manifest != nil {
+		entry = manifest.Entry(f.Name)
+	}
+	if entry == nil {
+		return io.Copy(w, rc)
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, h), rc)
+	if err == nil && (n != entry.Size || fmt.Sprintf("%x", h.Sum(nil)) != entry.Sha256) {
+		err = fmt.Errorf("content doesn't match the manifest checksum")
+	}
+
+	return n, err
+}
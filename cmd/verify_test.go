@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alexmullins/zip"
+	"github.com/deskpro/dputils/util"
+)
+
+const verifyTestDump = `-- MySQL dump 10.13  Distrib 5.7.30, for Linux (x86_64)
+CREATE TABLE ` + "`agent_activity`" + ` (
+  ` + "`agent_id`" + ` int(11) NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+INSERT INTO ` + "`agent_activity`" + ` VALUES (1);
+-- Dump completed on 2021-01-01 10:00:00
+`
+
+func writeVerifyTestArchive(t *testing.T, secret string, entries map[string]string) string {
+	archivePath := filepath.Join(t.TempDir(), "backup.zip")
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	for name, content := range entries {
+		entry, err := util.ZipCreate(w, name, secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = entry.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return archivePath
+}
+
+func countFailedChecks(checks []verifyCheck) int {
+	failed := 0
+	for _, check := range checks {
+		if !check.Ok {
+			failed++
+		}
+	}
+	return failed
+}
+
+func Test_verifyArchive(t *testing.T) {
+	archivePath := writeVerifyTestArchive(t, "", map[string]string{
+		"database.sql":                verifyTestDump,
+		"database_advanced.audit.sql": verifyTestDump,
+		"v5_metadata.json":            `{"ok": true}`,
+		"attachments/":                "",
+		"attachments/1/2/blob":        "content",
+	})
+
+	checks, err := verifyArchive(archivePath, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 4 || countFailedChecks(checks) != 0 {
+		t.Errorf("Expected 4 passed checks, got %+v", checks)
+	}
+}
+
+func Test_verifyArchiveTruncatedDump(t *testing.T) {
+	archivePath := writeVerifyTestArchive(t, "", map[string]string{
+		"database.sql": verifyTestDump[:len(verifyTestDump)-45],
+		"attachments/": "",
+	})
+
+	checks, err := verifyArchive(archivePath, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if countFailedChecks(checks) != 1 {
+		t.Errorf("Expected truncated dump to fail, got %+v", checks)
+	}
+}
+
+func Test_verifyArchiveEncrypted(t *testing.T) {
+	archivePath := writeVerifyTestArchive(t, "secret", map[string]string{
+		"database.sql":         verifyTestDump,
+		"attachments/1/2/blob": "content",
+	})
+
+	checks, err := verifyArchive(archivePath, "secret", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if countFailedChecks(checks) != 0 {
+		t.Errorf("Expected encrypted archive to pass, got %+v", checks)
+	}
+
+	checks, _ = verifyArchive(archivePath, "", "")
+	if countFailedChecks(checks) != 2 {
+		t.Errorf("Expected encrypted archive without secret to fail, got %+v", checks)
+	}
+
+	checks, _ = verifyArchive(archivePath, "wrong", "database")
+	if countFailedChecks(checks) != 1 {
+		t.Errorf("Expected encrypted archive with a wrong secret to fail, got %+v", checks)
+	}
+}
+
+func Test_verifyArchiveMissingEntries(t *testing.T) {
+	archivePath := writeVerifyTestArchive(t, "", map[string]string{
+		"v5_metadata.json": `{"ok": `,
+	})
+
+	checks, err := verifyArchive(archivePath, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if countFailedChecks(checks) != 3 {
+		t.Errorf("Expected metadata, dump and attachments checks to fail, got %+v", checks)
+	}
+}
+
+func Test_verifyArchiveManifest(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "backup.zip")
+	f, _ := os.Create(archivePath)
+	w := zip.NewWriter(f)
+
+	manifest := util.NewManifest("v0.1", "", false)
+	entry, _ := manifest.CreateEntry(w, "database.sql", "")
+	_, _ = entry.Write([]byte(verifyTestDump))
+	_, _ = manifest.CreateEntry(w, "attachments/", "")
+	entry, _ = manifest.CreateEntry(w, "attachments/1/2/blob", "")
+	_, _ = entry.Write([]byte("content"))
+	_ = manifest.Save(w)
+	_ = w.Close()
+	_ = f.Close()
+
+	checks, err := verifyArchive(archivePath, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 3 || countFailedChecks(checks) != 0 {
+		t.Errorf("Expected 3 passed checks, got %+v", checks)
+	}
+
+	manifest.Entry("attachments/1/2/blob").Sha256 = "broken"
+	manifest.Entries = append(manifest.Entries, &util.ManifestEntry{Name: "database_advanced.audit.sql"})
+	f, _ = os.Create(archivePath)
+	w = zip.NewWriter(f)
+	entry, _ = w.Create("database.sql")
+	_, _ = entry.Write([]byte(verifyTestDump))
+	entry, _ = w.Create("attachments/1/2/blob")
+	_, _ = entry.Write([]byte("content"))
+	_ = manifest.Save(w)
+	_ = w.Close()
+	_ = f.Close()
+
+	checks, _ = verifyArchive(archivePath, "", "")
+	if countFailedChecks(checks) != 2 {
+		t.Errorf("Expected manifest and attachments checks to fail, got %+v", checks)
+	}
+}
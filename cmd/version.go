@@ -5,6 +5,10 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// Version of dputils, recorded into backup manifests. May be overridden at build time with
+// -ldflags "-X github.com/deskpro/dputils/cmd.Version=..."
+var Version = "v0.1"
+
 func init() {
 	rootCmd.AddCommand(versionCmd)
 }
@@ -13,6 +17,6 @@ var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Print the version number",
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("Deskpro Utils v0.1")
+		fmt.Println("Deskpro Utils " + Version)
 	},
 }
\ No newline at end of file
@@ -0,0 +1,213 @@
+package backup
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/alexmullins/zip"
+	"github.com/deskpro/dputils/util"
+	log "github.com/sirupsen/logrus"
+)
+
+func (a *archiver) addAttachments(zipFile *zip.Writer, manifest *util.Manifest) error {
+	a.phaseStarted("attachments")
+
+	a.println("Writing attachments")
+	dpConfig := a.opts.DeskproConfig
+	attachUri := util.AttachmentsPath(dpConfig, a.opts.DeskproPath)
+
+	// the last blob is recorded so this backup can be used as a base for incremental backups. It's done before
+	// walking the attachments dir, so blobs added while the backup is running will be in the next increment.
+	if db, err := util.GetMysqlConnectionFromConfig(dpConfig, "database"); err == nil {
+		manifest.Attachments.LastBlobId, err = util.LastBlobId(a.ctx, db)
+		_ = db.Close()
+		if err != nil {
+			return util.NewError(util.AttachmentError, "Failed to read the last attachment from the database", err)
+		}
+	} else {
+		log.Warning("Failed to connect to db to get the last blob id ", err)
+		a.warning("Can't connect to the database, this backup can't be used as a base for incremental backups")
+		a.println("\tCan't connect to the database, this backup can't be used as a base for incremental backups")
+	}
+
+	manifest.Attachments.Included = true
+	manifest.CreateEntry(zipFile, "attachments/", a.opts.EncryptionSecret)
+	if err := a.addFiles(zipFile, attachUri, "attachments", manifest); err != nil {
+		return util.NewError(util.AttachmentError, "Failed to write attachments to zip archive", err)
+	}
+	a.progress(manifest)
+	a.println("\t Done writing attachments")
+	a.phaseFinished("attachments")
+
+	return nil
+}
+
+// addIncrementalAttachments writes only the blobs added after the previous backup (or timestamp) described by
+// IncrementalSince. Blobs are taken from the blobs table, so there is no need to walk the attachments dir.
+func (a *archiver) addIncrementalAttachments(zipFile *zip.Writer, manifest *util.Manifest) error {
+	a.phaseStarted("attachments")
+
+	since := a.opts.IncrementalSince
+	a.println("Writing attachments added since " + since)
+
+	db, err := util.GetMysqlConnectionFromConfig(a.opts.DeskproConfig, "database")
+	if err != nil {
+		return util.NewError(util.ConfigError, "Incremental backups require a database connection to find new attachments", err)
+	}
+	defer db.Close()
+
+	sinceBlobId, err := resolveIncrementalSince(db, since)
+	if err != nil {
+		return util.NewError(util.ConfigError, "Wrong --incremental-since option, provide a previous backup archive, its manifest or a timestamp", err)
+	}
+
+	attachUri := util.AttachmentsPath(a.opts.DeskproConfig, a.opts.DeskproPath)
+	lastBlobId, err := util.LastBlobId(a.ctx, db)
+	if err != nil {
+		return util.NewError(util.AttachmentError, "Failed to read the last attachment from the database", err)
+	}
+
+	manifest.Incremental = &util.ManifestIncremental{SinceBlobId: sinceBlobId}
+	manifest.Attachments.Included = true
+	manifest.Attachments.LastBlobId = lastBlobId
+	manifest.CreateEntry(zipFile, "attachments/", a.opts.EncryptionSecret)
+
+	nextStartId := sinceBlobId
+	missing := 0
+
+	for nextStartId < lastBlobId {
+		if err := a.ctx.Err(); err != nil {
+			return util.NewError(util.AttachmentError, "The backup of attachments was canceled", err)
+		}
+
+		batch, err := util.NextBlobBatch(a.ctx, db, nextStartId)
+		if err != nil {
+			return util.NewError(util.AttachmentError, "Failed to read the attachments to backup", err)
+		}
+		if batch == nil {
+			break
+		}
+
+		for _, blob := range batch {
+			if blob.Id > lastBlobId {
+				break
+			}
+
+			dat, err := ioutil.ReadFile(filepath.Join(attachUri, filepath.FromSlash(blob.SavePath)))
+			if err != nil {
+				log.Warning("Failed to read blob ", blob.Id, " ", err)
+				missing++
+				continue
+			}
+
+			f, err := manifest.CreateEntry(zipFile, "attachments/"+blob.SavePath, a.opts.EncryptionSecret)
+			if err == nil {
+				_, err = f.Write(dat)
+			}
+			if err != nil {
+				return util.NewError(util.AttachmentError, "Failed to write attachments to zip archive", err)
+			}
+			manifest.Attachments.Files++
+			manifest.Attachments.Size += int64(len(dat))
+		}
+
+		nextStartId = batch[len(batch)-1].Id
+		a.progress(manifest)
+	}
+
+	if missing > 0 {
+		a.warning(fmt.Sprintf("%d attachments were not found in %s", missing, attachUri))
+		a.println("\tWarning:", missing, "attachments were not found in", attachUri)
+	}
+	a.println("\t Done writing", manifest.Attachments.Files, "attachments")
+	a.phaseFinished("attachments")
+
+	return nil
+}
+
+// resolveIncrementalSince turns the IncrementalSince option into the id of the last blob included in the
+// previous backup. The option is either a path to a previous backup archive or manifest, or a timestamp.
+func resolveIncrementalSince(db *sql.DB, since string) (int64, error) {
+	if _, err := os.Stat(since); err == nil {
+		manifest, err := util.ReadManifestFile(since)
+		if err != nil {
+			return 0, err
+		}
+		if !manifest.Attachments.Included || manifest.Attachments.LastBlobId == 0 {
+			return 0, fmt.Errorf("%s doesn't record the last backed up attachment and can't be used as a base", since)
+		}
+		return manifest.Attachments.LastBlobId, nil
+	}
+
+	var (
+		sinceTime time.Time
+		err       error
+	)
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02", time.RFC3339} {
+		if sinceTime, err = time.ParseInLocation(layout, since, time.Local); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s is neither an existing file nor a timestamp", since)
+	}
+
+	var sinceBlobId int64
+	err = db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM blobs WHERE date_created < ?", sinceTime.UTC().Format("2006-01-02 15:04:05")).Scan(&sinceBlobId)
+
+	return sinceBlobId, err
+}
+
+// addFiles writes the files of the uri dir into the archive, files which can't be read are skipped
+func (a *archiver) addFiles(zipFile *zip.Writer, uri string, zipPath string, manifest *util.Manifest) error {
+	files, err := ioutil.ReadDir(uri)
+	if err != nil {
+		a.println(err)
+	}
+
+	var size int64
+	for _, file := range files {
+		if err := a.ctx.Err(); err != nil {
+			return err
+		}
+		// we don't want to backup import temporary files
+		if file.Name() == "import" {
+			continue
+		}
+		if !file.IsDir() {
+			size += file.Size()
+			dat, err := ioutil.ReadFile(filepath.Join(uri, file.Name()))
+			if err != nil {
+				a.println(err)
+			}
+
+			f, err := manifest.CreateEntry(zipFile, filepath.Join(zipPath, file.Name()), a.opts.EncryptionSecret)
+			if err == nil {
+				_, err = f.Write(dat)
+			}
+			if err != nil {
+				return err
+			}
+			manifest.Attachments.Files++
+			manifest.Attachments.Size += file.Size()
+			if size > 10*1024*1024 {
+				if err := zipFile.Flush(); err != nil {
+					return err
+				}
+				size = 0
+				a.progress(manifest)
+			}
+		} else if file.IsDir() {
+			newBase := filepath.Join(uri, file.Name(), "")
+			if err := a.addFiles(zipFile, newBase, filepath.Join(zipPath, file.Name()), manifest); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
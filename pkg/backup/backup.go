@@ -0,0 +1,260 @@
+// Package backup writes the Deskpro databases and attachments into a zip archive with a manifest, on the local disk
+// or streamed to a remote target. It's what the 'dputils backup' command runs.
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alexmullins/zip"
+	"github.com/deskpro/dputils/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// Options of a backup
+type Options struct {
+	// DeskproPath and PhpPath locate the Deskpro instance to back up, DeskproConfig is its flat config
+	// as returned by util.Config.GetDeskproConfig
+	DeskproPath   string
+	PhpPath       string
+	DeskproConfig map[string]string
+
+	// Target is a dir, a zip file name, a remote target URL (s3, sftp, http) or "public" to save the archive
+	// into the public assets dir of Deskpro
+	Target string
+	// FilePrefix is the prefix of the archive name when Target is a dir, it defaults to util.BackupArchivePrefix
+	FilePrefix string
+	// What is "database" or "attachments" to back up just that, both are backed up if it's empty
+	What        string
+	DumpOptions util.DumpOptions
+	// VolumeSize splits the archive into volumes of this many bytes if set, see util.VolumeName. The first
+	// volume only holds the manifest, the restore reads the archive from the volumes it lists.
+	VolumeSize int64
+	// EncryptionSecret encrypts every entry of the archive with AES if set
+	EncryptionSecret string
+	// IncrementalSince only backs up attachments added after a previous backup, it's a path to its archive
+	// or manifest, or a timestamp
+	IncrementalSince string
+	// Version of dputils recorded in the manifest
+	Version string
+	// Force starts the backup even if its estimated size doesn't fit on the disk of a local target
+	Force bool
+
+	// Out receives the human readable progress, it's discarded if nil
+	Out io.Writer
+	// Progress is called when a phase starts and finishes, with the progress of attachments and with warnings
+	Progress func(event util.Event)
+}
+
+// Result of a successful backup
+type Result struct {
+	// Location is where the archive was saved, credentials of remote targets are redacted
+	Location string
+	Manifest *util.Manifest
+}
+
+type archiver struct {
+	ctx  context.Context
+	opts Options
+	out  io.Writer
+}
+
+// Run writes the backup archive. Failures are returned as *util.Error, the kind tells what failed. When the backup
+// fails or the context is canceled, dumps are stopped and the partial archive is removed, or its upload is aborted.
+func Run(ctx context.Context, opts Options) (*Result, error) {
+	a := &archiver{ctx: ctx, opts: opts, out: opts.Out}
+	if a.out == nil {
+		a.out = ioutil.Discard
+	}
+	a.opts.DumpOptions.Warning = a.warning
+
+	return a.run()
+}
+
+func (a *archiver) run() (*Result, error) {
+	a.phaseStarted("validate")
+
+	var (
+		targetName string
+		err        error
+	)
+
+	target := a.opts.Target
+	if target == "" {
+		return nil, util.NewError(util.ConfigError, "You must specify a target to create a backup archive", nil)
+	}
+	prefix := a.opts.FilePrefix
+	if prefix == "" {
+		prefix = util.BackupArchivePrefix
+	}
+	fileName := util.BackupArchiveName(prefix, time.Now())
+	if target == "public" {
+		targetName = filepath.Join(a.opts.DeskproPath, "www", "assets", fileName)
+	} else if util.IsRemoteTarget(target) {
+		targetName, err = util.RemoteTargetName(target, fileName)
+		if err != nil {
+			return nil, util.NewError(util.ConfigError, "Can't parse target URL, please check your --target option carefully", err)
+		}
+	} else {
+		target, _ = filepath.Abs(target)
+		targetName = target
+		info, err := os.Stat(target)
+		ext := filepath.Ext(target)
+		if err != nil && os.IsNotExist(err) && ext == "" {
+			a.println("Can't find specified dir")
+		} else if err != nil && os.IsNotExist(err) && ext != "" {
+			targetName = target
+		} else if info.IsDir() {
+			targetName = filepath.Join(targetName, fileName)
+		} else if err != nil {
+			a.println("Can't find target path, please check your --target option carefully")
+			a.println(err)
+		}
+
+	}
+
+	what := a.opts.What
+	if what != "attachments" && what != "database" && what != "" {
+		return nil, util.NewError(util.ConfigError, "Wrong --backup options, you may specify either \"attachments\" or \"database\" or omit the option to backup both", nil)
+	}
+
+	if err = a.checkDiskSpace(targetName); err != nil {
+		return nil, err
+	}
+
+	var (
+		zipFile io.WriteCloser
+		volumes *volumeWriter
+	)
+	if a.opts.VolumeSize > 0 {
+		if !strings.HasSuffix(util.VolumeBaseName(targetName), ".zip") {
+			return nil, util.NewError(util.ConfigError, "The backup archive must be a .zip file to split it into volumes", nil)
+		}
+		a.printf("Backing up to %s in volumes of %d bytes\n", util.RedactUrl(util.VolumeName(targetName, 1)), a.opts.VolumeSize)
+		volumes = newVolumeWriter(targetName, a.opts.VolumeSize)
+		zipFile = volumes
+	} else {
+		a.println("Backing up to " + util.RedactUrl(targetName))
+		if zipFile, err = openTarget(targetName, a.uploadSize(targetName)); err != nil {
+			return nil, util.NewError(util.ConfigError, "Could not create backup archive", err)
+		}
+	}
+	a.phaseFinished("validate")
+
+	manifest, err := a.writeArchive(zipFile)
+	if err == nil && volumes != nil {
+		if err = volumes.writeIndex(manifest); err != nil {
+			err = util.NewError(util.GeneralError, "Failed to save the first volume of the backup archive", err)
+		}
+	}
+	if err != nil {
+		// a partial archive can't be restored, don't leave it behind
+		var removeErr error
+		if volumes != nil {
+			removeErr = volumes.abort(err)
+		} else {
+			removeErr = abortTarget(zipFile, targetName, err)
+		}
+		if removeErr != nil {
+			log.Warning("Failed to remove partial backup archive ", removeErr)
+		}
+
+		if a.ctx.Err() != nil {
+			a.println("The backup was interrupted, the partial archive was removed")
+			return nil, util.NewError(util.CanceledError, "The backup was interrupted", a.ctx.Err())
+		}
+		return nil, err
+	}
+
+	if target == "public" {
+		targetName = "http://your-deskpro-url/assets/" + fileName
+	}
+	if volumes != nil {
+		targetName = util.VolumeName(targetName, 1)
+	}
+
+	return &Result{Location: util.RedactUrl(targetName), Manifest: manifest}, nil
+}
+
+// writeArchive writes the dumps, attachments and manifest selected by What into the archive and closes it
+func (a *archiver) writeArchive(zipFile io.WriteCloser) (*util.Manifest, error) {
+	zipFileWriter := zip.NewWriter(zipFile)
+	what := a.opts.What
+	manifest := util.NewManifest(a.opts.Version, a.opts.DeskproPath, a.opts.EncryptionSecret != "")
+	if what == "database" || what == "" {
+		for _, dbType := range []string{"", "audit", "voice", "system"} {
+			if err := a.addDump(dbType, zipFileWriter, manifest); err != nil {
+				return nil, err
+			}
+		}
+		a.addMetadata(zipFileWriter, manifest)
+	}
+	if what == "attachments" || what == "" {
+		var err error
+		if a.opts.IncrementalSince != "" {
+			err = a.addIncrementalAttachments(zipFileWriter, manifest)
+		} else {
+			err = a.addAttachments(zipFileWriter, manifest)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	a.phaseStarted("archive")
+	if err := manifest.Save(zipFileWriter); err != nil {
+		return nil, util.NewError(util.GeneralError, "Failed to write the backup manifest", err)
+	}
+
+	if err := zipFileWriter.Close(); err != nil {
+		return nil, util.NewError(util.GeneralError, "Failed to finish the backup archive", err)
+	}
+	if err := zipFile.Close(); err != nil {
+		return nil, util.NewError(util.GeneralError, "Failed to save the backup archive", err)
+	}
+
+	a.phaseFinished("archive")
+
+	return manifest, nil
+}
+
+func (a *archiver) println(args ...interface{}) {
+	fmt.Fprintln(a.out, args...)
+}
+
+func (a *archiver) printf(format string, args ...interface{}) {
+	fmt.Fprintf(a.out, format, args...)
+}
+
+func (a *archiver) event(e util.Event) {
+	if a.opts.Progress != nil {
+		a.opts.Progress(e)
+	}
+}
+
+func (a *archiver) phaseStarted(phase string) {
+	a.event(util.Event{Type: util.EventPhaseStarted, Phase: phase})
+}
+
+func (a *archiver) phaseFinished(phase string) {
+	a.event(util.Event{Type: util.EventPhaseFinished, Phase: phase})
+}
+
+func (a *archiver) warning(message string) {
+	a.event(util.Event{Type: util.EventWarning, Message: message})
+}
+
+func (a *archiver) progress(manifest *util.Manifest) {
+	a.event(util.Event{
+		Type:  util.EventProgress,
+		Phase: "attachments",
+		Files: int64(manifest.Attachments.Files),
+		Bytes: manifest.Attachments.Size,
+	})
+}
@@ -0,0 +1,93 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/deskpro/dputils/util"
+)
+
+func Test_resolveIncrementalSince(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	manifest := util.NewManifest("v0.1", "", false)
+	manifest.Attachments.Included = true
+	manifest.Attachments.LastBlobId = 42
+	manifestPath := filepath.Join(t.TempDir(), util.ManifestName)
+	data, _ := json.Marshal(manifest)
+	_ = os.WriteFile(manifestPath, data, 0644)
+
+	sinceBlobId, err := resolveIncrementalSince(db, manifestPath)
+	if err != nil || sinceBlobId != 42 {
+		t.Errorf("Expected blob id 42 from the manifest, got %d (%v)", sinceBlobId, err)
+	}
+
+	expectedSql := `SELECT COALESCE\(MAX\(id\), 0\) FROM blobs WHERE date_created < \?`
+	mock.ExpectQuery(expectedSql).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+
+	sinceBlobId, err = resolveIncrementalSince(db, "2021-01-31 23:00:00")
+	if err != nil || sinceBlobId != 7 {
+		t.Errorf("Expected blob id 7 from the database, got %d (%v)", sinceBlobId, err)
+	}
+
+	if _, err = resolveIncrementalSince(db, "yesterday"); err == nil {
+		t.Error("Expected an error for a wrong --incremental-since value")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRunValidatesOptions(t *testing.T) {
+	if _, err := Run(context.Background(), Options{}); util.ErrorKindOf(err) != util.ConfigError {
+		t.Errorf("Expected a config error without a target, got %v", err)
+	}
+
+	target := filepath.Join(t.TempDir(), "backup.zip")
+	if _, err := Run(context.Background(), Options{Target: target, What: "everything"}); util.ErrorKindOf(err) != util.ConfigError {
+		t.Errorf("Expected a config error for a wrong What, got %v", err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Error("The archive shouldn't be created when the options are wrong")
+	}
+}
+
+func TestRunChecksDiskSpace(t *testing.T) {
+	dpPath := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dpPath, "attachments"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// a sparse file larger than any test disk
+	huge := filepath.Join(dpPath, "attachments", "huge")
+	if err := os.WriteFile(huge, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(huge, 1<<43); err != nil {
+		t.Skip("Sparse files aren't supported: ", err)
+	}
+
+	target := filepath.Join(t.TempDir(), "backup.zip")
+	opts := Options{Target: target, What: "attachments", DeskproPath: dpPath, DeskproConfig: map[string]string{}}
+	if _, err := Run(context.Background(), opts); util.ErrorKindOf(err) != util.ConfigError {
+		t.Errorf("Expected a config error for an archive which doesn't fit, got %v", err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Error("The archive shouldn't be created when it doesn't fit")
+	}
+
+	opts.Force = true
+	a := &archiver{ctx: context.Background(), opts: opts, out: ioutil.Discard}
+	if err := a.checkDiskSpace(target); err != nil {
+		t.Errorf("--force should start the backup anyway, got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package backup
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/alexmullins/zip"
+	"github.com/deskpro/dputils/util"
+)
+
+func (a *archiver) addDump(dbType string, zipFile *zip.Writer, manifest *util.Manifest) error {
+
+	var prefix string
+	if dbType == "" {
+		prefix = "database"
+	} else {
+		prefix = "database_advanced." + dbType
+	}
+	dpConfig := a.opts.DeskproConfig
+	databaseUrl := util.GetMysqlUrlFromConfig(dpConfig, prefix)
+	if databaseUrl.User.Username() == "" {
+		return nil
+	}
+
+	dbName := "database"
+	if dbType != "" {
+		dbName += "_" + dbType
+	}
+
+	dbManifestType := dbType
+	if dbManifestType == "" {
+		dbManifestType = "default"
+	}
+	a.phaseStarted("database_" + dbManifestType)
+
+	a.println("Dumping " + dbName)
+
+	var binlog *util.BinlogCoordinates
+	zipWriter, err := manifest.CreateEntry(zipFile, prefix+".sql", a.opts.EncryptionSecret)
+	if err == nil {
+		binlog, err = util.DumpDatabase(a.ctx, dpConfig, databaseUrl, a.opts.DumpOptions, zipWriter)
+	}
+	if err != nil {
+		return util.NewError(util.DumpError, "Failed to write the "+dbName+" dump file to zip archive", err)
+	}
+
+	db := util.ManifestDatabase{
+		Type:    dbManifestType,
+		Name:    strings.TrimLeft(databaseUrl.Path, "/"),
+		Entry:   prefix + ".sql",
+		Profile: a.opts.DumpOptions.Profile,
+		Binlog:  binlog,
+	}
+	if tables := a.opts.DumpOptions.Tables; !tables.IsZero() {
+		db.Tables = &tables
+		a.println("\tTables: " + tables.String())
+	}
+	manifest.Databases = append(manifest.Databases, db)
+	if binlog != nil {
+		a.printf("\tBinary log position: %s:%d\n", binlog.File, binlog.Position)
+	}
+
+	a.println("\tDone writing the " + dbName + " dump file to zip archive")
+	a.phaseFinished("database_" + dbManifestType)
+
+	return nil
+}
+
+func (a *archiver) addMetadata(zipFile *zip.Writer, manifest *util.Manifest) {
+	out, err := exec.CommandContext(a.ctx, a.opts.PhpPath, filepath.Join(a.opts.DeskproPath, "bin", "console"), "dp:utility:deskpro-horizon-check-reqs").Output()
+	if err != nil {
+		if exitError, ok := err.(*exec.ExitError); ok {
+			if exitError.ExitCode() == 1 {
+				// exit code of 1 means the command does not exist
+				return
+			}
+		} else {
+			// couldn't get exit code
+			return
+		}
+	}
+
+	a.phaseStarted("metadata")
+	defer a.phaseFinished("metadata")
+
+	a.println("Writing metadata")
+
+	f, err := manifest.CreateEntry(zipFile, "v5_metadata.json", a.opts.EncryptionSecret)
+	if err != nil {
+		a.warning("Failed writing metadata: " + err.Error())
+		a.println(err)
+		a.println("\tFailed writing metadata")
+		return
+	}
+	_, err = f.Write(out)
+	if err != nil {
+		a.warning("Failed writing metadata: " + err.Error())
+		a.println(err)
+		a.println("\tFailed writing metadata")
+		return
+	}
+
+	a.println("\tDone writing metadata")
+}
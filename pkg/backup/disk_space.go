@@ -0,0 +1,118 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/deskpro/dputils/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// checkDiskSpace refuses to start a backup to a local target whose estimated size doesn't fit on its disk, unless
+// Force is set. Remote targets are streamed and don't need local disk space.
+func (a *archiver) checkDiskSpace(targetName string) error {
+	if util.IsRemoteTarget(targetName) {
+		return nil
+	}
+
+	size, err := a.estimateSize()
+	if err != nil {
+		log.Warning("Failed to estimate the size of the backup ", err)
+		a.warning("Can't estimate the size of the backup, the free disk space isn't checked")
+		a.println("\tCan't estimate the size of the backup, the free disk space isn't checked")
+		return nil
+	}
+
+	usages, err := util.EstimateDiskUsage([]util.SpaceRequirement{
+		{Dir: filepath.Dir(targetName), Purpose: "backup archive", Bytes: size},
+	})
+	if err != nil {
+		log.Warning("Failed to read the free disk space ", err)
+		return nil
+	}
+	for _, usage := range usages {
+		a.println("\tDisk space " + usage.String())
+	}
+
+	err = util.DiskSpaceError(usages)
+	if err != nil && a.opts.Force {
+		a.warning(err.Error())
+		a.println("\tWarning: the backup may not fit on the disk, starting anyway because of --force")
+		return nil
+	}
+
+	return err
+}
+
+// uploadSize estimates the size of an archive uploaded to S3, whose part size depends on it. It's 0 for other
+// targets, targets with a part_size or if it can't be estimated.
+func (a *archiver) uploadSize(targetName string) int64 {
+	if !strings.HasPrefix(strings.ToLower(targetName), "s3://") || strings.Contains(targetName, "part_size=") {
+		return 0
+	}
+
+	size, err := a.estimateSize()
+	if err != nil {
+		log.Warning("Failed to estimate the size of the backup ", err)
+		a.warning(fmt.Sprintf(
+			"Can't estimate the size of the backup, the S3 upload is limited to %s unless part_size is set",
+			util.FormatSize(10000*util.DefaultS3PartSize),
+		))
+		return 0
+	}
+
+	return size
+}
+
+// estimateSize adds up the size of the databases and attachments the backup will write. Dumps and attachments are
+// counted uncompressed, so the archive is usually smaller.
+func (a *archiver) estimateSize() (int64, error) {
+	var size int64
+	what := a.opts.What
+	if what == "database" || what == "" {
+		databases, err := util.DeskproDatabasesSize(a.ctx, a.opts.DeskproConfig)
+		if err != nil {
+			return 0, err
+		}
+		size += databases
+	}
+
+	if what == "attachments" || what == "" {
+		var (
+			attachments int64
+			err         error
+		)
+		if a.opts.IncrementalSince != "" {
+			attachments, err = a.incrementalAttachmentsSize()
+		} else {
+			attachments, err = util.DirSize(util.AttachmentsPath(a.opts.DeskproConfig, a.opts.DeskproPath))
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+		size += attachments
+	}
+
+	return size, nil
+}
+
+// incrementalAttachmentsSize is the size of the filesystem blobs added since the IncrementalSince backup
+func (a *archiver) incrementalAttachmentsSize() (int64, error) {
+	db, err := util.GetMysqlConnectionFromConfig(a.opts.DeskproConfig, "database")
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	sinceBlobId, err := resolveIncrementalSince(db, a.opts.IncrementalSince)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	err = db.QueryRowContext(a.ctx, "SELECT COALESCE(SUM(filesize), 0) FROM blobs WHERE id > ? AND storage_loc = 'fs'", sinceBlobId).Scan(&size)
+
+	return size, err
+}
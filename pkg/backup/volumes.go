@@ -0,0 +1,187 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/alexmullins/zip"
+	"github.com/deskpro/dputils/util"
+)
+
+// volumeWriter splits the archive into volumes of size bytes, named by util.VolumeName starting with the
+// second volume. The first volume is written by writeIndex once the archive is complete, it holds the manifest
+// with the list of the other volumes.
+type volumeWriter struct {
+	archive string
+	size    int64
+
+	current io.WriteCloser
+	written int64
+	hash    hash.Hash
+	// names of every opened volume, so they can be removed if the backup fails
+	names   []string
+	volumes []util.ManifestVolume
+}
+
+func newVolumeWriter(archive string, size int64) *volumeWriter {
+	return &volumeWriter{archive: archive, size: size}
+}
+
+func (w *volumeWriter) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		if w.current == nil {
+			if err := w.next(); err != nil {
+				return total, err
+			}
+		}
+
+		chunk := p
+		if rest := w.size - w.written; int64(len(chunk)) > rest {
+			chunk = chunk[:rest]
+		}
+		n, err := w.current.Write(chunk)
+		w.hash.Write(chunk[:n])
+		w.written += int64(n)
+		total += n
+		p = p[n:]
+		if err != nil {
+			return total, err
+		}
+
+		if w.written == w.size {
+			if err = w.finish(); err != nil {
+				return total, err
+			}
+		}
+	}
+
+	return total, nil
+}
+
+// next opens the next volume
+func (w *volumeWriter) next() error {
+	name := util.VolumeName(w.archive, len(w.volumes)+2)
+	f, err := openTarget(name, w.size)
+	if err != nil {
+		return fmt.Errorf("can't create volume %s: %s", util.RedactUrl(name), err)
+	}
+
+	w.current, w.written, w.hash = f, 0, sha256.New()
+	w.names = append(w.names, name)
+
+	return nil
+}
+
+// finish closes the current volume and records it for the manifest
+func (w *volumeWriter) finish() error {
+	err := w.current.Close()
+	w.current = nil
+	w.volumes = append(w.volumes, util.ManifestVolume{
+		Name:   util.VolumeBaseName(w.names[len(w.names)-1]),
+		Size:   w.written,
+		Sha256: fmt.Sprintf("%x", w.hash.Sum(nil)),
+	})
+
+	return err
+}
+
+func (w *volumeWriter) Close() error {
+	if w.current == nil {
+		return nil
+	}
+
+	return w.finish()
+}
+
+// writeIndex writes the first volume with the manifest listing the other volumes
+func (w *volumeWriter) writeIndex(manifest *util.Manifest) error {
+	name := util.VolumeName(w.archive, 1)
+	f, err := openTarget(name, 0)
+	if err != nil {
+		return err
+	}
+	w.names = append(w.names, name)
+
+	manifest.Volumes = w.volumes
+	zipWriter := zip.NewWriter(f)
+	if err = manifest.Save(zipWriter); err == nil {
+		err = zipWriter.Close()
+	}
+	if err != nil {
+		w.current = f
+		return err
+	}
+
+	return f.Close()
+}
+
+// abort removes every volume written so far
+func (w *volumeWriter) abort(err error) error {
+	var (
+		names     = w.names
+		removeErr error
+	)
+	if w.current != nil {
+		removeErr = abortTarget(w.current, names[len(names)-1], err)
+		w.current = nil
+		names = names[:len(names)-1]
+	}
+
+	for _, name := range names {
+		if err := removeTarget(name); err != nil && removeErr == nil {
+			removeErr = err
+		}
+	}
+
+	return removeErr
+}
+
+// openTarget creates a local file or starts an upload to a remote target of about expectedSize bytes, 0 if it's
+// unknown
+func openTarget(name string, expectedSize int64) (io.WriteCloser, error) {
+	if util.IsRemoteTarget(name) {
+		return util.OpenSizedRemoteTarget(name, expectedSize)
+	}
+
+	return os.Create(name)
+}
+
+// abortTarget stops writing a partial archive and removes it, or aborts its upload
+func abortTarget(w io.WriteCloser, name string, err error) error {
+	if remote, ok := w.(util.RemoteTarget); ok {
+		return remote.Abort(err)
+	}
+
+	_ = w.Close()
+
+	return os.Remove(name)
+}
+
+// removeTarget removes a completely written local file or remote archive
+func removeTarget(name string) error {
+	if !util.IsRemoteTarget(name) {
+		return os.Remove(name)
+	}
+
+	u, err := url.Parse(name)
+	if err != nil {
+		return err
+	}
+	file := path.Base(u.Path)
+	u.Path = strings.TrimSuffix(path.Dir(u.Path), "/") + "/"
+
+	dir, err := util.OpenTargetDir(u.String())
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Remove(file)
+}
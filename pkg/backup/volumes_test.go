@@ -0,0 +1,55 @@
+package backup
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/deskpro/dputils/util"
+)
+
+func TestVolumeWriter(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "deskpro-backup.zip")
+	w := newVolumeWriter(archive, 4)
+
+	data := []byte("0123456789")
+	if _, err := w.Write(data[:3]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data[3:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := util.NewManifest("v0.1", "", false)
+	if err := w.writeIndex(manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(w.volumes) != 3 || w.volumes[0].Name != "deskpro-backup.002.zip" || w.volumes[2].Size != 2 {
+		t.Fatalf("Unexpected volumes %+v", w.volumes)
+	}
+	var joined []byte
+	for i := range w.volumes {
+		content, _ := os.ReadFile(util.VolumeName(archive, i+2))
+		joined = append(joined, content...)
+	}
+	if !bytes.Equal(joined, data) {
+		t.Errorf("Unexpected joined volumes %q", joined)
+	}
+
+	index, err := util.ReadManifestFile(util.VolumeName(archive, 1))
+	if err != nil || len(index.Volumes) != 3 {
+		t.Errorf("First volume doesn't list the volumes: %+v %v", index, err)
+	}
+
+	if err = w.abort(nil); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := os.ReadDir(filepath.Dir(archive)); len(files) != 0 {
+		t.Errorf("Expected the volumes to be removed, found %d files", len(files))
+	}
+}
@@ -0,0 +1,273 @@
+// Package binlog copies the binary logs of the Deskpro database server to a backup target, so a restore can
+// replay them on top of a full backup up to a point in time. It's what the 'dputils binlog archive' command runs.
+package binlog
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/deskpro/dputils/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// ArchiveOptions of the binary log archiver
+type ArchiveOptions struct {
+	// DeskproConfig is the flat config of the Deskpro instance as returned by util.Config.GetDeskproConfig, the
+	// binary logs of its database server are archived
+	DeskproConfig map[string]string
+	// Target is the local, s3:// or sftp:// dir the binary logs are copied to, under their names on the server
+	Target string
+	// TmpDir is where binary logs are read to before they're copied to the target. Defaults to the system temp dir.
+	TmpDir string
+
+	// Interval is how often new binary logs are looked for, it defaults to a minute
+	Interval time.Duration
+	// FlushInterval is how often the server is asked to start a new binary log (FLUSH BINARY LOGS), so the events
+	// of the log it writes to are archived too. It's how many minutes of changes can be lost, 0 never flushes.
+	FlushInterval time.Duration
+	// Once archives the binary logs the server doesn't write to anymore and returns
+	Once bool
+
+	// Out receives the human readable progress, it's discarded if nil
+	Out io.Writer
+}
+
+type archiver struct {
+	ctx  context.Context
+	opts ArchiveOptions
+	out  io.Writer
+	conn *sql.DB
+	// gap is the first log after a gap in the archive, it's only reported once
+	gap string
+}
+
+// Archive copies the binary logs the server has finished writing to the target, then looks for new ones every
+// Interval until the context is canceled. Logs which are already on the target are skipped, so the archiver can be
+// restarted at any time. A failed copy is logged and tried again on the next round, unless Once is set.
+func Archive(ctx context.Context, opts ArchiveOptions) error {
+	a := &archiver{ctx: ctx, opts: opts, out: opts.Out}
+	if a.out == nil {
+		a.out = ioutil.Discard
+	}
+	if a.opts.TmpDir == "" {
+		a.opts.TmpDir = os.TempDir()
+	}
+	if a.opts.Interval <= 0 {
+		a.opts.Interval = time.Minute
+	}
+
+	if err := a.validate(); err != nil {
+		return err
+	}
+	defer a.conn.Close()
+
+	// the first round flushes, so the changes up to the start are archived right away
+	var lastFlush time.Time
+	for {
+		if a.opts.FlushInterval > 0 && time.Since(lastFlush) >= a.opts.FlushInterval {
+			if _, err := a.conn.ExecContext(ctx, "FLUSH BINARY LOGS"); err != nil && ctx.Err() == nil {
+				log.Warning("Failed to flush binary logs ", err)
+				fmt.Fprintln(a.out, "Failed to start a new binary log, FLUSH BINARY LOGS requires the RELOAD privilege")
+				fmt.Fprintln(a.out, err)
+			}
+			lastFlush = time.Now()
+		}
+
+		err := a.archive()
+		if ctx.Err() != nil {
+			return util.NewError(util.CanceledError, "The binary log archiving was interrupted", ctx.Err())
+		}
+		if a.opts.Once {
+			return err
+		}
+		if err != nil {
+			log.Warning("Failed to archive binary logs ", err)
+			fmt.Fprintln(a.out, "Failed to archive binary logs, trying again in", a.opts.Interval)
+			fmt.Fprintln(a.out, err)
+		}
+
+		timer := time.NewTimer(a.opts.Interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+func (a *archiver) validate() error {
+	target := a.opts.Target
+	if target == "" {
+		return util.NewError(util.ConfigError, "Specify the --target dir to archive the binary logs to", nil)
+	}
+	if !util.IsRemoteTarget(target) {
+		if info, err := os.Stat(target); err != nil || !info.IsDir() {
+			return util.NewError(util.ConfigError, "Can't find the target dir "+target, err)
+		}
+	}
+	if _, err := a.listTarget(); err != nil {
+		return util.NewError(util.ConfigError, "Can't list the binary logs of the target", err)
+	}
+
+	conn, err := util.GetMysqlConnectionFromConfig(a.opts.DeskproConfig, "database")
+	if err != nil {
+		return util.NewError(util.ConfigError, "Failed to connect to the Deskpro database", err)
+	}
+	if _, err = util.ListBinaryLogs(a.ctx, conn); err != nil {
+		_ = conn.Close()
+		return util.NewError(util.ConfigError, "Failed to list the binary logs, make sure binary logging is enabled and the user has the REPLICATION CLIENT privilege", err)
+	}
+	a.conn = conn
+
+	return nil
+}
+
+// archive copies the binary logs which are complete and not on the target yet
+func (a *archiver) archive() error {
+	logs, err := util.ListBinaryLogs(a.ctx, a.conn)
+	if err != nil {
+		return err
+	}
+	archived, err := a.listTarget()
+	if err != nil {
+		return err
+	}
+
+	pending := pendingLogs(logs, archived)
+	if gap := firstMissingLog(logs, archived); gap != "" && gap != a.gap {
+		a.gap = gap
+		message := "The binary logs before " + gap + " were removed from the server before they were archived, a restore can't replay past the gap"
+		log.Warning(message)
+		fmt.Fprintln(a.out, message)
+	}
+
+	for _, name := range pending {
+		if err = a.copyLog(name); err != nil {
+			return fmt.Errorf("failed to archive binary log %s: %s", name, err)
+		}
+		fmt.Fprintln(a.out, time.Now().Format(time.RFC1123)+"\tArchived "+name)
+	}
+
+	return nil
+}
+
+func (a *archiver) listTarget() ([]string, error) {
+	dir, err := util.OpenTargetDir(a.opts.Target)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	return dir.List()
+}
+
+// copyLog reads the binary log from the server and copies it to the target
+func (a *archiver) copyLog(name string) error {
+	tmpdir, err := ioutil.TempDir(a.opts.TmpDir, "dputils_binlog")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	databaseUrl := util.GetMysqlUrlFromConfig(a.opts.DeskproConfig, "database")
+	path, err := util.FetchBinaryLog(a.ctx, a.opts.DeskproConfig, databaseUrl, name, tmpdir)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if !util.IsRemoteTarget(a.opts.Target) {
+		// a partial copy mustn't look archived
+		dst := filepath.Join(a.opts.Target, name)
+		if err = copyFile(dst+".part", f); err != nil {
+			return err
+		}
+		return os.Rename(dst+".part", dst)
+	}
+
+	targetName, err := util.RemoteTargetName(a.opts.Target, name)
+	if err != nil {
+		return err
+	}
+	w, err := util.OpenRemoteTarget(targetName)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, f); err != nil {
+		_ = w.Abort(err)
+		return err
+	}
+
+	return w.Close()
+}
+
+func copyFile(dst string, r io.Reader) error {
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		_ = f.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+
+	return f.Close()
+}
+
+// pendingLogs returns the logs of the server which aren't archived yet, except the last one the server writes to
+func pendingLogs(logs []string, archived []string) []string {
+	if len(logs) == 0 {
+		return nil
+	}
+
+	isArchived := map[string]bool{}
+	for _, name := range archived {
+		isArchived[name] = true
+	}
+
+	var pending []string
+	for _, name := range logs[:len(logs)-1] {
+		if !isArchived[name] {
+			pending = append(pending, name)
+		}
+	}
+
+	return pending
+}
+
+// firstMissingLog returns the oldest log of the server if the logs between it and the newest archived log were
+// removed from the server before they were archived
+func firstMissingLog(logs []string, archived []string) string {
+	if len(logs) == 0 {
+		return ""
+	}
+	base, first, ok := util.BinlogSequence(logs[0])
+	if !ok {
+		return ""
+	}
+
+	newest := -1
+	for _, name := range archived {
+		if archivedBase, n, ok := util.BinlogSequence(name); ok && archivedBase == base && n > newest {
+			newest = n
+		}
+	}
+	if newest < 0 || first <= newest+1 {
+		return ""
+	}
+
+	return logs[0]
+}
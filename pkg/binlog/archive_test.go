@@ -0,0 +1,32 @@
+package binlog
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_pendingLogs(t *testing.T) {
+	logs := []string{"mysql-bin.000003", "mysql-bin.000004", "mysql-bin.000005"}
+
+	pending := pendingLogs(logs, []string{"mysql-bin.000002", "mysql-bin.000003", "deskpro-backup.2026-10-18_02-00-00.zip"})
+	if strings.Join(pending, ",") != "mysql-bin.000004" {
+		t.Errorf("Unexpected pending logs %v", pending)
+	}
+	if pending = pendingLogs(logs[:1], nil); len(pending) != 0 {
+		t.Errorf("The log the server writes to was archived: %v", pending)
+	}
+}
+
+func Test_firstMissingLog(t *testing.T) {
+	logs := []string{"mysql-bin.000003", "mysql-bin.000004"}
+
+	if gap := firstMissingLog(logs, []string{"mysql-bin.000002"}); gap != "" {
+		t.Errorf("Unexpected gap before %s", gap)
+	}
+	if gap := firstMissingLog(logs, nil); gap != "" {
+		t.Errorf("Unexpected gap before %s", gap)
+	}
+	if gap := firstMissingLog(logs, []string{"mysql-bin.000001"}); gap != "mysql-bin.000003" {
+		t.Errorf("Expected a gap before mysql-bin.000003, got %q", gap)
+	}
+}
@@ -0,0 +1,147 @@
+package compare
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/deskpro/dputils/util"
+)
+
+const (
+	ChecksumNone = "none"
+	// ChecksumTable compares the CHECKSUM TABLE of both tables
+	ChecksumTable = "table"
+	// ChecksumChunked hashes the rows in ranges of the primary key, so the differing rows can be found
+	ChecksumChunked = "chunked"
+)
+
+// maxChunkDifferences is how many differing chunks of a table are listed
+const maxChunkDifferences = 10
+
+// checksumTable compares the CHECKSUM TABLE of the source and dest table
+func checksumTable(ctx context.Context, source *sql.DB, dest *sql.DB, table string) ([]string, error) {
+	var checksums [2]sql.NullInt64
+	for i, db := range []*sql.DB{source, dest} {
+		var name string
+		if err := db.QueryRowContext(ctx, "CHECKSUM TABLE "+util.QuoteIdentifier(table)).Scan(&name, &checksums[i]); err != nil {
+			return nil, err
+		}
+	}
+	if checksums[0] != checksums[1] {
+		return []string{fmt.Sprintf("CHECKSUM TABLE: %d != %d", checksums[0].Int64, checksums[1].Int64)}, nil
+	}
+
+	return nil, nil
+}
+
+// checksumChunks compares the hashes of the rows of both tables in ranges of chunkSize primary key values. Tables
+// without a single integer primary key are hashed as a whole.
+func checksumChunks(ctx context.Context, source *sql.DB, dest *sql.DB, table string, schema tableSchema, chunkSize int64) ([]string, error) {
+	key := schema.primaryKey()
+	if key != "" && !schema.integerColumn(key) {
+		key = ""
+	}
+	query := chunkQuery(table, schema.columns, key)
+
+	if key == "" {
+		difference, err := compareChunk(ctx, source, dest, query)
+		if err != nil || difference == "" {
+			return nil, err
+		}
+		return []string{"rows: " + difference}, nil
+	}
+
+	var (
+		differences []string
+		differing   int
+	)
+	start, ok, err := nextKey(ctx, source, dest, table, key, nil)
+	for ok && err == nil {
+		end := start + chunkSize
+		var difference string
+		if difference, err = compareChunk(ctx, source, dest, query, start, end); err != nil {
+			break
+		}
+		if difference != "" {
+			differing++
+			if differing <= maxChunkDifferences {
+				differences = append(differences, fmt.Sprintf("%s %d-%d: %s", key, start, end-1, difference))
+			}
+		}
+		start, ok, err = nextKey(ctx, source, dest, table, key, &end)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if differing > maxChunkDifferences {
+		differences = append(differences, fmt.Sprintf("and %d more ranges differ", differing-maxChunkDifferences))
+	}
+
+	return differences, nil
+}
+
+// chunkQuery counts the rows and XORs the hashes of their values, a NULL and an empty value hash differently
+func chunkQuery(table string, columns []string, key string) string {
+	nulls := make([]string, len(columns))
+	for i, column := range columns {
+		nulls[i] = "ISNULL(" + column + ")"
+	}
+	row := "CONCAT_WS('#', " + strings.Join(columns, ", ") + ", CONCAT(" + strings.Join(nulls, ", ") + "))"
+
+	query := "SELECT COUNT(*), COALESCE(BIT_XOR(CAST(CONV(LEFT(MD5(" + row + "), 16), 16, 10) AS UNSIGNED)), 0) FROM " +
+		util.QuoteIdentifier(table)
+	if key != "" {
+		query += " WHERE " + key + " >= ? AND " + key + " < ?"
+	}
+
+	return query
+}
+
+// compareChunk runs the chunk query on both databases and describes the difference, it's empty if they match
+func compareChunk(ctx context.Context, source *sql.DB, dest *sql.DB, query string, args ...interface{}) (string, error) {
+	var (
+		counts [2]int64
+		hashes [2]string
+	)
+	for i, db := range []*sql.DB{source, dest} {
+		if err := db.QueryRowContext(ctx, query, args...).Scan(&counts[i], &hashes[i]); err != nil {
+			return "", err
+		}
+	}
+
+	if counts[0] != counts[1] {
+		return fmt.Sprintf("%d rows in the source, %d in the destination", counts[0], counts[1]), nil
+	} else if hashes[0] != hashes[1] {
+		return "different values", nil
+	}
+
+	return "", nil
+}
+
+// nextKey returns the smallest key value of both tables from the given value on, false if there are no more rows
+func nextKey(ctx context.Context, source *sql.DB, dest *sql.DB, table string, key string, from *int64) (int64, bool, error) {
+	query := "SELECT MIN(" + key + ") FROM " + util.QuoteIdentifier(table)
+	var args []interface{}
+	if from != nil {
+		query += " WHERE " + key + " >= ?"
+		args = append(args, *from)
+	}
+
+	var (
+		next  int64
+		found bool
+	)
+	for _, db := range []*sql.DB{source, dest} {
+		var value sql.NullInt64
+		if err := db.QueryRowContext(ctx, query, args...).Scan(&value); err != nil {
+			return 0, false, err
+		}
+		if value.Valid && (!found || value.Int64 < next) {
+			next, found = value.Int64, true
+		}
+	}
+
+	return next, found, nil
+}
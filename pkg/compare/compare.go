@@ -0,0 +1,223 @@
+// Package compare compares the schema, the row counts and optionally the checksums of the tables of two Deskpro
+// databases, e.g. to confirm a restored database matches its source. It's what the 'dputils compare' command runs.
+package compare
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/deskpro/dputils/util"
+)
+
+type Status string
+
+const (
+	StatusMatch   Status = "match"
+	StatusDiffers Status = "differs"
+	// StatusMissing means the table is only in the source, StatusExtra that it's only in the destination
+	StatusMissing Status = "missing"
+	StatusExtra   Status = "extra"
+)
+
+// Options of a comparison
+type Options struct {
+	// Source is the database to compare the destination with, SourceDump is an SQL dump read instead of it
+	Source     util.MysqlConn
+	SourceDump string
+	Dest       util.MysqlConn
+	// Tables selects the compared tables, every table is compared if it's zero
+	Tables util.TableFilter
+	// Checksum is ChecksumNone (the default), ChecksumTable or ChecksumChunked. Checksums need a Source database,
+	// they're only computed for tables with the same schema.
+	Checksum string
+	// ChunkSize is how many primary key values ChecksumChunked hashes at once, 10000 if it's 0
+	ChunkSize int64
+
+	// Out receives the human readable progress, it's discarded if nil
+	Out io.Writer
+}
+
+// TableResult is the comparison of one table. Differences describe the schema and checksum differences, the
+// rows of a table which doesn't exist on one side are 0.
+type TableResult struct {
+	Name       string `json:"name"`
+	Status     Status `json:"status"`
+	SourceRows int64  `json:"source_rows"`
+	DestRows   int64  `json:"dest_rows"`
+	// Checksum is "match" or "differs", it's empty if the checksums weren't compared
+	Checksum    string   `json:"checksum,omitempty"`
+	Differences []string `json:"differences,omitempty"`
+}
+
+// Report are the results of every table, sorted by name
+type Report struct {
+	Match  bool          `json:"match"`
+	Tables []TableResult `json:"tables"`
+}
+
+// Differences returns the number of tables which don't match
+func (r *Report) Differences() int {
+	differences := 0
+	for _, table := range r.Tables {
+		if table.Status != StatusMatch {
+			differences++
+		}
+	}
+
+	return differences
+}
+
+type comparer struct {
+	ctx  context.Context
+	opts Options
+	out  io.Writer
+}
+
+// Run compares the databases. Failures are returned as *util.Error: a wrong option is a ConfigError, failing to
+// read the source a SourceError and failing to read the destination a ConfigError.
+func Run(ctx context.Context, opts Options) (*Report, error) {
+	c := &comparer{ctx: ctx, opts: opts, out: opts.Out}
+	if c.out == nil {
+		c.out = ioutil.Discard
+	}
+	if c.opts.Checksum == "" {
+		c.opts.Checksum = ChecksumNone
+	}
+	if c.opts.ChunkSize == 0 {
+		c.opts.ChunkSize = 10000
+	}
+
+	return c.run()
+}
+
+func (c *comparer) run() (*Report, error) {
+	switch c.opts.Checksum {
+	case ChecksumNone, ChecksumTable, ChecksumChunked:
+	default:
+		return nil, util.NewError(util.ConfigError, "Wrong --checksum option, you may specify either \"none\", \"table\" or \"chunked\"", nil)
+	}
+	if c.opts.ChunkSize < 1 {
+		return nil, util.NewError(util.ConfigError, "--chunk-size must be at least 1", nil)
+	}
+	if c.opts.Checksum != ChecksumNone && c.opts.SourceDump != "" {
+		return nil, util.NewError(util.ConfigError, "Checksums can't be computed from a dump, compare with a source database", nil)
+	}
+
+	var source database = &liveDatabase{conn: c.opts.Source.Conn}
+	if c.opts.SourceDump != "" {
+		fmt.Fprintln(c.out, "Reading the source dump "+c.opts.SourceDump)
+		dump, err := openDump(c.opts.SourceDump)
+		if err != nil {
+			return nil, util.NewError(util.SourceError, "Failed to read the source dump", err)
+		}
+		source = dump
+	}
+	dest := &liveDatabase{conn: c.opts.Dest.Conn}
+
+	sourceTables, err := source.tables(c.ctx)
+	if err != nil {
+		return nil, c.failed(util.SourceError, "Failed to read the tables of the source database", err)
+	}
+	destTables, err := dest.tables(c.ctx)
+	if err != nil {
+		return nil, c.failed(util.ConfigError, "Failed to read the tables of the destination database", err)
+	}
+
+	names := tableNames(sourceTables, destTables, c.opts.Tables)
+	fmt.Fprintf(c.out, "Comparing %d tables\n", len(names))
+
+	report := &Report{}
+	for _, name := range names {
+		result, err := c.compareTable(name, source, dest, sourceTables, destTables)
+		if err != nil {
+			return nil, err
+		}
+		report.Tables = append(report.Tables, result)
+	}
+	report.Match = report.Differences() == 0
+
+	return report, nil
+}
+
+func (c *comparer) compareTable(name string, source database, dest database, sourceTables map[string]string, destTables map[string]string) (TableResult, error) {
+	var err error
+	result := TableResult{Name: name, Status: StatusMatch}
+	sourceCreate, inSource := sourceTables[name]
+	destCreate, inDest := destTables[name]
+
+	if inSource {
+		if result.SourceRows, err = source.rowCount(c.ctx, name); err != nil {
+			return result, c.failed(util.SourceError, "Failed to count the rows of "+name+" in the source database", err)
+		}
+	}
+	if inDest {
+		if result.DestRows, err = dest.rowCount(c.ctx, name); err != nil {
+			return result, c.failed(util.ConfigError, "Failed to count the rows of "+name+" in the destination database", err)
+		}
+	}
+	if !inDest {
+		result.Status = StatusMissing
+		return result, nil
+	}
+	if !inSource {
+		result.Status = StatusExtra
+		return result, nil
+	}
+
+	sourceSchema, destSchema := parseSchema(sourceCreate), parseSchema(destCreate)
+	result.Differences = schemaDifferences(sourceSchema, destSchema)
+	if len(result.Differences) > 0 || result.SourceRows != result.DestRows {
+		result.Status = StatusDiffers
+	}
+	if c.opts.Checksum == ChecksumNone || len(result.Differences) > 0 {
+		return result, nil
+	}
+
+	fmt.Fprintln(c.out, "\tChecksumming "+name)
+	var differences []string
+	if c.opts.Checksum == ChecksumTable {
+		differences, err = checksumTable(c.ctx, c.opts.Source.Conn, c.opts.Dest.Conn, name)
+	} else {
+		differences, err = checksumChunks(c.ctx, c.opts.Source.Conn, c.opts.Dest.Conn, name, sourceSchema, c.opts.ChunkSize)
+	}
+	if err != nil {
+		return result, c.failed(util.GeneralError, "Failed to checksum "+name, err)
+	}
+
+	result.Checksum = string(StatusMatch)
+	if len(differences) > 0 {
+		result.Checksum = string(StatusDiffers)
+		result.Status = StatusDiffers
+		result.Differences = differences
+	}
+
+	return result, nil
+}
+
+// failed returns a CanceledError instead if the comparison was interrupted
+func (c *comparer) failed(kind util.ErrorKind, message string, err error) error {
+	if c.ctx.Err() != nil {
+		return util.NewError(util.CanceledError, "The comparison was interrupted", c.ctx.Err())
+	}
+
+	return util.NewError(kind, message, err)
+}
+
+// tableNames returns the names of the tables of both databases selected by the filter, sorted
+func tableNames(sourceTables map[string]string, destTables map[string]string, filter util.TableFilter) []string {
+	var names []string
+	for name := range sourceTables {
+		names = append(names, name)
+	}
+	for name := range destTables {
+		if _, ok := sourceTables[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return filter.Filter(names)
+}
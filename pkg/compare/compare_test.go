@@ -0,0 +1,130 @@
+package compare
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/deskpro/dputils/util"
+)
+
+const peopleTable = "CREATE TABLE `people` (\n" +
+	"  `id` int(11) NOT NULL AUTO_INCREMENT,\n" +
+	"  `name` varchar(255) DEFAULT NULL,\n" +
+	"  PRIMARY KEY (`id`),\n" +
+	"  KEY `idx_name` (`name`)\n" +
+	") ENGINE=InnoDB AUTO_INCREMENT=3 DEFAULT CHARSET=utf8mb4"
+
+func Test_readDump(t *testing.T) {
+	dump := "-- MySQL dump 10.13\n" +
+		"DROP TABLE IF EXISTS `people`;\n" +
+		peopleTable + ";\n" +
+		"INSERT INTO `people` VALUES (1,'Ann (admin)'),(2,'O\\'Brien, \\\\');\n" +
+		"INSERT INTO `people` VALUES (3,'\"(\"');\n" +
+		"CREATE TABLE `settings` (\n  `name` varchar(50) NOT NULL\n) ENGINE=InnoDB;\n" +
+		"-- Dump completed\n"
+
+	d, err := readDump(strings.NewReader(dump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.creates) != 2 || !strings.HasPrefix(d.creates["people"], "CREATE TABLE `people`") {
+		t.Errorf("Unexpected tables %v", d.creates)
+	}
+	if d.rows["people"] != 3 || d.rows["settings"] != 0 {
+		t.Errorf("Unexpected row counts %v", d.rows)
+	}
+}
+
+func Test_schemaDifferences(t *testing.T) {
+	dest := strings.Replace(peopleTable, "AUTO_INCREMENT=3", "AUTO_INCREMENT=9", 1)
+	if differences := schemaDifferences(parseSchema(peopleTable), parseSchema(dest)); len(differences) != 0 {
+		t.Errorf("The AUTO_INCREMENT counter isn't part of the schema: %v", differences)
+	}
+
+	dest = strings.Replace(dest, "varchar(255)", "varchar(100)", 1)
+	dest = strings.Replace(dest, "  KEY `idx_name` (`name`)\n", "  KEY `idx_email` (`name`)\n", 1)
+	differences := schemaDifferences(parseSchema(peopleTable), parseSchema(dest))
+	expected := []string{
+		"column `name`: `name` varchar(255) DEFAULT NULL != `name` varchar(100) DEFAULT NULL",
+		"KEY `idx_name` is missing in the destination",
+		"KEY `idx_email` is only in the destination",
+	}
+	if strings.Join(differences, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected differences %q", differences)
+	}
+
+	schema := parseSchema(peopleTable)
+	if key := schema.primaryKey(); key != "`id`" || !schema.integerColumn(key) {
+		t.Errorf("Unexpected primary key %s", key)
+	}
+}
+
+func TestRun(t *testing.T) {
+	source, sourceMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	dest, destMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dest.Close()
+
+	for _, mock := range []sqlmock.Sqlmock{sourceMock, destMock} {
+		mock.MatchExpectationsInOrder(false)
+		mock.ExpectQuery("SHOW FULL TABLES").WillReturnRows(
+			sqlmock.NewRows([]string{"Tables_in_deskpro", "Table_type"}).AddRow("people", "BASE TABLE").AddRow("people_view", "VIEW"),
+		)
+		mock.ExpectQuery("SHOW CREATE TABLE `people`").WillReturnRows(
+			sqlmock.NewRows([]string{"Table", "Create Table"}).AddRow("people", peopleTable),
+		)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM `people`")).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT MIN(`id`) FROM `people`") + "$").WillReturnRows(sqlmock.NewRows([]string{"min"}).AddRow(1))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT MIN(`id`) FROM `people` WHERE `id` >= ?")).WithArgs(11).
+			WillReturnRows(sqlmock.NewRows([]string{"min"}).AddRow(nil))
+	}
+	chunk := regexp.QuoteMeta("FROM `people` WHERE `id` >= ? AND `id` < ?")
+	sourceMock.ExpectQuery(chunk).WithArgs(1, 11).WillReturnRows(sqlmock.NewRows([]string{"count", "hash"}).AddRow(2, "1234"))
+	destMock.ExpectQuery(chunk).WithArgs(1, 11).WillReturnRows(sqlmock.NewRows([]string{"count", "hash"}).AddRow(2, "4321"))
+
+	report, err := Run(context.Background(), Options{
+		Source:    util.MysqlConn{Conn: source},
+		Dest:      util.MysqlConn{Conn: dest},
+		Checksum:  ChecksumChunked,
+		ChunkSize: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Match || len(report.Tables) != 1 {
+		t.Fatalf("Expected the people table to differ: %+v", report)
+	}
+	table := report.Tables[0]
+	if table.Status != StatusDiffers || table.Checksum != "differs" || table.SourceRows != 2 || table.DestRows != 2 {
+		t.Errorf("Unexpected result %+v", table)
+	}
+	if len(table.Differences) != 1 || table.Differences[0] != "`id` 1-10: different values" {
+		t.Errorf("Unexpected differences %q", table.Differences)
+	}
+	for _, mock := range []sqlmock.Sqlmock{sourceMock, destMock} {
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestRunValidatesOptions(t *testing.T) {
+	for _, opts := range []Options{
+		{Checksum: "md5"},
+		{Checksum: ChecksumTable, SourceDump: "deskpro.sql"},
+		{ChunkSize: -1},
+	} {
+		if _, err := Run(context.Background(), opts); util.ErrorKindOf(err) != util.ConfigError {
+			t.Errorf("Expected a config error for %+v, got %v", opts, err)
+		}
+	}
+}
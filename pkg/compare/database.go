@@ -0,0 +1,155 @@
+package compare
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/deskpro/dputils/util"
+)
+
+// database is one side of the comparison
+type database interface {
+	// tables returns the CREATE TABLE statement of every base table by its name
+	tables(ctx context.Context) (map[string]string, error)
+	rowCount(ctx context.Context, table string) (int64, error)
+}
+
+// liveDatabase is a database on a MySQL server
+type liveDatabase struct {
+	conn *sql.DB
+}
+
+func (d *liveDatabase) tables(ctx context.Context) (map[string]string, error) {
+	rows, err := d.conn.QueryContext(ctx, "SHOW FULL TABLES")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name, tableType string
+		if err = rows.Scan(&name, &tableType); err != nil {
+			return nil, err
+		}
+		if tableType != "VIEW" {
+			names = append(names, name)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	tables := map[string]string{}
+	for _, name := range names {
+		var table, createTable string
+		if err = d.conn.QueryRowContext(ctx, "SHOW CREATE TABLE "+util.QuoteIdentifier(name)).Scan(&table, &createTable); err != nil {
+			return nil, err
+		}
+		tables[name] = createTable
+	}
+
+	return tables, nil
+}
+
+func (d *liveDatabase) rowCount(ctx context.Context, table string) (int64, error) {
+	var count int64
+	err := d.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+util.QuoteIdentifier(table)).Scan(&count)
+
+	return count, err
+}
+
+var (
+	createTableRe = regexp.MustCompile("^CREATE TABLE (?:IF NOT EXISTS )?`((?:[^`]|``)+)`")
+	insertRe      = regexp.MustCompile("^(?:INSERT|REPLACE)(?: IGNORE)? INTO `((?:[^`]|``)+)`")
+)
+
+// dumpDatabase is an SQL dump created by mysqldump or the native engine, it's read once when it's opened
+type dumpDatabase struct {
+	creates map[string]string
+	rows    map[string]int64
+}
+
+func openDump(path string) (*dumpDatabase, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return readDump(f)
+}
+
+// readDump collects the CREATE TABLE statements of the dump and counts the rows of its INSERT statements
+func readDump(r io.Reader) (*dumpDatabase, error) {
+	d := &dumpDatabase{creates: map[string]string{}, rows: map[string]int64{}}
+	statements := util.NewStatementReader(r)
+	for {
+		statement, err := statements.Next()
+		if err == io.EOF {
+			return d, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if match := createTableRe.FindStringSubmatch(statement); match != nil {
+			d.creates[unquoteIdentifier(match[1])] = statement
+		} else if match := insertRe.FindStringSubmatch(statement); match != nil {
+			if values := strings.Index(statement, " VALUES "); values >= 0 {
+				d.rows[unquoteIdentifier(match[1])] += countTuples(statement[values:])
+			}
+		}
+	}
+}
+
+func (d *dumpDatabase) tables(ctx context.Context) (map[string]string, error) {
+	return d.creates, nil
+}
+
+func (d *dumpDatabase) rowCount(ctx context.Context, table string) (int64, error) {
+	return d.rows[table], nil
+}
+
+func unquoteIdentifier(name string) string {
+	return strings.Replace(name, "``", "`", -1)
+}
+
+// countTuples counts the (...) row tuples of the VALUES of an INSERT statement, parentheses in string literals
+// don't count
+func countTuples(values string) int64 {
+	var (
+		count int64
+		depth int
+		quote byte
+	)
+	for i := 0; i < len(values); i++ {
+		c := values[i]
+		if quote != 0 {
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+
+		switch c {
+		case '\'', '"':
+			quote = c
+		case '(':
+			if depth == 0 {
+				count++
+			}
+			depth++
+		case ')':
+			depth--
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,117 @@
+package compare
+
+import (
+	"regexp"
+	"strings"
+)
+
+var autoIncrementRe = regexp.MustCompile(` AUTO_INCREMENT=\d+`)
+
+// tableSchema is a CREATE TABLE statement split into its definitions (columns, keys and constraints) by name,
+// and its table options
+type tableSchema struct {
+	names       []string
+	definitions map[string]string
+	columns     []string
+	options     string
+}
+
+// parseSchema splits a CREATE TABLE statement as returned by SHOW CREATE TABLE, which is what mysqldump writes.
+// The AUTO_INCREMENT counter isn't part of the schema.
+func parseSchema(createTable string) tableSchema {
+	schema := tableSchema{definitions: map[string]string{}}
+	lines := strings.Split(createTable, "\n")
+	for _, line := range lines[1:] {
+		line = strings.TrimSuffix(strings.TrimSpace(line), ",")
+		if strings.HasPrefix(line, ")") {
+			schema.options = strings.TrimSpace(autoIncrementRe.ReplaceAllString(strings.TrimPrefix(line, ")"), ""))
+			continue
+		}
+		if line == "" {
+			continue
+		}
+
+		name := definitionName(line)
+		if strings.HasPrefix(name, "`") {
+			schema.columns = append(schema.columns, name)
+			name = "column " + name
+		}
+		schema.names = append(schema.names, name)
+		schema.definitions[name] = line
+	}
+
+	return schema
+}
+
+// definitionName is the column name, "PRIMARY KEY" or the key or constraint with its name, e.g. "KEY `idx_email`"
+func definitionName(line string) string {
+	if strings.HasPrefix(line, "PRIMARY KEY") {
+		return "PRIMARY KEY"
+	}
+	start := strings.Index(line, "`")
+	if start < 0 {
+		return line
+	}
+	for end := start + 1; end < len(line); end++ {
+		if line[end] != '`' {
+			continue
+		}
+		if end+1 < len(line) && line[end+1] == '`' {
+			end++
+			continue
+		}
+		return line[:end+1]
+	}
+
+	return line
+}
+
+// primaryKey returns the column of a single column primary key, or "" if there are several or none
+func (s tableSchema) primaryKey() string {
+	key, ok := s.definitions["PRIMARY KEY"]
+	if !ok {
+		return ""
+	}
+	columns := strings.TrimSuffix(strings.TrimPrefix(strings.SplitN(key, " USING ", 2)[0], "PRIMARY KEY ("), ")")
+	if strings.Contains(columns, ",") {
+		return ""
+	}
+
+	return columns
+}
+
+// integerColumn tells if the column has an integer type, so its values can be split into ranges
+func (s tableSchema) integerColumn(column string) bool {
+	definition := strings.TrimPrefix(s.definitions["column "+column], column+" ")
+	for _, integerType := range []string{"tinyint", "smallint", "mediumint", "int", "bigint"} {
+		if strings.HasPrefix(definition, integerType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// schemaDifferences describes how the dest table differs from the source table, the order of the definitions
+// doesn't matter
+func schemaDifferences(source tableSchema, dest tableSchema) []string {
+	var differences []string
+	for _, name := range source.names {
+		destDefinition, ok := dest.definitions[name]
+		if !ok {
+			differences = append(differences, name+" is missing in the destination")
+		} else if destDefinition != source.definitions[name] {
+			differences = append(differences, name+": "+source.definitions[name]+" != "+destDefinition)
+		}
+	}
+	for _, name := range dest.names {
+		if _, ok := source.definitions[name]; !ok {
+			differences = append(differences, name+" is only in the destination")
+		}
+	}
+	if source.options != dest.options {
+		differences = append(differences, "table options: "+source.options+" != "+dest.options)
+	}
+
+	return differences
+}
@@ -0,0 +1,388 @@
+// Package doctor checks that the Deskpro instance and this server have everything the other commands need, so
+// problems are found before a backup or a restore fails half way. It's what the 'dputils doctor' command runs.
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deskpro/dputils/util"
+)
+
+type Status string
+
+const (
+	StatusOk      Status = "ok"
+	StatusWarning Status = "warning"
+	StatusFailed  Status = "failed"
+	// StatusSkipped means the check couldn't run because an earlier check failed
+	StatusSkipped Status = "skipped"
+)
+
+// Check is the result of one check, Detail describes what was found or what is wrong
+type Check struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// Report are the results of every check in the order they ran
+type Report struct {
+	Ok     bool    `json:"ok"`
+	Checks []Check `json:"checks"`
+}
+
+// Failed returns the number of failed checks
+func (r *Report) Failed() int {
+	failed := 0
+	for _, check := range r.Checks {
+		if check.Status == StatusFailed {
+			failed++
+		}
+	}
+
+	return failed
+}
+
+// DefaultPhpExtensions are the PHP extensions Deskpro and its console commands load
+var DefaultPhpExtensions = []string{"ctype", "curl", "gd", "iconv", "json", "mbstring", "openssl", "pdo_mysql", "xml", "zip"}
+
+// Options of the checks
+type Options struct {
+	// DeskproPath and PhpPath locate the Deskpro instance to check
+	DeskproPath string
+	PhpPath     string
+
+	// MinPhpVersion is the oldest PHP version which passes, e.g. "7.1". The version isn't compared if it's empty.
+	MinPhpVersion string
+	// PhpExtensions must be loaded by PHP, DefaultPhpExtensions if nil
+	PhpExtensions []string
+
+	// TmpDir is where the restore downloads sources to, defaults to the system temp dir. A warning is reported if
+	// it or the attachments dir has less than MinFreeSpace bytes free.
+	TmpDir       string
+	MinFreeSpace int64
+}
+
+type doctor struct {
+	ctx    context.Context
+	opts   Options
+	report *Report
+}
+
+// Run runs every check. A check which depends on a failed one, like the database connections on the Deskpro
+// config, is reported as skipped.
+func Run(ctx context.Context, opts Options) *Report {
+	d := &doctor{ctx: ctx, opts: opts, report: &Report{}}
+	if d.opts.PhpExtensions == nil {
+		d.opts.PhpExtensions = DefaultPhpExtensions
+	}
+	if d.opts.TmpDir == "" {
+		d.opts.TmpDir = os.TempDir()
+	}
+
+	phpOk := d.checkPhp()
+	if phpOk {
+		d.checkPhpExtensions()
+	} else {
+		d.add("php extensions", StatusSkipped, "PHP can't be executed")
+	}
+
+	consoleOk := phpOk && d.checkConsole()
+	if !phpOk {
+		d.add("bin/console", StatusSkipped, "PHP can't be executed")
+	}
+
+	var dpConfig map[string]string
+	if consoleOk {
+		dpConfig = d.checkConfig()
+	} else {
+		d.add("config", StatusSkipped, "bin/console can't be executed")
+	}
+
+	if dpConfig != nil {
+		d.checkDatabases(dpConfig)
+		d.checkMysqlBinaries(dpConfig)
+		d.checkAttachments(util.AttachmentsPath(dpConfig, d.opts.DeskproPath))
+	} else {
+		for _, name := range []string{"database", "paths.mysql_path", "paths.mysqldump_path", "attachments"} {
+			d.add(name, StatusSkipped, "the Deskpro config can't be read")
+		}
+	}
+
+	d.checkDiskSpace("tmpdir", d.opts.TmpDir)
+	d.checkLogs()
+
+	d.report.Ok = d.report.Failed() == 0
+
+	return d.report
+}
+
+func (d *doctor) add(name string, status Status, detail string) {
+	d.report.Checks = append(d.report.Checks, Check{Name: name, Status: status, Detail: detail})
+}
+
+// run executes a command and returns its output, with the error output in the error if it fails
+func (d *doctor) run(name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(d.ctx, time.Minute)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		err = fmt.Errorf("%s: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+
+	return strings.TrimSpace(string(out)), err
+}
+
+func (d *doctor) checkPhp() bool {
+	if d.opts.PhpPath == "" {
+		d.add("php", StatusFailed, "the path to PHP isn't set, specify it with --php")
+		return false
+	}
+
+	version, err := d.run(d.opts.PhpPath, "-r", "echo PHP_VERSION;")
+	if err != nil {
+		d.add("php", StatusFailed, d.opts.PhpPath+" can't be executed: "+err.Error())
+		return false
+	}
+
+	if d.opts.MinPhpVersion != "" && compareVersions(version, d.opts.MinPhpVersion) < 0 {
+		d.add("php", StatusFailed, fmt.Sprintf("PHP %s (%s) is older than %s", version, d.opts.PhpPath, d.opts.MinPhpVersion))
+		return true
+	}
+
+	d.add("php", StatusOk, fmt.Sprintf("PHP %s (%s)", version, d.opts.PhpPath))
+
+	return true
+}
+
+func (d *doctor) checkPhpExtensions() {
+	out, err := d.run(d.opts.PhpPath, "-m")
+	if err != nil {
+		d.add("php extensions", StatusFailed, "failed to list the PHP extensions: "+err.Error())
+		return
+	}
+
+	loaded := map[string]bool{}
+	for _, line := range strings.Split(out, "\n") {
+		loaded[strings.ToLower(strings.TrimSpace(line))] = true
+	}
+
+	var missing []string
+	for _, extension := range d.opts.PhpExtensions {
+		if !loaded[strings.ToLower(extension)] {
+			missing = append(missing, extension)
+		}
+	}
+
+	if len(missing) > 0 {
+		d.add("php extensions", StatusFailed, "missing "+strings.Join(missing, ", "))
+		return
+	}
+
+	d.add("php extensions", StatusOk, strings.Join(d.opts.PhpExtensions, ", "))
+}
+
+func (d *doctor) checkConsole() bool {
+	console := filepath.Join(d.opts.DeskproPath, "bin", "console")
+	if _, err := os.Stat(console); err != nil {
+		d.add("bin/console", StatusFailed, "Deskpro isn't found in "+d.opts.DeskproPath+": "+err.Error())
+		return false
+	}
+
+	version, err := d.run(d.opts.PhpPath, console, "--version")
+	if err != nil {
+		d.add("bin/console", StatusFailed, console+" fails: "+err.Error())
+		return false
+	}
+
+	d.add("bin/console", StatusOk, strings.Split(version, "\n")[0])
+
+	return true
+}
+
+// checkConfig reads the config the same way the other commands do, with bin/console dump_config
+func (d *doctor) checkConfig() map[string]string {
+	config := &util.Config{}
+	dpConfig, err := config.SetPhpPath(d.opts.PhpPath).SetDpPath(d.opts.DeskproPath).GetDeskproConfig()
+	if err == nil && dpConfig == nil {
+		err = fmt.Errorf("dump_config didn't return the config")
+	}
+	if err != nil {
+		d.add("config", StatusFailed, "failed to read the Deskpro config: "+err.Error())
+		return nil
+	}
+
+	d.add("config", StatusOk, fmt.Sprintf("%d settings in %s", len(dpConfig), filepath.Join(d.opts.DeskproPath, "config")))
+
+	return dpConfig
+}
+
+// checkDatabases connects to the default database and every database_advanced.* connection of the config
+func (d *doctor) checkDatabases(dpConfig map[string]string) {
+	for _, prefix := range databasePrefixes(dpConfig) {
+		murl := util.GetMysqlUrlFromConfig(dpConfig, prefix)
+		if murl.User.Username() == "" {
+			d.add(prefix, StatusFailed, "the connection isn't configured")
+			continue
+		}
+
+		description := murl.User.Username() + "@" + murl.Host + murl.Path
+		version, err := d.databaseVersion(murl)
+		if err != nil {
+			d.add(prefix, StatusFailed, description+": "+err.Error())
+			continue
+		}
+
+		d.add(prefix, StatusOk, description+", MySQL "+version)
+	}
+}
+
+func (d *doctor) databaseVersion(murl url.URL) (string, error) {
+	db, err := util.GetMysqlConnection(murl)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(d.ctx, 10*time.Second)
+	defer cancel()
+
+	var version string
+	err = db.QueryRowContext(ctx, "SELECT VERSION()").Scan(&version)
+
+	return version, err
+}
+
+// databasePrefixes returns "database" and the config prefixes of the advanced connections
+func databasePrefixes(dpConfig map[string]string) []string {
+	advanced := map[string]bool{}
+	for key := range dpConfig {
+		if !strings.HasPrefix(key, "database_advanced.") {
+			continue
+		}
+		if parts := strings.SplitN(key, ".", 3); len(parts) == 3 {
+			advanced["database_advanced."+parts[1]] = true
+		}
+	}
+
+	var prefixes []string
+	for prefix := range advanced {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	return append([]string{"database"}, prefixes...)
+}
+
+// checkMysqlBinaries checks the mysql and mysqldump binaries, they're optional with the native engine
+func (d *doctor) checkMysqlBinaries(dpConfig map[string]string) {
+	for _, key := range []string{"paths.mysql_path", "paths.mysqldump_path"} {
+		version, err := util.MysqlBinaryVersion(dpConfig, key)
+		if err != nil {
+			d.add(key, StatusWarning, err.Error())
+			continue
+		}
+
+		d.add(key, StatusOk, version)
+	}
+}
+
+func (d *doctor) checkAttachments(dir string) {
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		d.add("attachments", StatusFailed, "can't find the attachments dir "+dir)
+		return
+	}
+	if err = checkWritable(dir); err != nil {
+		d.add("attachments", StatusFailed, dir+" isn't writable: "+err.Error())
+		return
+	}
+
+	d.add("attachments", StatusOk, dir+" is writable")
+	d.checkDiskSpace("attachments disk space", dir)
+}
+
+func (d *doctor) checkDiskSpace(name string, dir string) {
+	free, err := util.FreeDiskSpace(dir)
+	if err != nil {
+		d.add(name, StatusWarning, "failed to read the free disk space of "+dir+": "+err.Error())
+		return
+	}
+
+	detail := fmt.Sprintf("%s free in %s", util.FormatSize(free), dir)
+	if free < d.opts.MinFreeSpace {
+		d.add(name, StatusWarning, detail+", less than "+util.FormatSize(d.opts.MinFreeSpace))
+		return
+	}
+
+	d.add(name, StatusOk, detail)
+}
+
+// checkLogs makes sure dputils.log can be written, otherwise the log goes to stderr
+func (d *doctor) checkLogs() {
+	dir := filepath.Join(d.opts.DeskproPath, "var", "logs")
+	if err := checkWritable(dir); err != nil {
+		d.add("var/logs", StatusWarning, dir+" isn't writable, dputils logs to stderr: "+err.Error())
+		return
+	}
+
+	d.add("var/logs", StatusOk, dir+" is writable")
+}
+
+func checkWritable(dir string) error {
+	f, err := ioutil.TempFile(dir, ".dputils_doctor")
+	if err != nil {
+		return err
+	}
+	_ = f.Close()
+
+	return os.Remove(f.Name())
+}
+
+// compareVersions compares dotted versions like "7.4.33" by their numbers, suffixes like "-1ubuntu" are ignored
+func compareVersions(a string, b string) int {
+	as, bs := versionNumbers(a), versionNumbers(b)
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var an, bn int
+		if i < len(as) {
+			an = as[i]
+		}
+		if i < len(bs) {
+			bn = bs[i]
+		}
+		if an != bn {
+			if an < bn {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return 0
+}
+
+func versionNumbers(version string) []int {
+	end := 0
+	for end < len(version) && (version[end] == '.' || version[end] >= '0' && version[end] <= '9') {
+		end++
+	}
+
+	var numbers []int
+	for _, part := range strings.Split(strings.Trim(version[:end], "."), ".") {
+		n, _ := strconv.Atoi(part)
+		numbers = append(numbers, n)
+	}
+
+	return numbers
+}
@@ -0,0 +1,74 @@
+package doctor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_compareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"7.4.33", "7.1", 1},
+		{"8.1.2-1ubuntu2.14", "8.1.2", 0},
+		{"7.0.33", "7.1", -1},
+		{"8.10.0", "8.9", 1},
+	}
+	for _, test := range tests {
+		if result := compareVersions(test.a, test.b); result != test.expected {
+			t.Errorf("%s vs %s: expected %d, got %d", test.a, test.b, test.expected, result)
+		}
+	}
+}
+
+func Test_databasePrefixes(t *testing.T) {
+	prefixes := databasePrefixes(map[string]string{
+		"database.host":                    "localhost",
+		"database_advanced.voice.host":     "voice",
+		"database_advanced.audit.host":     "audit",
+		"database_advanced.audit.password": "secret",
+		"paths.mysql_path":                 "/usr/bin/mysql",
+	})
+
+	if strings.Join(prefixes, ",") != "database,database_advanced.audit,database_advanced.voice" {
+		t.Errorf("Unexpected prefixes %v", prefixes)
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dputils_doctor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = os.MkdirAll(filepath.Join(dir, "var", "logs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	report := Run(context.Background(), Options{DeskproPath: dir, PhpPath: filepath.Join(dir, "missing-php"), TmpDir: dir})
+
+	statuses := map[string]Status{}
+	for _, check := range report.Checks {
+		statuses[check.Name] = check.Status
+	}
+	expected := map[string]Status{
+		"php":         StatusFailed,
+		"bin/console": StatusSkipped,
+		"database":    StatusSkipped,
+		"tmpdir":      StatusOk,
+		"var/logs":    StatusOk,
+	}
+	for name, status := range expected {
+		if statuses[name] != status {
+			t.Errorf("%s: expected %s, got %s", name, status, statuses[name])
+		}
+	}
+	if report.Ok || report.Failed() != 1 {
+		t.Errorf("Expected only the PHP check to fail, %d failed", report.Failed())
+	}
+}
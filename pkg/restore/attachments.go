@@ -0,0 +1,267 @@
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/deskpro/dputils/util"
+	"github.com/hashicorp/go-getter"
+	log "github.com/sirupsen/logrus"
+)
+
+// attachmentsReportName is the summary of the copied attachments saved in the tmpdir
+const attachmentsReportName = "dputils_attachments_report.json"
+
+// attachmentRetryBackoff is the delay before the first retry of a failed attachment, it's doubled for every next retry
+var attachmentRetryBackoff = 500 * time.Millisecond
+
+// attachmentReport is the summary of restoreAttachments, it's saved into the report file
+type attachmentReport struct {
+	Copied  int64         `json:"copied"`
+	Skipped int64         `json:"skipped"`
+	Failed  int64         `json:"failed"`
+	Bytes   int64         `json:"bytes"`
+	Errors  []blobFailure `json:"errors,omitempty"`
+	mu      sync.Mutex
+}
+
+type blobFailure struct {
+	Id     int64  `json:"id"`
+	Path   string `json:"path"`
+	Source string `json:"source"`
+	Error  string `json:"error"`
+}
+
+// blobBatch tracks the blobs of one batch read from the database, so the checkpoint only moves past batches which
+// are completely copied
+type blobBatch struct {
+	lastId    int64
+	remaining int32
+	failed    int32
+	done      chan struct{}
+}
+
+type blobJob struct {
+	blob  util.Blob
+	batch *blobBatch
+}
+
+// restoreAttachments copies attachments up to the lastId blob with a pool of workers, retrying failed files. It
+// starts after the last blob saved in the checkpoint and saves the id of every copied batch, as long as all
+// previous batches were copied too. A summary is written into the report file. No new batches are started once
+// the context is canceled.
+func (r *restorer) restoreAttachments(destinationMysqlConn util.MysqlConn, attachUri string, moveAttachments bool, lastId int64) error {
+	realAttachPath := util.AttachmentsPath(r.opts.DeskproConfig, r.opts.DeskproPath)
+	reportPath := filepath.Join(r.opts.TmpDir, attachmentsReportName)
+	if attachUri != "none" {
+		r.println("==========================================================================================")
+		r.println("Restore Attachments")
+		r.println("==========================================================================================")
+
+		var (
+			nextStartId int64
+			report      = &attachmentReport{}
+			jobs        = make(chan blobJob, r.opts.AttachmentWorkers*2)
+			batches     = make(chan *blobBatch, r.opts.AttachmentWorkers*2)
+			workers     = new(sync.WaitGroup)
+			committed   = make(chan struct{})
+		)
+
+		if r.checkpoint.LastBlobId > nextStartId {
+			nextStartId = r.checkpoint.LastBlobId
+			r.println("Resuming after blob ", nextStartId)
+		}
+
+		var totalFiles, totalBytes int64
+		err := destinationMysqlConn.Conn.QueryRowContext(
+			r.ctx,
+			"SELECT COUNT(*), COALESCE(SUM(filesize), 0) FROM blobs WHERE id > ? AND id <= ? AND storage_loc = 'fs'",
+			nextStartId, lastId,
+		).Scan(&totalFiles, &totalBytes)
+		if err != nil {
+			log.Warning("Failed to count blobs ", err)
+		}
+		r.printf("Copying %d files (%d bytes) with %d workers\n", totalFiles, totalBytes, r.opts.AttachmentWorkers)
+
+		progress := func() {
+			r.event(util.Event{
+				Type:       util.EventProgress,
+				Phase:      "attachments",
+				Files:      report.processed(),
+				Bytes:      atomic.LoadInt64(&report.Bytes),
+				TotalFiles: totalFiles,
+				TotalBytes: totalBytes,
+			})
+		}
+
+		for i := 0; i < r.opts.AttachmentWorkers; i++ {
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				for job := range jobs {
+					// queued blobs are left for the next run once the restore is canceled
+					if r.ctx.Err() != nil || !copyBlob(r.ctx, job.blob, attachUri, realAttachPath, moveAttachments, r.opts.AttachmentRetries, report) {
+						atomic.StoreInt32(&job.batch.failed, 1)
+					}
+					if atomic.AddInt32(&job.batch.remaining, -1) == 0 {
+						close(job.batch.done)
+					}
+				}
+			}()
+		}
+
+		// batches complete out of order, the checkpoint is saved in order and stops at the first batch with a failure
+		go func() {
+			defer close(committed)
+			ok := true
+			for batch := range batches {
+				<-batch.done
+				ok = ok && atomic.LoadInt32(&batch.failed) == 0
+				progress()
+				if ok {
+					if err := r.checkpoint.completeBlobs(batch.lastId); err != nil {
+						log.Warning("Failed to save restore checkpoint ", err)
+					}
+				}
+			}
+		}()
+
+		var batchErr error
+		for nextStartId < lastId && r.ctx.Err() == nil {
+			var batch []util.Blob
+			if batch, batchErr = util.NextBlobBatch(r.ctx, destinationMysqlConn.Conn, nextStartId); batchErr != nil || batch == nil {
+				break
+			}
+
+			tracker := &blobBatch{lastId: batch[len(batch)-1].Id, remaining: int32(len(batch)), done: make(chan struct{})}
+			batches <- tracker
+			for _, blob := range batch {
+				jobs <- blobJob{blob: blob, batch: tracker}
+			}
+			nextStartId = tracker.lastId
+		}
+		close(jobs)
+		workers.Wait()
+		close(batches)
+		<-committed
+
+		r.printf("Done all blobs: %d copied, %d skipped, %d failed (%d bytes)\n", report.Copied, report.Skipped, report.Failed, report.Bytes)
+		if err := report.save(reportPath); err != nil {
+			log.Warning("Failed to save attachments report ", err)
+			r.println("Failed to save the attachments report: ", err)
+		} else {
+			r.println("Report saved to " + reportPath)
+		}
+		if err := r.ctx.Err(); err != nil {
+			return util.NewError(util.CanceledError, "The restore of attachments was canceled", err)
+		}
+		if batchErr != nil {
+			return util.NewError(util.AttachmentError, "Failed to read the attachments to restore", batchErr)
+		}
+		if report.Failed > 0 {
+			message := fmt.Sprintf("%d attachments failed to copy, see %s", report.Failed, reportPath)
+			r.warning(message)
+			r.println("Some attachments failed to copy, run the restore with --resume to retry them")
+			return util.NewError(util.AttachmentError, message, nil)
+		}
+	}
+
+	return nil
+}
+
+// copyBlob copies or moves an attachment into the Deskpro attachments dir, retrying with a backoff if it fails.
+// A copy stopped by the context isn't retried nor reported as failed.
+func copyBlob(ctx context.Context, blob util.Blob, attachUri string, realAttachPath string, moveAttachments bool, retries int, report *attachmentReport) bool {
+	blobPath := strings.Replace(attachUri, "%PATH%", blob.SavePath, 1)
+	targetPath := filepath.Join(realAttachPath, filepath.FromSlash(blob.SavePath))
+
+	// already exists, check hash
+	if _, err := os.Stat(targetPath); !os.IsNotExist(err) && compareFileHash(targetPath, blob.Hash) {
+		atomic.AddInt64(&report.Skipped, 1)
+		return true
+	}
+
+	var err error
+	backoff := attachmentRetryBackoff
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			log.Warning("Retrying blob ", blobPath, " after ", err)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return false
+			}
+			backoff *= 2
+		}
+
+		if moveAttachments {
+			if err = os.MkdirAll(filepath.Dir(targetPath), 0755); err == nil {
+				err = os.Rename(blobPath, targetPath)
+			}
+		} else {
+			err = download(ctx, targetPath, blobPath, getter.ClientModeFile)
+		}
+
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		report.fail(blobFailure{Id: blob.Id, Path: blob.SavePath, Source: blobPath, Error: err.Error()})
+		return false
+	}
+
+	atomic.AddInt64(&report.Copied, 1)
+	if info, err := os.Stat(targetPath); err == nil {
+		atomic.AddInt64(&report.Bytes, info.Size())
+	}
+
+	return true
+}
+
+func compareFileHash(filePath string, expectHash string) bool {
+	hash, err := util.FileMd5(filePath)
+
+	return err == nil && hash == expectHash
+}
+
+func (r *attachmentReport) fail(failure blobFailure) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Failed++
+	r.Errors = append(r.Errors, failure)
+}
+
+// processed is the number of blobs which are copied, skipped or failed so far
+func (r *attachmentReport) processed() int64 {
+	r.mu.Lock()
+	failed := r.Failed
+	r.mu.Unlock()
+
+	return atomic.LoadInt64(&r.Copied) + atomic.LoadInt64(&r.Skipped) + failed
+}
+
+func (r *attachmentReport) save(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,161 @@
+package restore
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/deskpro/dputils/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// archivedBinlogs returns the archived binary logs needed to replay the default database of the backup up to
+// Until, in their order. It's checked before anything is changed, so a missing log doesn't leave the database
+// restored only up to the backup.
+func (r *restorer) archivedBinlogs(manifest *util.Manifest) ([]string, *util.ManifestDatabase, error) {
+	if manifest == nil || manifest.Database("default") == nil {
+		return nil, nil, util.NewError(util.SourceError, "The backup archive doesn't have a manifest with the binary log position of the dump, binary logs can't be replayed on it", nil)
+	}
+	db := manifest.Database("default")
+	if db.Binlog == nil {
+		return nil, nil, util.NewError(util.SourceError, "The backup doesn't record the binary log position of the dump, it must be created with --dump-profile=consistent and binary logging enabled", nil)
+	}
+	if until := r.opts.Until.Time; !until.IsZero() && until.Before(manifest.CreatedAt) {
+		return nil, nil, util.NewError(util.ConfigError, "--until is before the backup was created at "+manifest.CreatedAt.Local().Format(util.BinlogTimeLayout)+", restore an older backup", nil)
+	}
+
+	dir, err := util.OpenTargetDir(r.opts.Binlogs)
+	if err != nil {
+		return nil, nil, util.NewError(util.SourceError, "Can't open the binary log dir "+util.RedactUrl(r.opts.Binlogs), err)
+	}
+	defer dir.Close()
+	names, err := dir.List()
+	if err != nil {
+		return nil, nil, util.NewError(util.SourceError, "Can't list the binary logs in "+util.RedactUrl(r.opts.Binlogs), err)
+	}
+
+	base, first, ok := util.BinlogSequence(db.Binlog.File)
+	if !ok {
+		return nil, nil, util.NewError(util.SourceError, "Unexpected binary log name "+db.Binlog.File+" in the backup manifest", nil)
+	}
+	sequences := map[int]string{}
+	for _, name := range names {
+		if nameBase, n, ok := util.BinlogSequence(name); ok && nameBase == base && n >= first {
+			sequences[n] = name
+		}
+	}
+	if _, ok := sequences[first]; !ok {
+		return nil, nil, util.NewError(util.SourceError, "Binary log "+db.Binlog.File+" the backup was taken at isn't archived in "+util.RedactUrl(r.opts.Binlogs), nil)
+	}
+
+	var order []int
+	for n := range sequences {
+		order = append(order, n)
+	}
+	sort.Ints(order)
+
+	var binlogs []string
+	for i, n := range order {
+		if n != first+i {
+			return nil, nil, util.NewError(util.SourceError, fmt.Sprintf("Binary logs are missing between %s and %s", binlogs[i-1], sequences[n]), nil)
+		}
+		binlogs = append(binlogs, sequences[n])
+	}
+
+	return binlogs, db, nil
+}
+
+// replayBinlogs replays the archived binary logs on the restored default database, from the position the dump was
+// taken at up to Until
+func (r *restorer) replayBinlogs(destinationMysqlConn util.MysqlConn, manifest *util.Manifest) error {
+	r.println("Replaying binary logs until " + r.opts.Until.String() + "...")
+
+	binlogs, db, err := r.archivedBinlogs(manifest)
+	if err != nil {
+		return err
+	}
+
+	files, cleanup, err := r.fetchBinlogs(binlogs)
+	defer cleanup()
+	if err != nil {
+		return util.NewError(util.SourceError, "Failed to get the archived binary logs", err)
+	}
+
+	reached, err := util.ReplayBinaryLogs(r.ctx, r.opts.DeskproConfig, destinationMysqlConn.MysqlUrl, util.BinlogReplay{
+		Files:    files,
+		Start:    *db.Binlog,
+		Stop:     r.opts.Until,
+		Database: db.Name,
+	})
+	if err != nil {
+		return util.NewError(util.DumpError, "Failed to replay the binary logs", err)
+	}
+	if !reached {
+		message := "The archived binary logs end before " + r.opts.Until.String() + ", every archived transaction was replayed"
+		log.Warning(message)
+		r.warning(message)
+		r.println("\t" + message)
+	}
+
+	r.printf("\tReplayed %d binary logs from %s:%d\n", len(binlogs), db.Binlog.File, db.Binlog.Position)
+
+	return nil
+}
+
+// fetchBinlogs returns the paths of local binary logs as they are, remote logs are downloaded into the tmpdir and
+// removed by the returned cleanup
+func (r *restorer) fetchBinlogs(binlogs []string) ([]string, func(), error) {
+	if !util.IsRemoteTarget(r.opts.Binlogs) {
+		var files []string
+		for _, name := range binlogs {
+			files = append(files, filepath.Join(r.opts.Binlogs, name))
+		}
+		return files, func() {}, nil
+	}
+
+	tmpdir := filepath.Join(r.opts.TmpDir, fmt.Sprintf("binlogs%d", os.Getpid()))
+	cleanup := func() {
+		_ = os.RemoveAll(tmpdir)
+	}
+	if err := os.MkdirAll(tmpdir, 0755); err != nil {
+		return nil, cleanup, err
+	}
+
+	dir, err := util.OpenTargetDir(r.opts.Binlogs)
+	if err != nil {
+		return nil, cleanup, err
+	}
+	defer dir.Close()
+
+	var files []string
+	for _, name := range binlogs {
+		path := filepath.Join(tmpdir, name)
+		if err = downloadBinlog(dir, name, path); err != nil {
+			return nil, cleanup, fmt.Errorf("%s: %s", name, err)
+		}
+		files = append(files, path)
+	}
+
+	return files, cleanup, nil
+}
+
+func downloadBinlog(dir util.TargetDir, name string, path string) error {
+	src, err := dir.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
@@ -0,0 +1,84 @@
+package restore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deskpro/dputils/util"
+)
+
+func Test_archivedBinlogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dputils_binlogs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"mysql-bin.000001", "mysql-bin.000002", "mysql-bin.000003", "mysql-bin.000004"} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte("binlog"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	createdAt := time.Date(2026, 10, 18, 2, 0, 0, 0, time.Local)
+	manifest := &util.Manifest{
+		CreatedAt: createdAt,
+		Databases: []util.ManifestDatabase{{
+			Type:   "default",
+			Name:   "deskpro",
+			Binlog: &util.BinlogCoordinates{File: "mysql-bin.000002", Position: 157},
+		}},
+	}
+
+	r := newTestRestorer(Options{Binlogs: dir, Until: util.BinlogStop{Time: createdAt.Add(time.Hour)}})
+	binlogs, db, err := r.archivedBinlogs(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(binlogs, ",") != "mysql-bin.000002,mysql-bin.000003,mysql-bin.000004" || db.Name != "deskpro" {
+		t.Errorf("Unexpected binary logs %v", binlogs)
+	}
+
+	// a gap in the archive
+	if err = os.Remove(filepath.Join(dir, "mysql-bin.000003")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = r.archivedBinlogs(manifest); util.ErrorKindOf(err) != util.SourceError {
+		t.Errorf("Expected a source error for the missing log, got %v", err)
+	}
+
+	// a time before the backup
+	r.opts.Until = util.BinlogStop{Time: createdAt.Add(-time.Hour)}
+	if _, _, err = r.archivedBinlogs(manifest); util.ErrorKindOf(err) != util.ConfigError {
+		t.Errorf("Expected a config error for --until before the backup, got %v", err)
+	}
+
+	// a backup without the binary log position
+	manifest.Databases[0].Binlog = nil
+	r.opts.Until = util.BinlogStop{Gtid: "3e11fa47-71ca-11e1-9e33-c80aa9429562:23"}
+	if _, _, err = r.archivedBinlogs(manifest); util.ErrorKindOf(err) != util.SourceError {
+		t.Errorf("Expected a source error for the missing position, got %v", err)
+	}
+}
+
+func Test_validateUntil(t *testing.T) {
+	until := util.BinlogStop{Gtid: "3e11fa47-71ca-11e1-9e33-c80aa9429562:23"}
+	for _, opts := range []Options{
+		{Binlogs: "/binlogs"},
+		{Until: until, Binlogs: "/binlogs"},
+		{Until: until, FullBackup: "backup.zip"},
+		{Until: until, FullBackup: "backup.zip", Binlogs: "/binlogs", DumpOptions: util.DumpOptions{Tables: util.TableFilter{Include: []string{"tickets"}}}},
+	} {
+		if err := newTestRestorer(opts).validateUntil(); util.ErrorKindOf(err) != util.ConfigError {
+			t.Errorf("Expected a config error for %+v, got %v", opts, err)
+		}
+	}
+
+	if err := newTestRestorer(Options{Until: until, FullBackup: "backup.zip", Binlogs: "/binlogs"}).validateUntil(); err != nil {
+		t.Error(err)
+	}
+}
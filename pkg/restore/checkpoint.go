@@ -0,0 +1,160 @@
+package restore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const checkpointName = "dputils_restore_checkpoint.json"
+
+// Restore phases in the order they are completed
+const (
+	phaseStarted     = ""
+	phaseSources     = "sources"
+	phaseDatabases   = "databases"
+	phaseAttachments = "attachments"
+)
+
+var restorePhases = []string{phaseStarted, phaseSources, phaseDatabases, phaseAttachments}
+
+// checkpoint is the progress of a restore saved in the tmpdir, so an interrupted restore can be continued
+// with --resume instead of starting from scratch
+type checkpoint struct {
+	Phase     string    `json:"phase"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// where the sources were downloaded and extracted to
+	FullBackup      bool              `json:"full_backup"`
+	BackupDir       string            `json:"backup_dir,omitempty"`
+	DumpDir         string            `json:"dump_dir,omitempty"`
+	DumpPaths       map[string]string `json:"dump_paths,omitempty"`
+	AttachUri       string            `json:"attach_uri,omitempty"`
+	MoveAttachments bool              `json:"move_attachments"`
+
+	// the safety snapshot taken before the databases were changed
+	SnapshotId string `json:"snapshot_id,omitempty"`
+
+	// database types ("default", "audit", "voice" or "system") which are completely restored
+	Databases []string `json:"databases,omitempty"`
+	// every attachment up to this blob id is copied
+	LastBlobId int64 `json:"last_blob_id"`
+	// the phase the restore was in when it was interrupted
+	StoppedIn string `json:"stopped_in,omitempty"`
+
+	path string
+	mu   sync.Mutex
+}
+
+func newCheckpoint(tmpdir string) *checkpoint {
+	return &checkpoint{
+		DumpPaths: map[string]string{},
+		path:      filepath.Join(tmpdir, checkpointName),
+	}
+}
+
+// readCheckpoint loads the checkpoint saved in the tmpdir by an interrupted restore
+func readCheckpoint(tmpdir string) (*checkpoint, error) {
+	checkpoint := newCheckpoint(tmpdir)
+
+	data, err := ioutil.ReadFile(checkpoint.path)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, checkpoint); err != nil {
+		return nil, err
+	}
+	if checkpoint.DumpPaths == nil {
+		checkpoint.DumpPaths = map[string]string{}
+	}
+
+	return checkpoint, nil
+}
+
+// done tells if the phase was completed before
+func (c *checkpoint) done(phase string) bool {
+	return phaseIndex(c.Phase) >= phaseIndex(phase)
+}
+
+func phaseIndex(phase string) int {
+	for i, p := range restorePhases {
+		if p == phase {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (c *checkpoint) databaseRestored(dbType string) bool {
+	for _, restored := range c.Databases {
+		if restored == dbType {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *checkpoint) completePhase(phase string) error {
+	c.mu.Lock()
+	c.Phase = phase
+	c.mu.Unlock()
+
+	return c.save()
+}
+
+func (c *checkpoint) completeDatabase(dbType string) error {
+	c.mu.Lock()
+	c.Databases = append(c.Databases, dbType)
+	c.mu.Unlock()
+
+	return c.save()
+}
+
+func (c *checkpoint) completeBlobs(lastBlobId int64) error {
+	c.mu.Lock()
+	c.LastBlobId = lastBlobId
+	c.mu.Unlock()
+
+	return c.save()
+}
+
+// stop records the phase the restore was interrupted in
+func (c *checkpoint) stop(phase string) error {
+	c.mu.Lock()
+	c.StoppedIn = phase
+	c.mu.Unlock()
+
+	return c.save()
+}
+
+// save writes the checkpoint into a temporary file first, so a crash while saving doesn't leave a broken checkpoint
+func (c *checkpoint) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err = ioutil.WriteFile(c.path+".tmp", data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(c.path+".tmp", c.path)
+}
+
+// remove deletes the checkpoint once the restore is finished
+func (c *checkpoint) remove() error {
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
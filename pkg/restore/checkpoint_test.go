@@ -0,0 +1,43 @@
+package restore
+
+import (
+	"testing"
+)
+
+func Test_checkpoint(t *testing.T) {
+	tmpdir := t.TempDir()
+
+	if _, err := readCheckpoint(tmpdir); err == nil {
+		t.Error("Expected an error without a checkpoint")
+	}
+
+	checkpoint := newCheckpoint(tmpdir)
+	checkpoint.DumpPaths["default"] = "/tmp/db.sql"
+	if err := checkpoint.completePhase(phaseSources); err != nil {
+		t.Fatal(err)
+	}
+	_ = checkpoint.completeDatabase("default")
+	_ = checkpoint.completeBlobs(42)
+
+	resumed, err := readCheckpoint(tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !resumed.done(phaseSources) || resumed.done(phaseDatabases) {
+		t.Errorf("Unexpected completed phase %q", resumed.Phase)
+	}
+	if !resumed.databaseRestored("default") || resumed.databaseRestored("audit") {
+		t.Errorf("Unexpected restored databases %v", resumed.Databases)
+	}
+	if resumed.LastBlobId != 42 || resumed.DumpPaths["default"] != "/tmp/db.sql" {
+		t.Errorf("Unexpected checkpoint %+v", resumed)
+	}
+
+	if err = resumed.remove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = readCheckpoint(tmpdir); err == nil {
+		t.Error("Checkpoint wasn't removed")
+	}
+}
@@ -0,0 +1,210 @@
+package restore
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/deskpro/dputils/util"
+	"github.com/hashicorp/go-getter"
+	log "github.com/sirupsen/logrus"
+)
+
+func (r *restorer) validateDeskpro(prefix string, checkEmpty bool) (util.MysqlConn, error) {
+	var (
+		localDbConn          *sql.DB
+		localDbUrl           url.URL
+		destinationMysqlConn util.MysqlConn
+	)
+
+	dpConfig := r.opts.DeskproConfig
+	localDbUrl = util.GetMysqlUrlFromConfig(dpConfig, prefix)
+	localDbConn, err := util.GetMysqlConnectionFromConfig(dpConfig, prefix)
+	if err != nil {
+		r.println("Please correct the database configuration and then try again.")
+		return util.MysqlConn{}, util.NewError(util.ConfigError, "The database details contained in config.database.php do not work", err)
+	}
+
+	destinationMysqlConn = util.MysqlConn{MysqlUrl: localDbUrl, Conn: localDbConn}
+
+	res, err := localDbConn.Query("SHOW TABLES")
+	if err != nil {
+		r.println("Please correct the database configuration and then try again.")
+		return util.MysqlConn{}, util.NewError(util.ConfigError, "The database details contained in config.database.php do not work", err)
+	}
+
+	if checkEmpty && res.Next() {
+		log.Info("local db has tables")
+
+		// this checks for a count of settings matches the settings that get set upon install
+		// if these arent there, then we can assume its a new instance that hasnt even been configured yet
+		res, err = localDbConn.Query("SELECT COUNT(*) FROM settings WHERE name IN ('admin_has_loaded', 'core.license', 'core.setup_initial')")
+		settingCount := 0
+
+		if err != nil {
+			log.Warning("scanning for settings failed ", err)
+			// an error (i.e. maybe tbale didnt exist) lets just use same handling as if its an install
+			settingCount = 3
+		} else {
+			if res.Next() {
+				_ = res.Scan(&settingCount)
+			}
+		}
+
+		if settingCount == 3 {
+			log.Info("found some records that indicate real install")
+			r.println("If this is a new server, then it might simply be the default demo installation.")
+			r.println("You can wipe the installation with the following command: ")
+			r.println("")
+			r.println(r.opts.PhpPath, " ", filepath.Join(r.opts.DeskproPath, "bin", "console"), " install:clean --keep-config")
+			r.println("")
+			return util.MysqlConn{}, util.NewError(util.ConfigError, "The local db already contains tables", nil)
+		}
+	}
+
+	return destinationMysqlConn, nil
+}
+
+func (r *restorer) restoreDatabaseAdvancedDump(backupDir string, manifest *util.Manifest, dbType string) error {
+	dbDumpLocal, err := getFullBackupDump(r.ctx, backupDir, fullBackupDumpName(manifest, dbType))
+	if err != nil {
+		return err
+	}
+
+	if len(dbDumpLocal) > 1 {
+		r.println("Trying to restore database from advanced dump: " + dbType)
+		destinationMysqlConn, err := r.connectAdvancedDestination(dbType)
+		if err != nil {
+			return err
+		}
+
+		return r.restoreDatabase(destinationMysqlConn, util.MysqlConn{}, dbDumpLocal)
+	}
+
+	return nil
+}
+
+func (r *restorer) restoreDatabaseAdvanced(dbType string) error {
+
+	if _, ok := r.opts.SourceDatabases[dbType]; ok {
+		r.println("Trying to restore database from advanced config: " + dbType)
+
+		advancedSourceConnection, err := r.connectSource(dbType)
+		if err != nil {
+			return err
+		}
+		destinationMysqlConn, err := r.connectAdvancedDestination(dbType)
+		if err != nil {
+			return err
+		}
+
+		return r.restoreDatabase(destinationMysqlConn, advancedSourceConnection, "")
+	}
+
+	return nil
+}
+
+// connectAdvancedDestination connects to the "audit", "voice" or "system" database of the Deskpro instance
+func (r *restorer) connectAdvancedDestination(dbType string) (util.MysqlConn, error) {
+	prefix := "database_advanced." + dbType
+
+	destinationAdvancedMysqlUrl := util.GetMysqlUrlFromConfig(r.opts.DeskproConfig, prefix)
+	if destinationAdvancedMysqlUrl.User.Username() == "" {
+		return util.MysqlConn{}, util.NewError(util.ConfigError, "No connection config for database "+dbType, nil)
+	}
+	destinationAdvancedMysqlConn, err := util.GetMysqlConnectionFromConfig(r.opts.DeskproConfig, prefix)
+	if err != nil {
+		return util.MysqlConn{}, util.NewError(util.ConfigError, "Failed to connect to the "+dbType+" database", err)
+	}
+
+	return util.MysqlConn{MysqlUrl: destinationAdvancedMysqlUrl, Conn: destinationAdvancedMysqlConn}, nil
+}
+
+func (r *restorer) restoreDatabase(destinationMysqlConn util.MysqlConn, sourceMysqlConn util.MysqlConn, dbDumpLocal string) error {
+	r.println("==========================================================================================")
+	r.println("Restore Database")
+	r.println("==========================================================================================")
+
+	filter := r.opts.DumpOptions.Tables
+	if filter.IsZero() {
+		r.println("Clearing existing database...")
+	} else {
+		r.println("Clearing the selected tables (" + filter.String() + ")...")
+	}
+	if err := dropTables(r.ctx, destinationMysqlConn.Conn, filter); err != nil {
+		return util.NewError(util.DumpError, "Failed to clear the database", err)
+	}
+	r.println("\tOK")
+
+	tmpdir := r.opts.TmpDir
+	archive := detectArchive(dbDumpLocal, tmpdir)
+	if archive {
+		newPath := filepath.Join(tmpdir, "deskpro_database.sql"+fmt.Sprintf("%d", time.Now().Unix()))
+		// the extracted dump is only needed for the import
+		defer os.Remove(newPath)
+		err := download(r.ctx, newPath, dbDumpLocal, getter.ClientModeFile)
+		if err != nil {
+			return util.NewError(util.DumpError, "Failed to unarchive backup file", err)
+		}
+		dbDumpLocal = newPath
+	}
+
+	if len(dbDumpLocal) > 1 {
+
+		dumpFile, err := os.Open(dbDumpLocal)
+		if err != nil {
+			return util.NewError(util.DumpError, "Couldn't open dump file", err)
+		}
+		defer dumpFile.Close()
+		b := make([]byte, 1024*100)
+		_, err = dumpFile.Read(b)
+		if err != nil {
+			return util.NewError(util.DumpError, "Couldn't read dump file", err)
+		}
+		// a dump of selected tables may not hold agent_activity, it must still be a MySQL dump
+		if !strings.Contains(string(b), "agent_activity") && (filter.IsZero() || !strings.HasPrefix(string(b), "-- MySQL dump")) {
+			return util.NewError(util.DumpError, "The dump file seems to be broken, we can't find correct SQL dump for Deskpro tables", nil)
+		}
+		if _, err = dumpFile.Seek(0, io.SeekStart); err != nil {
+			return util.NewError(util.DumpError, "Couldn't read dump file", err)
+		}
+
+		r.println("Restoring from database dump (this may take a while)...")
+
+		err = util.ImportDatabase(r.ctx, r.opts.DeskproConfig, destinationMysqlConn.MysqlUrl, destinationMysqlConn.Conn, r.opts.DumpOptions, dumpFile)
+		if err != nil {
+			return util.NewError(util.DumpError, "Failed to restore mysql dump", err)
+		}
+	} else {
+		r.println("Restoring from mysqldump (this may take a while)...")
+
+		reader, writer := io.Pipe()
+		dumped := make(chan error, 1)
+		go func() {
+			_, err := util.DumpDatabase(r.ctx, r.opts.DeskproConfig, sourceMysqlConn.MysqlUrl, r.opts.DumpOptions, writer)
+			_ = writer.CloseWithError(err)
+			dumped <- err
+		}()
+
+		err := util.ImportDatabase(r.ctx, r.opts.DeskproConfig, destinationMysqlConn.MysqlUrl, destinationMysqlConn.Conn, r.opts.DumpOptions, reader)
+		// unblocks the dump if the import stopped reading early
+		_ = reader.CloseWithError(err)
+		dumpErr := <-dumped
+
+		if dumpErr != nil {
+			return util.NewError(util.DumpError, "Failed to dump the source database", dumpErr)
+		}
+		if err != nil {
+			return util.NewError(util.DumpError, "Failed to restore mysql dump", err)
+		}
+	}
+
+	r.println("\tOK")
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package restore
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/alexmullins/zip"
+	"github.com/deskpro/dputils/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// checkDiskSpace refuses to start the restore if the sources downloaded and extracted into the TmpDir, the
+// attachments copied into the attachments dir and the safety snapshot don't fit on their disks, unless Force is
+// set. Sizes which can't be found out without downloading, e.g. of s3 URLs, aren't counted.
+func (r *restorer) checkDiskSpace() error {
+	usages, err := util.EstimateDiskUsage(r.spaceRequirements())
+	if err != nil {
+		log.Warning("Failed to read the free disk space ", err)
+		return nil
+	}
+	for _, usage := range usages {
+		r.println("Disk space " + usage.String())
+	}
+
+	err = util.DiskSpaceError(usages)
+	if err != nil && r.opts.Force {
+		r.warning(err.Error())
+		r.println("\tWarning: the restore may not fit on the disk, starting anyway because of --force")
+		return nil
+	}
+
+	return err
+}
+
+// spaceRequirements estimates what the restore writes on the local disks. A remote archive is downloaded by
+// go-getter into the system temp dir before it's extracted, local archives are extracted in place.
+func (r *restorer) spaceRequirements() []util.SpaceRequirement {
+	var (
+		requirements   []util.SpaceRequirement
+		tmpdir         = r.opts.TmpDir
+		attachmentsDir = util.AttachmentsPath(r.opts.DeskproConfig, r.opts.DeskproPath)
+	)
+	add := func(dir string, purpose string, bytes int64) {
+		requirements = append(requirements, util.SpaceRequirement{Dir: dir, Purpose: purpose, Bytes: bytes})
+	}
+
+	if r.opts.FullBackup != "" {
+		for _, archive := range append([]string{r.opts.FullBackup}, r.opts.Incrementals...) {
+			src := sourcePath(archive)
+			downloaded, extracted, attachments := r.archiveSize(src)
+			add(os.TempDir(), "download of "+filepath.Base(src), downloaded)
+			add(tmpdir, "extracted "+filepath.Base(src), extracted)
+			// the attachments are moved out of the extracted archive
+			if !util.SameDisk(tmpdir, attachmentsDir) {
+				add(attachmentsDir, "attachments of "+filepath.Base(src), attachments)
+			}
+		}
+	} else {
+		if r.opts.Dump != "" {
+			if size, ok := util.SourceSize(r.ctx, sourcePath(r.opts.Dump)); ok {
+				add(tmpdir, "database dump", size)
+			}
+		}
+		if r.opts.Attachments != "" && r.opts.Attachments != "none" {
+			r.addAttachmentsRequirements(add, sourcePath(r.opts.Attachments), attachmentsDir)
+		}
+	}
+
+	if r.opts.SafetySnapshot {
+		size, err := util.DeskproDatabasesSize(r.ctx, r.opts.DeskproConfig)
+		if err != nil {
+			log.Warning("Failed to estimate the size of the safety snapshot ", err)
+		}
+		add(tmpdir, "safety snapshot", size)
+	}
+
+	return requirements
+}
+
+// archiveSize returns the bytes downloaded, the bytes extracted and the bytes of attachments of a backup archive.
+// The content of a remote archive is unknown, it's assumed to take as much space as the archive.
+func (r *restorer) archiveSize(src string) (int64, int64, int64) {
+	if archive, _, ok := util.VolumeArchive(src); ok {
+		extracted, attachments := volumesSize(archive)
+		return 0, extracted, attachments
+	}
+
+	size, ok := util.SourceSize(r.ctx, src)
+	if !ok {
+		return 0, 0, 0
+	}
+	if _, err := os.Stat(src); err != nil {
+		return size, size, 0
+	}
+
+	extracted, err := util.ZipEntriesSize(src, "")
+	if err != nil {
+		// not a zip archive
+		return 0, size, 0
+	}
+	attachments, _ := util.ZipEntriesSize(src, "attachments/")
+
+	return 0, extracted, attachments
+}
+
+// addAttachmentsRequirements adds the space taken by the attachments restored from src, which is an archive or
+// a dir. Remote dirs are copied blob by blob and aren't counted.
+func (r *restorer) addAttachmentsRequirements(add func(string, string, int64), src string, attachmentsDir string) {
+	size, ok := util.SourceSize(r.ctx, src)
+	if !ok {
+		return
+	}
+
+	_, statErr := os.Stat(src)
+	if r.opts.AttachmentsArchive || detectArchive(src, r.opts.TmpDir) {
+		if statErr != nil {
+			add(os.TempDir(), "download of the attachments archive", size)
+		}
+		add(r.opts.TmpDir, "extracted attachments archive", size)
+		if !util.SameDisk(r.opts.TmpDir, attachmentsDir) {
+			add(attachmentsDir, "attachments", size)
+		}
+		return
+	}
+
+	if statErr == nil && !(r.opts.MoveAttachments && util.SameDisk(src, attachmentsDir)) {
+		add(attachmentsDir, "attachments", size)
+	}
+}
+
+// sourcePath turns a path or a file:// URL given as an option into an absolute path, other URLs are returned as
+// they are. A one letter scheme is a Windows drive.
+func sourcePath(src string) string {
+	if u, err := url.Parse(src); err == nil && u.Scheme == "file" {
+		src = u.Path
+	} else if err == nil && len(u.Scheme) > 1 {
+		return src
+	}
+	if abs, err := filepath.Abs(src); err == nil {
+		return abs
+	}
+
+	return src
+}
+
+// volumesSize returns what an archive split into local volumes and its attachments take once extracted. The
+// volumes are read where they are, so they don't take space in the tmpdir.
+func volumesSize(archive string) (int64, int64) {
+	manifest, err := readVolumeManifest(util.VolumeName(archive, 1))
+	if err != nil {
+		return 0, 0
+	}
+
+	volumes := &volumeReader{}
+	defer volumes.Close()
+	for i, volume := range manifest.Volumes {
+		f, err := os.Open(util.VolumeName(archive, i+2))
+		if err != nil {
+			return 0, 0
+		}
+		volumes.add(f, volume.Size, false)
+	}
+
+	reader, err := zip.NewReader(volumes, volumes.size)
+	if err != nil {
+		return volumes.size, manifest.Attachments.Size
+	}
+
+	return util.ZipFilesSize(reader.File, ""), util.ZipFilesSize(reader.File, "attachments/")
+}
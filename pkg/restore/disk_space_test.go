@@ -0,0 +1,47 @@
+package restore
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/deskpro/dputils/util"
+)
+
+func Test_spaceRequirements(t *testing.T) {
+	archive, _ := filepath.Abs(filepath.Join("..", "..", "test_mocks", "backup.zip"))
+	extracted, err := util.ZipEntriesSize(archive, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpdir := t.TempDir()
+	r := newTestRestorer(Options{FullBackup: archive, TmpDir: tmpdir, DeskproPath: tmpdir})
+	required := map[string]int64{}
+	for _, requirement := range r.spaceRequirements() {
+		required[requirement.Dir] += requirement.Bytes
+	}
+	// a local archive is extracted in place and its attachments are moved on the same disk
+	if len(required) != 2 || required[tmpdir] != extracted {
+		t.Errorf("Expected %d bytes in the tmpdir, got %v", extracted, required)
+	}
+
+	// the volumes are read where they are, only the extracted archive takes space in the tmpdir
+	firstVolume := splitVolumes(t, archive, t.TempDir(), 200)
+	r = newTestRestorer(Options{FullBackup: firstVolume, TmpDir: tmpdir, DeskproPath: tmpdir})
+	attachments, _ := util.ZipEntriesSize(archive, "attachments/")
+	if _, size, attachmentsSize := r.archiveSize(firstVolume); size != extracted || attachmentsSize != attachments {
+		t.Errorf("Expected %d bytes extracted and %d bytes of attachments, got %d and %d", extracted, attachments, size, attachmentsSize)
+	}
+}
+
+func Test_sourcePath(t *testing.T) {
+	abs, _ := filepath.Abs("backup.zip")
+	for src, expected := range map[string]string{
+		"backup.zip":                     abs,
+		"https://example.com/backup.zip": "https://example.com/backup.zip",
+	} {
+		if path := sourcePath(src); path != expected {
+			t.Errorf("Expected %s, got %s", expected, path)
+		}
+	}
+}
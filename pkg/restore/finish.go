@@ -0,0 +1,171 @@
+package restore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/deskpro/dputils/util"
+	log "github.com/sirupsen/logrus"
+)
+
+func (r *restorer) markAsTestInstance(destinationMysqlConn util.MysqlConn) {
+	if r.opts.AsTestInstance {
+		r.phaseStarted("test_instance")
+		defer r.phaseFinished("test_instance")
+
+		r.println("==========================================================================================")
+		r.println("Marking your new Deskpro instance as test instance (email accounts)")
+		r.println("==========================================================================================")
+		r.println("Disabling email accounts")
+		_, err := destinationMysqlConn.Conn.Exec("UPDATE `email_accounts` SET `is_enabled` = 0")
+		if err != nil {
+			r.warning("Failed to disable email accounts: " + err.Error())
+			r.println("\tFailed to disable accounts")
+		} else {
+			r.println("\tOK")
+		}
+		r.println("Disable url corrections and outgoing emails")
+
+		var re = regexp.MustCompile(`(\$SETTINGS\['disable_url_corrections'\]\s*=)\s*(true|false)(;)`)
+
+		configPath := filepath.Join(r.opts.DeskproPath, "config", "advanced", "config.settings.php")
+
+		bytesRead, err := ioutil.ReadFile(configPath)
+		if err != nil {
+			r.warning("Failed to disable url corrections and outgoing email: " + err.Error())
+			r.println("\tCan't read config file.")
+			return
+		}
+
+		s := string(bytesRead)
+
+		if strings.Contains(s, "disable_url_corrections") && strings.Contains(s, "disable_outgoing_email") {
+			s = re.ReplaceAllString(s, "$1 true$3")
+
+			re = regexp.MustCompile(`(\$SETTINGS\['disable_outgoing_email'\]\s*=)\s*(true|false)(;)`)
+			s = re.ReplaceAllString(s, "$1 true$3")
+
+			err = ioutil.WriteFile(configPath, []byte(s), 0644)
+			if err != nil {
+				r.println("\tCan't disable url corrections and outgoing email.")
+				return
+			}
+		} else {
+			if file, err := os.OpenFile(configPath, os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+				r.println("\tCan't disable url corrections and outgoing email: can not open config file")
+			} else {
+				if _, err = file.Write([]byte("\r$SETTINGS['disable_url_corrections'] = true;")); err != nil {
+					r.println("\tCan't disable url corrections and outgoing email: can't write config file")
+					return
+				}
+				if _, err = file.Write([]byte("\r$SETTINGS['disable_outgoing_email'] = true;")); err != nil {
+					r.println("\tCan't disable url corrections and outgoing email: can't write config file")
+					return
+				}
+			}
+		}
+
+		r.println("\tOK")
+	}
+}
+
+func (r *restorer) doElasticReset(destinationMysqlConn util.MysqlConn) {
+	if r.opts.ReindexElastic {
+		r.phaseStarted("elastic_reset")
+		defer r.phaseFinished("elastic_reset")
+
+		r.println("==========================================================================================")
+		r.println("Scheduling Elasticsearch indexation")
+		r.println("==========================================================================================")
+		r.println("Setting elastic.requires_reset flag")
+		_, err := destinationMysqlConn.Conn.Exec("INSERT INTO `settings` (`name`, `value`) VALUES ('elastica.requires_reset', 1) ON DUPLICATE KEY UPDATE `value` = 1")
+		if err != nil {
+			r.println("\tFailed to set flag")
+		} else {
+			r.println("\tOK")
+		}
+		r.println("Updating Elastic indexer status")
+		_, err2 := destinationMysqlConn.Conn.Exec("DELETE FROM `datastore` WHERE `name` = 'sys.es_indexer'")
+		if err2 != nil {
+			r.println("\tFailed to reset indexer status")
+		} else {
+			r.println("\tOK")
+		}
+
+		if err != nil || err2 != nil {
+			r.warning("Failed to schedule Elastic reindex")
+			r.println("Failed to schedule Elastic reindex")
+		} else {
+			r.println("Scheduled Elasticsearch reindexation for next cron start")
+		}
+	}
+}
+
+// doUpgrade runs the Deskpro upgrade unless SkipUpgrade is set
+func (r *restorer) doUpgrade() error {
+	r.println("==========================================================================================")
+	r.println("Running Deskpro upgrade")
+	r.println("==========================================================================================")
+
+	if r.opts.SkipUpgrade {
+		r.println("Skipping upgrade, --skip-upgrade flag specified")
+		return nil
+	}
+
+	r.phaseStarted("upgrade")
+
+	upgradeCmd := exec.CommandContext(
+		r.ctx,
+		r.opts.PhpPath,
+		filepath.Join(r.opts.DeskproPath, "bin", "console"),
+		"dp:upgrade",
+	)
+
+	var buff bytes.Buffer
+	upgradeCmd.Stdout = &buff
+	upgradeCmd.Stderr = &buff
+
+	_ = upgradeCmd.Start()
+
+	err := upgradeCmd.Wait()
+
+	if err != nil {
+		r.println(buff.String())
+		return util.NewError(util.UpgradeError, "Deskpro upgrade failed", err)
+	}
+
+	r.println("Deskpro upgrade success")
+	r.println(buff.String())
+	r.phaseFinished("upgrade")
+
+	return nil
+}
+
+// sanitize replaces personal data of the restored database with fake values
+func (r *restorer) sanitize(destinationMysqlConn util.MysqlConn) error {
+	sanitizer := r.opts.Sanitizer
+	r.println("==========================================================================================")
+	r.println("Replacing personal data and secrets with fake values (" + strings.Join(sanitizer.Groups, ", ") + ")")
+	r.println("==========================================================================================")
+
+	sanitizer.Db = destinationMysqlConn.Conn
+	_, err := sanitizer.Run(r.opts.SanitizeRules, func(result util.SanitizeResult) {
+		if result.Skipped != "" {
+			log.Warning("Sanitize skipped table ", result.Table, ": ", result.Skipped)
+			r.printf("\t%s: skipped, %s\n", result.Table, result.Skipped)
+			return
+		}
+		r.printf("\t%s: %d rows\n", result.Table, result.Rows)
+	})
+	if err != nil {
+		return util.NewError(util.GeneralError, "Failed to sanitize the database", err)
+	}
+	r.println("\tOK")
+
+	return nil
+}
@@ -0,0 +1,297 @@
+package restore
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/deskpro/dputils/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// planSources is what the validation of restore options found, it's described by printPlan
+type planSources struct {
+	fullBackup      bool
+	attachmentsDir  string
+	dumpDir         string
+	manifest        *util.Manifest
+	dbDumpLocal     string
+	sourceMysqlConn util.MysqlConn
+	attachUri       string
+	moveAttachments bool
+}
+
+// printPlan describes everything the restore would do with the validated options, without changing anything
+func (r *restorer) printPlan(destinationMysqlConn util.MysqlConn, sources planSources) error {
+	r.println("==========================================================================================")
+	r.println("Restore plan (--plan was specified, nothing will be changed)")
+	r.println("==========================================================================================")
+
+	r.println("Database")
+	r.printDestinationPlan(destinationMysqlConn)
+	if sources.dbDumpLocal != "" {
+		r.printDumpPlan(sources.dbDumpLocal)
+	} else {
+		r.printDirectSourcePlan(sources.sourceMysqlConn)
+	}
+	if !r.opts.Until.IsZero() {
+		binlogs, db, err := r.archivedBinlogs(sources.manifest)
+		if err != nil {
+			return err
+		}
+		r.printf("\tThen %d archived binary logs will be replayed from %s:%d until %s\n", len(binlogs), db.Binlog.File, db.Binlog.Position, r.opts.Until)
+	}
+
+	for _, dbType := range []string{"audit", "voice", "system"} {
+		if sources.fullBackup {
+			name := fullBackupDumpName(sources.manifest, dbType)
+			if name == "" || !fullBackupDumpExists(sources.dumpDir, name) {
+				continue
+			}
+			r.println("Database " + dbType)
+			if !r.printAdvancedDestinationPlan(dbType) {
+				r.println("\tThere is no connection config for this database, the restore would fail")
+			}
+			r.println("\tWill be restored from the dump " + name)
+		} else if _, ok := r.opts.SourceDatabases[dbType]; ok {
+			r.println("Database " + dbType)
+			if !r.printAdvancedDestinationPlan(dbType) {
+				r.println("\tThere is no connection config for this database, it will be restored into the default database")
+			}
+			sourceMysqlConn, err := r.connectSource(dbType)
+			if err != nil {
+				return err
+			}
+			r.printDirectSourcePlan(sourceMysqlConn)
+		}
+	}
+
+	r.println("Attachments")
+	r.printAttachmentsPlan(sources)
+
+	if r.opts.SafetySnapshot {
+		r.println("Safety snapshot")
+		r.println("\tThe databases and config files of this instance will be saved first, so the restore can be rolled back")
+	}
+
+	r.println("After the restore")
+	if r.opts.SkipUpgrade {
+		r.println("\tDeskpro upgrade will be skipped (--skip-upgrade)")
+	} else {
+		r.println("\tDeskpro upgrade will be run (bin/console dp:upgrade)")
+	}
+	if r.opts.ReindexElastic {
+		r.println("\tElasticsearch reindexation will be scheduled")
+	}
+	if r.opts.AsTestInstance {
+		r.println("\tEmail accounts will be disabled")
+		r.println("\tURL corrections and outgoing email will be disabled in " + filepath.Join(r.opts.DeskproPath, "config", "advanced", "config.settings.php"))
+	}
+	if r.opts.Sanitizer != nil {
+		r.println("\tPersonal data and secrets will be replaced with fake values (" + strings.Join(r.opts.Sanitizer.Groups, ", ") + ")")
+	}
+
+	r.println("==========================================================================================")
+	r.println("Run the same command without --plan to restore")
+	r.println("==========================================================================================")
+
+	return nil
+}
+
+func (r *restorer) printDestinationPlan(destinationMysqlConn util.MysqlConn) {
+	r.println("\tDestination: " + mysqlUrlDescription(destinationMysqlConn))
+
+	tables, views, err := listTables(destinationMysqlConn.Conn)
+	if err != nil {
+		log.Warning("Failed to list destination tables ", err)
+		r.println("\tFailed to list tables of the destination database: ", err)
+		return
+	}
+
+	if len(tables) == 0 && len(views) == 0 {
+		r.println("\tThe destination database is empty, no tables will be dropped")
+		return
+	}
+
+	if filter := r.opts.DumpOptions.Tables; !filter.IsZero() {
+		tables = filter.Filter(tables)
+		views = filter.Filter(views)
+		r.println("\tOnly the selected tables will be replaced: " + filter.String())
+		if len(tables) == 0 && len(views) == 0 {
+			r.println("\tNo destination tables are selected, no tables will be dropped")
+			return
+		}
+	}
+
+	if len(tables) > 0 {
+		r.printf("\t%d tables will be dropped: %s\n", len(tables), summarizeList(tables, 10))
+	}
+	if len(views) > 0 {
+		r.printf("\t%d views will be dropped: %s\n", len(views), summarizeList(views, 10))
+	}
+}
+
+// printAdvancedDestinationPlan describes the destination of an audit, voice or system database. It returns false
+// if there is no connection config for the database.
+func (r *restorer) printAdvancedDestinationPlan(dbType string) bool {
+	dpConfig := r.opts.DeskproConfig
+	prefix := "database_advanced." + dbType
+	destinationUrl := util.GetMysqlUrlFromConfig(dpConfig, prefix)
+	if destinationUrl.User.Username() == "" {
+		return false
+	}
+
+	conn, err := util.GetMysqlConnectionFromConfig(dpConfig, prefix)
+	if err != nil {
+		r.println("\tFailed to connect to the destination database: ", err)
+		return true
+	}
+	defer conn.Close()
+
+	r.printDestinationPlan(util.MysqlConn{MysqlUrl: destinationUrl, Conn: conn})
+
+	return true
+}
+
+func (r *restorer) printDumpPlan(dbDumpLocal string) {
+	info, err := os.Stat(dbDumpLocal)
+	if err != nil {
+		r.println("\tFailed to read the database dump: ", err)
+		return
+	}
+
+	compressed := ""
+	if detectArchive(dbDumpLocal, filepath.Dir(dbDumpLocal)) {
+		compressed = ", compressed"
+	}
+	r.printf("\tWill be restored from the dump %s (%d bytes%s)\n", dbDumpLocal, info.Size(), compressed)
+}
+
+func (r *restorer) printDirectSourcePlan(sourceMysqlConn util.MysqlConn) {
+	if sourceMysqlConn.Conn == nil {
+		return
+	}
+
+	dbName := strings.TrimLeft(sourceMysqlConn.MysqlUrl.Path, "/")
+	size, err := util.DatabaseSize(r.ctx, sourceMysqlConn.Conn, dbName)
+	if err != nil {
+		r.println("\tWill be copied from " + mysqlUrlDescription(sourceMysqlConn))
+		return
+	}
+
+	r.printf("\tWill be copied from %s (about %d bytes of data and indexes)\n", mysqlUrlDescription(sourceMysqlConn), size)
+}
+
+func (r *restorer) printAttachmentsPlan(sources planSources) {
+	if sources.attachUri == "" || sources.attachUri == "none" {
+		r.println("\tAttachments will be skipped")
+		return
+	}
+
+	action := "copied"
+	if sources.moveAttachments {
+		action = "moved"
+	}
+	target := util.AttachmentsPath(r.opts.DeskproConfig, r.opts.DeskproPath)
+
+	switch {
+	case sources.attachmentsDir != "":
+		files, size, err := countAttachmentFiles(sources.attachmentsDir)
+		if err != nil {
+			r.println("\tFailed to count attachments in the backup archive: ", err)
+			return
+		}
+		r.printf("\t%d files (%d bytes) will be %s from the backup archive into %s\n", files, size, action, target)
+
+	case sources.sourceMysqlConn.Conn != nil:
+		files, size, err := countSourceBlobs(sources.sourceMysqlConn.Conn)
+		if err != nil {
+			r.println("\tFailed to count attachments in the source database: ", err)
+			return
+		}
+		r.printf("\t%d files (%d bytes) will be %s from %s into %s\n", files, size, action, sources.attachUri, target)
+
+	default:
+		r.printf("\tAttachments listed in the restored database will be %s from %s into %s\n", action, sources.attachUri, target)
+	}
+}
+
+func mysqlUrlDescription(conn util.MysqlConn) string {
+	return conn.MysqlUrl.User.Username() + "@" + conn.MysqlUrl.Host + conn.MysqlUrl.Path
+}
+
+// fullBackupDumpExists tells if getFullBackupDump would find the dump in the extracted archive
+func fullBackupDumpExists(backupDir string, fileName string) bool {
+	files, err := os.ReadDir(backupDir)
+	if err != nil {
+		return false
+	}
+
+	for _, f := range files {
+		if f.Name() == fileName || strings.HasPrefix(f.Name(), fileName+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// listTables returns the base tables and the views of the database the connection is using
+func listTables(db *sql.DB) ([]string, []string, error) {
+	rows, err := db.Query("SHOW FULL TABLES")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var tables, views []string
+	for rows.Next() {
+		var name, tableType string
+		if err = rows.Scan(&name, &tableType); err != nil {
+			return nil, nil, err
+		}
+		if tableType == "VIEW" {
+			views = append(views, name)
+		} else {
+			tables = append(tables, name)
+		}
+	}
+
+	return tables, views, rows.Err()
+}
+
+func countSourceBlobs(db *sql.DB) (int64, int64, error) {
+	var files, size int64
+	err := db.QueryRow("SELECT COUNT(*), COALESCE(SUM(filesize), 0) FROM blobs WHERE storage_loc = 'fs'").Scan(&files, &size)
+
+	return files, size, err
+}
+
+func countAttachmentFiles(dir string) (int64, int64, error) {
+	var files, size int64
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		files++
+		size += info.Size()
+		return nil
+	})
+
+	return files, size, err
+}
+
+// summarizeList joins the first max items and tells how many more there are
+func summarizeList(items []string, max int) string {
+	if len(items) <= max {
+		return strings.Join(items, ", ")
+	}
+
+	return fmt.Sprintf("%s and %d more", strings.Join(items[:max], ", "), len(items)-max)
+}
@@ -0,0 +1,77 @@
+package restore
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_listTables(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SHOW FULL TABLES").WillReturnRows(sqlmock.NewRows([]string{"Tables_in_deskpro", "Table_type"}).
+		AddRow("people", "BASE TABLE").AddRow("people_names", "VIEW").AddRow("tickets", "BASE TABLE"))
+
+	tables, views, err := listTables(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != 2 || tables[0] != "people" || tables[1] != "tickets" {
+		t.Errorf("Unexpected tables %v", tables)
+	}
+	if len(views) != 1 || views[0] != "people_names" {
+		t.Errorf("Unexpected views %v", views)
+	}
+}
+
+func Test_countSourceBlobs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\), COALESCE\(SUM\(filesize\), 0\) FROM blobs WHERE storage_loc = 'fs'`).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "size"}).AddRow(3, 1024))
+
+	files, size, err := countSourceBlobs(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files != 3 || size != 1024 {
+		t.Errorf("Unexpected blob count %d and size %d", files, size)
+	}
+}
+
+func Test_countAttachmentFiles(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.MkdirAll(filepath.Join(dir, "1", "2"), 0755)
+	_ = os.WriteFile(filepath.Join(dir, "1", "2", "blob"), []byte("content"), 0644)
+	_ = os.WriteFile(filepath.Join(dir, "1", "other"), []byte("abc"), 0644)
+
+	files, size, err := countAttachmentFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files != 2 || size != 10 {
+		t.Errorf("Unexpected file count %d and size %d", files, size)
+	}
+
+	if files, _, err = countAttachmentFiles(filepath.Join(dir, "missing")); err != nil || files != 0 {
+		t.Error("A missing attachments dir should have no files")
+	}
+}
+
+func Test_summarizeList(t *testing.T) {
+	if s := summarizeList([]string{"a", "b"}, 2); s != "a, b" {
+		t.Errorf("Unexpected summary %q", s)
+	}
+	if s := summarizeList([]string{"a", "b", "c", "d"}, 2); s != "a, b and 2 more" {
+		t.Errorf("Unexpected summary %q", s)
+	}
+}
@@ -0,0 +1,506 @@
+// Package restore restores a Deskpro instance from a backup archive, a database dump or a running MySQL server,
+// together with its attachments. It's what the 'dputils restore' command runs.
+package restore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/deskpro/dputils/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// Options of a restore. The database is restored from FullBackup, Dump or SourceDatabases["default"], in this order.
+type Options struct {
+	// DeskproPath and PhpPath locate the Deskpro instance to restore into, DeskproConfig is its flat config
+	// as returned by util.Config.GetDeskproConfig
+	DeskproPath   string
+	PhpPath       string
+	DeskproConfig map[string]string
+
+	// TmpDir is where sources are downloaded to and where the checkpoint and safety snapshots are saved.
+	// Defaults to the system temp dir.
+	TmpDir string
+
+	// FullBackup is the path or URL of an archive created by the backup, Incrementals are incremental archives
+	// applied on top of it in the given order
+	FullBackup   string
+	Incrementals []string
+	// Dump is the path or URL of a database dump, it may be compressed
+	Dump string
+	// SourceDatabases are MySQL servers to copy the databases from directly, by database type ("default",
+	// "audit", "voice" or "system")
+	SourceDatabases map[string]url.URL
+	// Attachments is the path or URL the attachments are copied from, "none" skips them. Not used with FullBackup.
+	Attachments        string
+	AttachmentsArchive bool
+	MoveAttachments    bool
+
+	DumpOptions util.DumpOptions
+	// Until replays the binary logs archived in Binlogs (see package binlog) on the default database restored from
+	// FullBackup, from the position the dump was taken at up to a point in time or a GTID
+	Until   util.BinlogStop
+	Binlogs string
+	// AttachmentWorkers defaults to 4, AttachmentRetries is the number of retries of a failed attachment
+	AttachmentWorkers int
+	AttachmentRetries int
+
+	// Resume continues the restore interrupted in TmpDir
+	Resume         bool
+	SafetySnapshot bool
+	SkipUpgrade    bool
+	ReindexElastic bool
+	AsTestInstance bool
+	// Force starts the restore even if the estimated downloads, attachments and safety snapshot don't fit on the
+	// disks of the TmpDir and the attachments dir
+	Force bool
+	// Sanitizer replaces personal data of the restored database with SanitizeRules, if set
+	Sanitizer     *util.Sanitizer
+	SanitizeRules *util.SanitizeRules
+
+	// Out receives the human readable progress, it's discarded if nil
+	Out io.Writer
+	// Progress is called when a phase starts and finishes, with the progress of attachments and with warnings
+	Progress func(event util.Event)
+}
+
+type restorer struct {
+	ctx        context.Context
+	opts       Options
+	out        io.Writer
+	plan       bool
+	temp       *tempFiles
+	checkpoint *checkpoint
+	// phase is the phase in progress, it's recorded in the checkpoint if the restore is interrupted
+	phase string
+}
+
+// Run restores the Deskpro instance. Failures are returned as *util.Error, the kind tells what failed. Downloaded
+// files are removed when the restore fails before the checkpoint refers to them. When the context is canceled,
+// the running dump, import or copy is stopped and the phase it stopped in is recorded in the checkpoint, the error
+// is then a util.CanceledError.
+func Run(ctx context.Context, opts Options) error {
+	return run(ctx, opts, false)
+}
+
+// Plan validates the options and writes what Run would do into Out without changing anything. Backup archives
+// and dumps are still downloaded into the TmpDir to validate them, they're removed when the plan is printed.
+func Plan(ctx context.Context, opts Options) error {
+	return run(ctx, opts, true)
+}
+
+func run(ctx context.Context, opts Options, plan bool) error {
+	r := &restorer{ctx: ctx, opts: opts, out: opts.Out, plan: plan, temp: &tempFiles{}}
+	if r.out == nil {
+		r.out = ioutil.Discard
+	}
+	if r.opts.TmpDir == "" {
+		r.opts.TmpDir = os.TempDir()
+	}
+	if r.opts.AttachmentWorkers == 0 {
+		r.opts.AttachmentWorkers = 4
+	}
+	r.opts.DumpOptions.Warning = r.warning
+
+	return r.finish(r.run())
+}
+
+// finish removes the downloaded files when the restore failed, or after a plan which doesn't need them anymore.
+// An error caused by canceling the context becomes a util.CanceledError.
+func (r *restorer) finish(err error) error {
+	if err != nil && r.ctx.Err() != nil {
+		err = r.interrupted(r.ctx.Err())
+	}
+	if err != nil || r.plan {
+		r.temp.remove()
+	}
+
+	return err
+}
+
+// validateUntil checks the options of a point-in-time restore
+func (r *restorer) validateUntil() error {
+	if r.opts.Until.IsZero() {
+		if r.opts.Binlogs != "" {
+			return util.NewError(util.ConfigError, "--binlogs is only used together with --until", nil)
+		}
+		return nil
+	}
+
+	if r.opts.FullBackup == "" {
+		return util.NewError(util.ConfigError, "--until can only be used together with --full-backup", nil)
+	}
+	if r.opts.Binlogs == "" {
+		return util.NewError(util.ConfigError, "--until needs --binlogs, the dir the binary logs were archived to", nil)
+	}
+	if !r.opts.DumpOptions.Tables.IsZero() {
+		return util.NewError(util.ConfigError, "--until can't be used together with --include-tables or --exclude-tables", nil)
+	}
+
+	return nil
+}
+
+// interrupted records where the canceled restore stopped, so it can be continued with --resume
+func (r *restorer) interrupted(cause error) error {
+	message := "The restore was interrupted"
+	if r.phase != "" {
+		message += " during " + r.phase
+	}
+
+	r.println("")
+	r.println(message)
+	if r.checkpoint != nil && r.checkpoint.done(phaseSources) && !r.plan {
+		if err := r.checkpoint.stop(r.phase); err != nil {
+			log.Warning("Failed to save restore checkpoint ", err)
+		}
+		r.println("Run the same command with --resume to continue from where it stopped")
+	} else {
+		r.println("Nothing was restored yet")
+	}
+
+	return util.NewError(util.CanceledError, message, cause)
+}
+
+func (r *restorer) run() error {
+	r.phaseStarted("validate")
+
+	if r.opts.AttachmentWorkers < 1 || r.opts.AttachmentRetries < 0 {
+		return util.NewError(util.ConfigError, "--attachment-workers must be at least 1 and --attachment-retries can't be negative", nil)
+	}
+	if r.opts.Resume && r.plan {
+		return util.NewError(util.ConfigError, "--plan can't be used together with --resume", nil)
+	}
+	if err := r.validateUntil(); err != nil {
+		return err
+	}
+
+	var err error
+	if r.checkpoint, err = r.openCheckpoint(); err != nil {
+		return err
+	}
+	// a resumed restore has already (partially) restored the database, so it's not empty anymore
+	destinationMysqlConn, err := r.validateDeskpro("database", r.checkpoint.Phase == phaseStarted && r.opts.DumpOptions.Tables.IsZero())
+	if err != nil {
+		return err
+	}
+
+	r.phaseFinished("validate")
+
+	var (
+		moveAttachments bool
+		attachUri       string
+		dbDumpLocal     string
+		sourceMysqlConn util.MysqlConn
+		manifest        *util.Manifest
+		fullBackup      bool
+		backupDir       string
+	)
+
+	if !r.checkpoint.done(phaseSources) {
+		if err = r.checkDiskSpace(); err != nil {
+			return err
+		}
+
+		r.phaseStarted("sources")
+		if fullBackup, backupDir, err = r.checkFullBackup(); err != nil {
+			return err
+		}
+
+		if !fullBackup {
+			// this one needed to insure we have at least 1 default source connection or dump
+			if dbDumpLocal, sourceMysqlConn, err = r.validateDeskproSource(); err != nil {
+				return err
+			}
+			if attachUri, moveAttachments, err = r.validateAttachments(sourceMysqlConn.Conn); err != nil {
+				return err
+			}
+		} else {
+			manifest = r.readFullBackupManifest(backupDir)
+			dumpDir, dumpManifest, err := r.applyIncrementalBackups(backupDir, manifest)
+			if err != nil {
+				return err
+			}
+			moveAttachments = true
+			if manifest != nil && !manifest.Attachments.Included {
+				r.println("The backup archive doesn't contain attachments -- skipping attachments")
+				attachUri = "none"
+			} else {
+				attachUri = r.transformAttachUri(filepath.Join(backupDir, "attachments"))
+			}
+			r.checkpoint.BackupDir = backupDir
+			backupDir, manifest = dumpDir, dumpManifest
+			if dbDumpLocal, err = getFullBackupDump(r.ctx, backupDir, fullBackupDumpName(manifest, "default")); err != nil {
+				return err
+			}
+			if !r.opts.Until.IsZero() {
+				if _, _, err = r.archivedBinlogs(manifest); err != nil {
+					return err
+				}
+			}
+		}
+
+		r.checkpoint.FullBackup = fullBackup
+		r.checkpoint.DumpDir = backupDir
+		r.checkpoint.DumpPaths["default"] = dbDumpLocal
+		r.checkpoint.AttachUri = attachUri
+		r.checkpoint.MoveAttachments = moveAttachments
+
+		if r.plan {
+			attachmentsDir := ""
+			if fullBackup {
+				attachmentsDir = filepath.Join(r.checkpoint.BackupDir, "attachments")
+			}
+			err = r.printPlan(destinationMysqlConn, planSources{
+				fullBackup:      fullBackup,
+				attachmentsDir:  attachmentsDir,
+				dumpDir:         backupDir,
+				manifest:        manifest,
+				dbDumpLocal:     dbDumpLocal,
+				sourceMysqlConn: sourceMysqlConn,
+				attachUri:       attachUri,
+				moveAttachments: moveAttachments,
+			})
+			if err != nil {
+				return err
+			}
+			r.phaseFinished("sources")
+			return nil
+		}
+
+		r.saveCheckpoint(phaseSources)
+		// the checkpoint refers to the downloaded sources, a resumed restore needs them
+		r.temp.keep()
+		r.phaseFinished("sources")
+	} else {
+		r.println("Using the sources downloaded by the interrupted restore")
+		fullBackup, backupDir = r.checkpoint.FullBackup, r.checkpoint.DumpDir
+		dbDumpLocal = r.checkpoint.DumpPaths["default"]
+		attachUri, moveAttachments = r.checkpoint.AttachUri, r.checkpoint.MoveAttachments
+		if fullBackup {
+			manifest = r.readFullBackupManifest(backupDir)
+		} else if dbDumpLocal == "" && !r.checkpoint.done(phaseDatabases) {
+			if sourceMysqlConn, err = r.connectSource("default"); err != nil {
+				return err
+			}
+		}
+	}
+
+	if r.opts.SafetySnapshot && r.checkpoint.SnapshotId == "" {
+		if err = r.takeSafetySnapshot(); err != nil {
+			return err
+		}
+	}
+
+	if !r.checkpoint.done(phaseDatabases) {
+		err = r.restoreCheckpointedDatabase("default", func() error {
+			if err := r.restoreDatabase(destinationMysqlConn, sourceMysqlConn, dbDumpLocal); err != nil {
+				return err
+			}
+			if r.opts.Until.IsZero() {
+				return nil
+			}
+			return r.replayBinlogs(destinationMysqlConn, manifest)
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, dbType := range []string{"audit", "voice", "system"} {
+			dbType := dbType
+			err = r.restoreCheckpointedDatabase(dbType, func() error {
+				if !fullBackup {
+					// now let's check we have additional connections like audit, system or voice
+					return r.restoreDatabaseAdvanced(dbType)
+				}
+				return r.restoreDatabaseAdvancedDump(backupDir, manifest, dbType)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		r.saveCheckpoint(phaseDatabases)
+	}
+
+	// failed attachments and a failed upgrade don't stop the restore, the error is returned at the end
+	var stepErr error
+
+	if !r.checkpoint.done(phaseAttachments) {
+		r.phaseStarted("attachments")
+		lastId, err := util.LastBlobId(r.ctx, destinationMysqlConn.Conn)
+		if err != nil {
+			return util.NewError(util.AttachmentError, "Failed to read the last attachment of the restored database", err)
+		}
+		stepErr = r.restoreAttachments(destinationMysqlConn, attachUri, moveAttachments, lastId)
+		if stepErr == nil {
+			r.saveCheckpoint(phaseAttachments)
+			r.phaseFinished("attachments")
+		} else if util.ErrorKindOf(stepErr) != util.AttachmentError {
+			return stepErr
+		}
+	}
+
+	if err = r.doUpgrade(); err != nil {
+		if r.ctx.Err() != nil {
+			return err
+		}
+		stepErr = err
+		if r.checkpoint.SnapshotId != "" {
+			r.println("To put back the databases and config files from before the restore, run:")
+			r.println("\t" + RollbackCommand(r.checkpoint.SnapshotId, r.opts.TmpDir))
+		}
+	}
+	r.doElasticReset(destinationMysqlConn)
+	r.markAsTestInstance(destinationMysqlConn)
+	if r.opts.Sanitizer != nil {
+		r.phaseStarted("sanitize")
+		if err = r.sanitize(destinationMysqlConn); err != nil {
+			return err
+		}
+		r.phaseFinished("sanitize")
+	}
+
+	if stepErr != nil {
+		// the checkpoint is kept, so failed attachments can be copied with --resume
+		return stepErr
+	}
+
+	if err := r.checkpoint.remove(); err != nil {
+		log.Warning("Failed to remove restore checkpoint ", err)
+	}
+
+	return nil
+}
+
+func (r *restorer) println(a ...interface{}) {
+	fmt.Fprintln(r.out, a...)
+}
+
+func (r *restorer) printf(format string, a ...interface{}) {
+	fmt.Fprintf(r.out, format, a...)
+}
+
+func (r *restorer) event(e util.Event) {
+	if r.opts.Progress != nil {
+		r.opts.Progress(e)
+	}
+}
+
+func (r *restorer) phaseStarted(phase string) {
+	r.phase = phase
+	r.event(util.Event{Type: util.EventPhaseStarted, Phase: phase})
+}
+
+func (r *restorer) phaseFinished(phase string) {
+	r.phase = ""
+	r.event(util.Event{Type: util.EventPhaseFinished, Phase: phase})
+}
+
+func (r *restorer) warning(message string) {
+	r.event(util.Event{Type: util.EventWarning, Message: message})
+}
+
+// openCheckpoint loads the checkpoint of an interrupted restore if Resume is set, otherwise a new restore is started
+func (r *restorer) openCheckpoint() (*checkpoint, error) {
+	tmpdir := r.opts.TmpDir
+	if !r.opts.Resume {
+		if _, err := os.Stat(filepath.Join(tmpdir, checkpointName)); err == nil && !r.plan {
+			r.println("Found a checkpoint of an interrupted restore in " + tmpdir + ", starting from scratch.")
+			r.println("Use --resume to continue the interrupted restore instead.")
+		}
+		return newCheckpoint(tmpdir), nil
+	}
+
+	checkpoint, err := readCheckpoint(tmpdir)
+	if err != nil {
+		r.println("Make sure you use the same --tmpdir as the interrupted restore")
+		return nil, util.NewError(util.ConfigError, "Can't resume the restore, failed to read the checkpoint in "+tmpdir, err)
+	}
+
+	r.println("==========================================================================================")
+	r.println("Resuming the restore interrupted at", checkpoint.UpdatedAt.Format(time.RFC3339))
+	r.println("==========================================================================================")
+	if checkpoint.Phase != phaseStarted {
+		r.println("\tCompleted phase:", checkpoint.Phase)
+	}
+	if len(checkpoint.Databases) > 0 {
+		r.println("\tRestored databases:", strings.Join(checkpoint.Databases, ", "))
+	}
+	if checkpoint.LastBlobId > 0 {
+		r.println("\tAttachments copied up to blob", checkpoint.LastBlobId)
+	}
+	if checkpoint.StoppedIn != "" {
+		r.println("\tInterrupted during:", checkpoint.StoppedIn)
+		checkpoint.StoppedIn = ""
+	}
+
+	return checkpoint, nil
+}
+
+// takeSafetySnapshot saves the destination databases and config files before anything is changed
+func (r *restorer) takeSafetySnapshot() error {
+	r.phaseStarted("safety_snapshot")
+
+	r.println("==========================================================================================")
+	r.println("Safety snapshot")
+	r.println("==========================================================================================")
+
+	// the snapshot always holds every table, so it can be rolled back whatever the table filters are
+	dumpOpts := r.opts.DumpOptions
+	dumpOpts.Tables = util.TableFilter{}
+	snapshot, err := TakeSnapshot(r.ctx, r.opts.DeskproPath, r.opts.DeskproConfig, dumpOpts, r.opts.TmpDir, r.out)
+	if err != nil {
+		return util.NewError(util.DumpError, "Failed to take the safety snapshot, nothing was changed", err)
+	}
+
+	r.checkpoint.SnapshotId = snapshot.Id
+	r.saveCheckpoint(r.checkpoint.Phase)
+
+	r.println("\tSaved to " + snapshot.Dir())
+	r.println("If the restore fails, you can put everything back with:")
+	r.println("\t" + RollbackCommand(snapshot.Id, r.opts.TmpDir))
+	r.phaseFinished("safety_snapshot")
+
+	return nil
+}
+
+// RollbackCommand is the command which puts back the snapshot taken before a restore
+func RollbackCommand(snapshotId string, tmpdir string) string {
+	return "dputils rollback --snapshot " + snapshotId + " --tmpdir " + tmpdir
+}
+
+func (r *restorer) saveCheckpoint(phase string) {
+	if err := r.checkpoint.completePhase(phase); err != nil {
+		log.Warning("Failed to save restore checkpoint ", err)
+		r.warning("Failed to save the restore checkpoint: " + err.Error())
+		r.println("Failed to save the restore checkpoint, the restore can't be resumed if it's interrupted")
+		r.println(err)
+	}
+}
+
+// restoreCheckpointedDatabase runs the restore of a database unless it was restored before the restore was
+// interrupted
+func (r *restorer) restoreCheckpointedDatabase(dbType string, restore func() error) error {
+	if r.checkpoint.databaseRestored(dbType) {
+		r.println("Database " + dbType + " was restored before the restore was interrupted -- skipping")
+		return nil
+	}
+
+	r.phaseStarted("database_" + dbType)
+	if err := restore(); err != nil {
+		return err
+	}
+	r.phaseFinished("database_" + dbType)
+
+	if err := r.checkpoint.completeDatabase(dbType); err != nil {
+		log.Warning("Failed to save restore checkpoint ", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,277 @@
+package restore
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/deskpro/dputils/util"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestRestorer(opts Options) *restorer {
+	if opts.AttachmentWorkers == 0 {
+		opts.AttachmentWorkers = 2
+	}
+
+	return &restorer{
+		ctx:        context.Background(),
+		opts:       opts,
+		out:        ioutil.Discard,
+		temp:       &tempFiles{},
+		checkpoint: newCheckpoint(opts.TmpDir),
+	}
+}
+
+func Test_checkFullBackup(t *testing.T) {
+	r := newTestRestorer(Options{
+		FullBackup: filepath.Join("..", "..", "test_mocks", "backup.zip"),
+		TmpDir:     filepath.Join("..", "..", "test_mocks", "tmp"),
+	})
+	fullBackup, backup, err := r.checkFullBackup()
+
+	if err != nil || fullBackup != true {
+		t.Error("Backup checking failed!")
+	}
+
+	// clean after
+	_ = os.RemoveAll(backup)
+}
+
+func Test_finishRemovesPlanSources(t *testing.T) {
+	tmpdir := t.TempDir()
+	r := newTestRestorer(Options{
+		FullBackup: filepath.Join("..", "..", "test_mocks", "backup.zip"),
+		TmpDir:     tmpdir,
+	})
+	r.plan = true
+	if _, _, err := r.checkFullBackup(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(tmpdir); len(entries) == 0 {
+		t.Fatal("expected the backup to be extracted into the tmpdir")
+	}
+
+	if err := r.finish(nil); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected an empty tmpdir after the plan, found %d entries", len(entries))
+	}
+}
+
+func Test_getFullBackupDump(t *testing.T) {
+	dumpFile, err := getFullBackupDump(context.Background(), filepath.Join("..", "..", "test_mocks"), "database")
+
+	if err != nil || dumpFile == "" {
+		t.Error("Backup checking failed!")
+	}
+	_ = os.Remove(dumpFile)
+
+	dumpFile, err = getFullBackupDump(context.Background(), filepath.Join("..", "..", "test_mocks"), "database_compressed")
+
+	if err != nil || dumpFile == "" {
+		t.Error("Backup checking failed!")
+	}
+
+	_ = os.Remove(dumpFile)
+}
+
+func Test_restoreAttachments(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	expectedCountSql := `SELECT COUNT\(\*\), COALESCE\(SUM\(filesize\), 0\) FROM blobs WHERE id > \? AND id <= \? AND storage_loc = 'fs'`
+	mock.ExpectQuery(expectedCountSql).WithArgs(0, 2).WillReturnRows(sqlmock.NewRows([]string{"count", "size"}).AddRow(2, 8))
+	expectedSql := `SELECT id, save_path, blob_hash FROM blobs WHERE id > \? AND storage_loc = 'fs' ORDER BY id ASC LIMIT 100`
+	rows := sqlmock.NewRows([]string{"id", "save_path", "blob_hash"}).AddRow("1", "1/test", "test").AddRow("2", "1/test", "test")
+	mock.ExpectQuery(expectedSql).WithArgs(0).WillReturnRows(rows)
+	attachUri, _ := filepath.Abs(filepath.Join("..", "..", "test_mocks", "attachments"))
+	attachUri = transformAttachUri(attachUri)
+	murl := url.URL{
+		Scheme:   "mysql",
+		User:     url.UserPassword("deskpro", "deskpro"),
+		Host:     "localhost",
+		Path:     "/deskpro",
+		RawPath:  "",
+		RawQuery: "",
+	}
+	mysqlC := util.MysqlConn{MysqlUrl: murl, Conn: db}
+	var progress []util.Event
+	attachmentsPath := t.TempDir()
+	r := newTestRestorer(Options{
+		DeskproPath:   filepath.Join("..", "..", "test_mocks", "dp_dir"),
+		DeskproConfig: map[string]string{"paths.dp_paths.attachments": attachmentsPath},
+		TmpDir:        t.TempDir(),
+		Progress:      func(event util.Event) { progress = append(progress, event) },
+	})
+	if err := r.restoreAttachments(mysqlC, attachUri, false, 2); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(filepath.Join(attachmentsPath, "1", "test")); err != nil {
+		t.Error("The attachment wasn't copied into the configured attachments path")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+	if r.checkpoint.LastBlobId != 2 {
+		t.Errorf("Expected the checkpoint to be saved after the copied batch, got %d", r.checkpoint.LastBlobId)
+	}
+	if _, err := os.Stat(filepath.Join(r.opts.TmpDir, attachmentsReportName)); err != nil {
+		t.Error("Attachments report wasn't saved")
+	}
+	if len(progress) != 1 || progress[0].Type != util.EventProgress || progress[0].Files != 2 || progress[0].TotalFiles != 2 {
+		t.Errorf("Unexpected progress events %+v", progress)
+	}
+}
+
+func Test_copyBlob(t *testing.T) {
+	attachmentRetryBackoff = time.Millisecond
+	report := &attachmentReport{}
+	src := t.TempDir()
+	dst := t.TempDir()
+	_ = os.WriteFile(filepath.Join(src, "blob"), []byte("content"), 0644)
+	attachUri := transformAttachUri(src)
+
+	if !copyBlob(context.Background(), util.Blob{Id: 1, SavePath: "blob"}, attachUri, dst, true, 1, report) {
+		t.Error("Blob wasn't moved")
+	}
+	if copyBlob(context.Background(), util.Blob{Id: 2, SavePath: "missing"}, attachUri, dst, true, 2, report) {
+		t.Error("Missing blob was reported as copied")
+	}
+
+	if report.Copied != 1 || report.Bytes != 7 || report.Failed != 1 || report.Errors[0].Id != 2 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+}
+
+func Test_compareFileHash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blob")
+	_ = os.WriteFile(path, []byte("hello"), 0644)
+
+	if !compareFileHash(path, "5d41402abc4b2a76b9719d911017c592") {
+		t.Error("Matching hash wasn't recognized")
+	}
+	if compareFileHash(path, "d41d8cd98f00b204e9800998ecf8427e") {
+		t.Error("Hash of an empty file matched")
+	}
+}
+
+func Test_validateDeskproSourceDump(t *testing.T) {
+	tmpdir := t.TempDir()
+	r := newTestRestorer(Options{Dump: filepath.Join(t.TempDir(), "missing.sql"), TmpDir: tmpdir})
+
+	_, err := r.validateDeskproSourceDump()
+	if util.ErrorKindOf(err) != util.SourceError {
+		t.Fatalf("Expected a source error, got %v", err)
+	}
+
+	r.temp.remove()
+	if _, err := os.Stat(filepath.Join(tmpdir, "db.sql")); !os.IsNotExist(err) {
+		t.Error("The downloaded dump wasn't removed")
+	}
+}
+
+func Test_fullBackupDumpName(t *testing.T) {
+	if name := fullBackupDumpName(nil, "default"); name != "database" {
+		t.Errorf("Expected legacy default dump name, got %s", name)
+	}
+	if name := fullBackupDumpName(nil, "audit"); name != "database_advanced.audit" {
+		t.Errorf("Expected legacy audit dump name, got %s", name)
+	}
+
+	manifest := util.NewManifest("v0.1", "", false)
+	manifest.Databases = append(manifest.Databases, util.ManifestDatabase{Type: "default", Name: "deskpro", Entry: "database.sql"})
+
+	if name := fullBackupDumpName(manifest, "default"); name != "database.sql" {
+		t.Errorf("Expected dump name from the manifest, got %s", name)
+	}
+	if name := fullBackupDumpName(manifest, "audit"); name != "" {
+		t.Errorf("Expected no audit dump, got %s", name)
+	}
+}
+
+func Test_moveAttachmentsDir(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "attachments")
+	dst := filepath.Join(t.TempDir(), "attachments")
+	_ = os.MkdirAll(filepath.Join(src, "1", "2"), 0755)
+	_ = os.WriteFile(filepath.Join(src, "1", "2", "blob"), []byte("content"), 0644)
+
+	if err := moveAttachmentsDir(src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dst, "1", "2", "blob")); err != nil {
+		t.Error("Attachment wasn't moved into the base archive")
+	}
+
+	if err := moveAttachmentsDir(filepath.Join(src, "missing"), dst); err != nil {
+		t.Error("Archives without attachments should be skipped")
+	}
+}
+
+func TestRunValidatesOptions(t *testing.T) {
+	var events []util.Event
+	err := Run(context.Background(), Options{
+		TmpDir:            t.TempDir(),
+		AttachmentWorkers: -1,
+		Progress:          func(event util.Event) { events = append(events, event) },
+	})
+
+	if util.ErrorKindOf(err) != util.ConfigError {
+		t.Fatalf("Expected a config error, got %v", err)
+	}
+	if len(events) != 1 || events[0].Type != util.EventPhaseStarted || events[0].Phase != "validate" {
+		t.Errorf("Unexpected events %+v", events)
+	}
+}
+
+func Test_copyBlobCanceled(t *testing.T) {
+	backoff := attachmentRetryBackoff
+	attachmentRetryBackoff = time.Hour
+	defer func() { attachmentRetryBackoff = backoff }()
+	report := &attachmentReport{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if copyBlob(ctx, util.Blob{Id: 1, SavePath: "missing"}, transformAttachUri(t.TempDir()), t.TempDir(), true, 3, report) {
+		t.Error("Blob was reported as copied")
+	}
+	if report.Failed != 0 {
+		t.Errorf("Canceled blob was reported as failed %+v", report)
+	}
+}
+
+func Test_interrupted(t *testing.T) {
+	r := newTestRestorer(Options{TmpDir: t.TempDir()})
+	r.phaseStarted("database_default")
+
+	err := r.interrupted(context.Canceled)
+	if util.ErrorKindOf(err) != util.CanceledError {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if _, err = readCheckpoint(r.opts.TmpDir); err == nil {
+		t.Error("Checkpoint was saved before the sources were downloaded")
+	}
+
+	_ = r.checkpoint.completePhase(phaseSources)
+	_ = r.interrupted(context.Canceled)
+	resumed, err := readCheckpoint(r.opts.TmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.StoppedIn != "database_default" {
+		t.Errorf("Unexpected stopped phase %q", resumed.StoppedIn)
+	}
+}
@@ -0,0 +1,265 @@
+package restore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/deskpro/dputils/util"
+)
+
+const (
+	snapshotPrefix       = "dputils_snapshot_"
+	snapshotManifestName = "snapshot.json"
+)
+
+// Snapshot is a copy of the destination databases and Deskpro config files taken before a restore,
+// which can be put back with RestoreConfigFiles and RollbackDatabase
+type Snapshot struct {
+	Id          string             `json:"id"`
+	CreatedAt   time.Time          `json:"created_at"`
+	DeskproPath string             `json:"deskpro_path"`
+	Databases   []SnapshotDatabase `json:"databases"`
+	ConfigFiles []string           `json:"config_files"`
+
+	dir string
+}
+
+// SnapshotDatabase is the dump of one database type ("default", "audit", "voice" or "system") in the snapshot dir
+type SnapshotDatabase struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	File string `json:"file"`
+}
+
+// databaseConfigPrefix returns the prefix of the connection config for the database type ("default", "audit",
+// "voice" or "system")
+func databaseConfigPrefix(dbType string) string {
+	if dbType == "default" {
+		return "database"
+	}
+
+	return "database_advanced." + dbType
+}
+
+// TakeSnapshot dumps every configured database and copies the Deskpro config dir in dpPath into a new snapshot dir
+// in tmpdir. The progress is written into out.
+func TakeSnapshot(ctx context.Context, dpPath string, dpConfig map[string]string, opts util.DumpOptions, tmpdir string, out io.Writer) (*Snapshot, error) {
+	snapshot := &Snapshot{
+		CreatedAt:   time.Now(),
+		DeskproPath: dpPath,
+	}
+
+	var err error
+	if snapshot.Id, snapshot.dir, err = createSnapshotDir(tmpdir, snapshot.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	// a partial snapshot can't be rolled back to, don't leave it behind
+	if err = snapshot.save(ctx, dpConfig, opts, out); err != nil {
+		_ = os.RemoveAll(snapshot.dir)
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// createSnapshotDir creates a new snapshot dir in tmpdir and returns its id. The id is the creation time, snapshots
+// taken within the same second get a sequence suffix so an existing snapshot is never overwritten.
+func createSnapshotDir(tmpdir string, createdAt time.Time) (string, string, error) {
+	if err := os.MkdirAll(tmpdir, 0700); err != nil {
+		return "", "", err
+	}
+
+	base := createdAt.Format("20060102150405")
+	for seq := 1; ; seq++ {
+		id := base
+		if seq > 1 {
+			id = fmt.Sprintf("%s_%d", base, seq)
+		}
+		dir := filepath.Join(tmpdir, snapshotPrefix+id)
+		err := os.Mkdir(dir, 0700)
+		if err == nil {
+			return id, dir, nil
+		}
+		if !os.IsExist(err) {
+			return "", "", err
+		}
+	}
+}
+
+// save dumps the databases and copies the config files into the snapshot dir, the manifest is written last
+func (s *Snapshot) save(ctx context.Context, dpConfig map[string]string, opts util.DumpOptions, out io.Writer) error {
+	for _, dbType := range []string{"default", "audit", "voice", "system"} {
+		prefix := databaseConfigPrefix(dbType)
+		databaseUrl := util.GetMysqlUrlFromConfig(dpConfig, prefix)
+		if databaseUrl.User.Username() == "" {
+			continue
+		}
+
+		fmt.Fprintln(out, "Dumping the "+dbType+" database")
+		db := SnapshotDatabase{Type: dbType, Name: strings.TrimLeft(databaseUrl.Path, "/"), File: prefix + ".sql"}
+		if err := snapshotDump(ctx, dpConfig, databaseUrl, opts, filepath.Join(s.dir, db.File)); err != nil {
+			return err
+		}
+		s.Databases = append(s.Databases, db)
+	}
+
+	fmt.Fprintln(out, "Copying config files")
+	configDir := filepath.Join(s.DeskproPath, "config")
+	err := filepath.Walk(configDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(configDir, path)
+		if err != nil {
+			return err
+		}
+		s.ConfigFiles = append(s.ConfigFiles, filepath.ToSlash(rel))
+
+		return util.CopyFile(path, filepath.Join(s.dir, "config", rel))
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(s.dir, snapshotManifestName), data, 0600)
+}
+
+func snapshotDump(ctx context.Context, dpConfig map[string]string, databaseUrl url.URL, opts util.DumpOptions, path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = util.DumpDatabase(ctx, dpConfig, databaseUrl, opts, f); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// Dir is the dir the snapshot is saved in
+func (s *Snapshot) Dir() string {
+	return s.dir
+}
+
+// ReadSnapshot finds a snapshot by its id in tmpdir, or by the path to the snapshot dir
+func ReadSnapshot(tmpdir string, idOrPath string) (*Snapshot, error) {
+	dir := filepath.Join(tmpdir, snapshotPrefix+idOrPath)
+	if _, err := os.Stat(filepath.Join(idOrPath, snapshotManifestName)); err == nil {
+		dir = idOrPath
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, snapshotManifestName))
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{dir: dir}
+	if err = json.Unmarshal(data, snapshot); err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// ListSnapshots returns the ids of the snapshots in tmpdir, the oldest first
+func ListSnapshots(tmpdir string) []string {
+	files, err := ioutil.ReadDir(tmpdir)
+	if err != nil {
+		return nil
+	}
+
+	var ids []string
+	for _, f := range files {
+		if f.IsDir() && strings.HasPrefix(f.Name(), snapshotPrefix) {
+			ids = append(ids, strings.TrimPrefix(f.Name(), snapshotPrefix))
+		}
+	}
+
+	return ids
+}
+
+// RestoreConfigFiles copies the config files from the snapshot back into the Deskpro config dir
+func (s *Snapshot) RestoreConfigFiles() error {
+	for _, name := range s.ConfigFiles {
+		src := filepath.Join(s.dir, "config", filepath.FromSlash(name))
+		dst := filepath.Join(s.DeskproPath, "config", filepath.FromSlash(name))
+		if err := util.CopyFile(src, dst); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RollbackDatabase wipes the database of the snapshot database type and imports the snapshot dump into it
+func (s *Snapshot) RollbackDatabase(ctx context.Context, dpConfig map[string]string, opts util.DumpOptions, db SnapshotDatabase) error {
+	prefix := databaseConfigPrefix(db.Type)
+	conn, err := util.GetMysqlConnectionFromConfig(dpConfig, prefix)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	dump, err := os.Open(filepath.Join(s.dir, db.File))
+	if err != nil {
+		return err
+	}
+	defer dump.Close()
+
+	if err = dropTables(ctx, conn, util.TableFilter{}); err != nil {
+		return err
+	}
+
+	return util.ImportDatabase(ctx, dpConfig, util.GetMysqlUrlFromConfig(dpConfig, prefix), conn, opts, dump)
+}
+
+// dropTables drops the tables and views selected by the filter, every table and view of the database the
+// connection is using if it's zero
+func dropTables(ctx context.Context, db *sql.DB, filter util.TableFilter) error {
+	tables, views, err := listTables(db)
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// foreign key checks are per session, so all tables must be dropped on the same connection
+	if _, err = conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 0"); err != nil {
+		return err
+	}
+	for _, view := range filter.Filter(views) {
+		if _, err = conn.ExecContext(ctx, "DROP VIEW "+util.QuoteIdentifier(view)); err != nil {
+			return fmt.Errorf("can't drop view %s: %s", view, err)
+		}
+	}
+	for _, table := range filter.Filter(tables) {
+		if _, err = conn.ExecContext(ctx, "DROP TABLE "+util.QuoteIdentifier(table)); err != nil {
+			return fmt.Errorf("can't drop table %s: %s", table, err)
+		}
+	}
+	_, err = conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 1")
+
+	return err
+}
@@ -0,0 +1,111 @@
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/deskpro/dputils/util"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_ReadSnapshot(t *testing.T) {
+	tmpdir := t.TempDir()
+	dpDir := t.TempDir()
+	dir := filepath.Join(tmpdir, snapshotPrefix+"20200101120000")
+
+	_ = os.MkdirAll(filepath.Join(dir, "config", "advanced"), 0755)
+	_ = os.WriteFile(filepath.Join(dir, "config", "advanced", "config.settings.php"), []byte("<?php // before"), 0644)
+	data, _ := json.Marshal(Snapshot{
+		Id:          "20200101120000",
+		DeskproPath: dpDir,
+		Databases:   []SnapshotDatabase{{Type: "default", Name: "deskpro", File: "database.sql"}},
+		ConfigFiles: []string{"advanced/config.settings.php"},
+	})
+	_ = os.WriteFile(filepath.Join(dir, snapshotManifestName), data, 0644)
+
+	if ids := ListSnapshots(tmpdir); len(ids) != 1 || ids[0] != "20200101120000" {
+		t.Errorf("Unexpected snapshots %v", ids)
+	}
+
+	snapshot, err := ReadSnapshot(tmpdir, "20200101120000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Databases) != 1 || snapshot.Databases[0].File != "database.sql" {
+		t.Errorf("Unexpected snapshot %+v", snapshot)
+	}
+	if _, err = ReadSnapshot(t.TempDir(), dir); err != nil {
+		t.Error("Snapshot should be found by its path")
+	}
+
+	if err = snapshot.RestoreConfigFiles(); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(filepath.Join(dpDir, "config", "advanced", "config.settings.php"))
+	if string(content) != "<?php // before" {
+		t.Errorf("Config file wasn't restored, got %q", content)
+	}
+}
+
+func Test_createSnapshotDir(t *testing.T) {
+	tmpdir := filepath.Join(t.TempDir(), "tmp")
+	createdAt := time.Date(2020, 1, 1, 12, 0, 0, 0, time.Local)
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		id, dir, err := createSnapshotDir(tmpdir, createdAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dir != filepath.Join(tmpdir, snapshotPrefix+id) {
+			t.Errorf("Unexpected snapshot dir %s for %s", dir, id)
+		}
+		ids = append(ids, id)
+	}
+
+	expected := []string{"20200101120000", "20200101120000_2", "20200101120000_3"}
+	if !reflect.DeepEqual(expected, ids) {
+		t.Errorf("Expected %v was %v", expected, ids)
+	}
+	if listed := ListSnapshots(tmpdir); !reflect.DeepEqual(expected, listed) {
+		t.Errorf("Expected the snapshots %v to be listed, was %v", expected, listed)
+	}
+}
+
+func Test_dropTables(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SHOW FULL TABLES").WillReturnRows(sqlmock.NewRows([]string{"Tables_in_deskpro", "Table_type"}).
+		AddRow("people", "BASE TABLE").AddRow("people_names", "VIEW").AddRow("tickets", "BASE TABLE"))
+	mock.ExpectExec("SET FOREIGN_KEY_CHECKS = 0").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DROP VIEW `people_names`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DROP TABLE `people`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DROP TABLE `tickets`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SET FOREIGN_KEY_CHECKS = 1").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err = dropTables(context.Background(), db, util.TableFilter{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the selected tables are dropped
+	mock.ExpectQuery("SHOW FULL TABLES").WillReturnRows(sqlmock.NewRows([]string{"Tables_in_deskpro", "Table_type"}).
+		AddRow("people", "BASE TABLE").AddRow("people_names", "VIEW").AddRow("tickets", "BASE TABLE"))
+	mock.ExpectExec("SET FOREIGN_KEY_CHECKS = 0").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DROP TABLE `tickets`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SET FOREIGN_KEY_CHECKS = 1").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err = dropTables(context.Background(), db, util.TableFilter{Include: []string{"tick*"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
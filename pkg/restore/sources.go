@@ -0,0 +1,493 @@
+package restore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/deskpro/dputils/util"
+	"github.com/hashicorp/go-getter"
+	urlhelper "github.com/hashicorp/go-getter/helper/url"
+	log "github.com/sirupsen/logrus"
+)
+
+// readFullBackupManifest reads the manifest of an extracted backup archive. Archives created by older versions
+// of dputils don't have a manifest, nil is returned for them.
+func (r *restorer) readFullBackupManifest(backupDir string) *util.Manifest {
+	manifest, err := util.ReadManifestFile(filepath.Join(backupDir, util.ManifestName))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warning("Failed to read backup manifest ", err)
+			r.println("Failed to read the backup manifest, guessing the archive content instead")
+		}
+		return nil
+	}
+
+	return manifest
+}
+
+// fullBackupDumpName returns the name of the dump file for the database type ("default", "audit", "voice"
+// or "system"). The name is taken from the manifest if the archive has one, otherwise it's the name
+// the backup command has always used. An empty string means the archive doesn't contain the dump.
+func fullBackupDumpName(manifest *util.Manifest, dbType string) string {
+	if manifest != nil {
+		if db := manifest.Database(dbType); db != nil {
+			return db.Entry
+		}
+		return ""
+	}
+
+	if dbType == "default" {
+		return "database"
+	}
+
+	return "database_advanced." + dbType
+}
+
+func getFullBackupDump(ctx context.Context, backupDir string, fileName string) (string, error) {
+	if fileName == "" {
+		return "", nil
+	}
+
+	dir, _ := filepath.Abs(backupDir)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", util.NewError(util.SourceError, "Failed to get full backup dump file", err)
+	}
+
+	for _, f := range files {
+		if f.Name() == fileName || strings.HasPrefix(f.Name(), fileName+".") {
+			dumpPath := filepath.Join(dir, "dump"+fmt.Sprintf("%d", time.Now().Unix())+".sql")
+			err := download(ctx, dumpPath, filepath.Join(dir, f.Name()), getter.ClientModeFile)
+			if err != nil {
+				return "", util.NewError(util.SourceError, "Failed to get full backup dump file", err)
+			}
+
+			return dumpPath, nil
+		}
+	}
+
+	return "", nil
+}
+
+// download fetches src into dst like getter.GetFile (getter.ClientModeFile) or getter.GetAny
+// (getter.ClientModeAny), the download stops when the context is canceled
+func download(ctx context.Context, dst string, src string, mode getter.ClientMode) error {
+	client := &getter.Client{Ctx: ctx, Src: src, Dst: dst, Mode: mode}
+
+	return client.Get()
+}
+
+func (r *restorer) checkFullBackup() (bool, string, error) {
+
+	var err error
+	backupUri := r.opts.FullBackup
+	tmpdir := r.opts.TmpDir
+	if backupUri != "" {
+		_, err = url.ParseRequestURI(backupUri)
+		if err != nil {
+			// not an URL with a scheme, so assume a filesystem path
+			if backupUri, err = filepath.Abs(backupUri); err != nil {
+				return false, "", util.NewError(util.ConfigError, "Backup path is wrong, please check the path for the backup archive carefully", err)
+			}
+		}
+
+		r.println("==========================================================================================")
+		r.println("Detected a full backup flag. Restoring from the full backup archive")
+		r.println("==========================================================================================")
+		fakename, err := r.fetchBackupArchive(backupUri, "backup_archive")
+		if err != nil {
+			return false, "", err
+		}
+		if manifest := r.readFullBackupManifest(filepath.Join(tmpdir, fakename)); manifest != nil {
+			if err = r.checkFullBackupManifest(manifest, filepath.Join(tmpdir, fakename)); err != nil {
+				return false, "", err
+			}
+			return true, filepath.Join(tmpdir, fakename), nil
+		}
+
+		if _, err := os.Stat(filepath.Join(tmpdir, fakename, "attachments")); os.IsNotExist(err) {
+			return false, "", util.NewError(util.SourceError, "We can't find attachments subdir in your backup archive", nil)
+		}
+
+		dumpExists := false
+		files, _ := ioutil.ReadDir(filepath.Join(tmpdir, fakename))
+		for _, f := range files {
+			if strings.HasPrefix(f.Name(), "database"+".") {
+				dumpExists = true
+			}
+		}
+
+		if !dumpExists {
+			return false, "", util.NewError(util.SourceError, "We can't find database dump file in your backup archive", nil)
+		}
+
+		return true, filepath.Join(tmpdir, fakename), nil
+	}
+
+	return false, "", nil
+}
+
+// fetchBackupArchive downloads and extracts a backup archive into a new dir in the tmpdir and returns the dir name.
+// An archive split into volumes is extracted from its volumes.
+func (r *restorer) fetchBackupArchive(backupUri string, name string) (string, error) {
+	fakename := name + fmt.Sprintf("%d", time.Now().UnixNano())
+	r.temp.add(filepath.Join(r.opts.TmpDir, fakename))
+	if _, _, ok := util.VolumeArchive(backupUri); ok {
+		if err := r.extractVolumes(backupUri, filepath.Join(r.opts.TmpDir, fakename)); err != nil {
+			return "", err
+		}
+		return fakename, nil
+	}
+
+	err := download(r.ctx, filepath.Join(r.opts.TmpDir, fakename), backupUri, getter.ClientModeAny)
+	if err != nil {
+		r.println("If using an URL, remember to include the scheme (http:// or https://)")
+		return "", util.NewError(util.SourceError, "Failed to get backup archive "+backupUri, err)
+	}
+
+	return fakename, nil
+}
+
+// applyIncrementalBackups extracts the incremental archives and moves their attachments into the extracted base
+// archive, in the given order. It returns the dir and manifest of the most recent archive with a database dump,
+// which is where the database should be restored from.
+func (r *restorer) applyIncrementalBackups(backupDir string, manifest *util.Manifest) (string, *util.Manifest, error) {
+	dumpDir, dumpManifest := backupDir, manifest
+
+	for _, incrementalUri := range r.opts.Incrementals {
+		if _, err := url.ParseRequestURI(incrementalUri); err != nil {
+			incrementalUri, _ = filepath.Abs(incrementalUri)
+		}
+
+		r.println("Applying incremental backup archive " + incrementalUri)
+		incrementalName, err := r.fetchBackupArchive(incrementalUri, "incremental_archive")
+		if err != nil {
+			return "", nil, err
+		}
+		incrementalDir := filepath.Join(r.opts.TmpDir, incrementalName)
+		incrementalManifest := r.readFullBackupManifest(incrementalDir)
+
+		if incrementalManifest == nil || incrementalManifest.Incremental == nil {
+			return "", nil, util.NewError(util.SourceError, incrementalUri+" is not an incremental backup archive", nil)
+		}
+
+		if manifest != nil && incrementalManifest.Incremental.SinceBlobId > manifest.Attachments.LastBlobId {
+			return "", nil, util.NewError(util.SourceError, fmt.Sprintf(
+				"%s starts after attachment %d, but the previous archive ends with attachment %d. Is an archive missing from the chain?",
+				incrementalUri, incrementalManifest.Incremental.SinceBlobId, manifest.Attachments.LastBlobId,
+			), nil)
+		}
+
+		if err := moveAttachmentsDir(filepath.Join(incrementalDir, "attachments"), filepath.Join(backupDir, "attachments")); err != nil {
+			return "", nil, util.NewError(util.AttachmentError, "Failed to apply attachments from "+incrementalUri, err)
+		}
+		r.println("\tAttachments:", incrementalManifest.Attachments.Files, "files")
+
+		if incrementalManifest.Database("default") != nil {
+			dumpDir, dumpManifest = incrementalDir, incrementalManifest
+		}
+		manifest = incrementalManifest
+	}
+
+	return dumpDir, dumpManifest, nil
+}
+
+// moveAttachmentsDir moves every file from the src dir into the same relative path in the dst dir
+func moveAttachmentsDir(src string, dst string) error {
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return nil
+	}
+
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dst, rel)
+		if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		return os.Rename(path, target)
+	})
+}
+
+// checkFullBackupManifest makes sure every database dump and the attachments listed in the manifest were extracted
+func (r *restorer) checkFullBackupManifest(manifest *util.Manifest, backupDir string) error {
+	r.println("Backup archive created by dputils", manifest.Version, "on", manifest.Host, "at", manifest.CreatedAt.Format(time.RFC3339))
+
+	if manifest.Database("default") == nil {
+		return util.NewError(util.SourceError, "Your backup archive doesn't contain the Deskpro database dump", nil)
+	}
+
+	for _, db := range manifest.Databases {
+		if _, err := os.Stat(filepath.Join(backupDir, db.Entry)); os.IsNotExist(err) {
+			return util.NewError(util.SourceError, "We can't find "+db.Entry+" database dump file in your backup archive", nil)
+		}
+		r.println("\tDatabase dump:", db.Type, "("+db.Entry+")")
+		if db.Tables != nil {
+			r.println("\t\tTables: " + db.Tables.String())
+			if r.opts.DumpOptions.Tables.IsZero() {
+				message := "The " + db.Type + " database dump only contains some tables, the other tables will be dropped and not restored"
+				r.warning(message)
+				r.println("\t\t" + message + ", use --include-tables to restore just the backed up tables")
+			}
+		}
+	}
+
+	if manifest.Attachments.Included {
+		if _, err := os.Stat(filepath.Join(backupDir, "attachments")); os.IsNotExist(err) && manifest.Attachments.Files > 0 {
+			return util.NewError(util.SourceError, "We can't find attachments subdir in your backup archive", nil)
+		}
+		r.println("\tAttachments:", manifest.Attachments.Files, "files")
+	}
+
+	return nil
+}
+
+func (r *restorer) validateDeskproSource() (string, util.MysqlConn, error) {
+	//------------------------------
+	// Database conn or dump
+	//------------------------------
+	r.println("After you have wiped the existing installation, try the restore again.")
+
+	r.println("")
+	r.println("==========================================================================================")
+	r.println("Your existing database to restore")
+	r.println("==========================================================================================")
+
+	var (
+		dbDumpLocal string
+		sourceConn  util.MysqlConn
+		err         error
+	)
+	if _, ok := r.opts.SourceDatabases["default"]; ok {
+		sourceConn, err = r.connectSource("default")
+	} else if r.opts.Dump != "" {
+		dbDumpLocal, err = r.validateDeskproSourceDump()
+	} else {
+		log.Info("no --mysql-direct or --mysql-dump specified")
+		err = util.NewError(util.ConfigError, "We need a way to get the database. You can use either --mysql-direct or --mysql-dump. Check --help for more information.", nil)
+	}
+
+	return dbDumpLocal, sourceConn, err
+}
+
+// connectSource connects to the source database of the type ("default", "audit", "voice" or "system")
+func (r *restorer) connectSource(dbType string) (util.MysqlConn, error) {
+	mysqlUrl := r.opts.SourceDatabases[dbType]
+
+	r.println("Using direct MySQL connection to: ", mysqlUrlDescription(util.MysqlConn{MysqlUrl: mysqlUrl}))
+	r.println("Testing connection...")
+
+	conn, err := util.GetMysqlConnection(mysqlUrl)
+	if err != nil {
+		return util.MysqlConn{}, util.NewError(util.SourceError, "Failed to connect to remote database", err)
+	}
+	r.println("\tOK")
+
+	return util.MysqlConn{MysqlUrl: mysqlUrl, Conn: conn}, nil
+}
+
+func (r *restorer) validateDeskproSourceDump() (string, error) {
+	var (
+		dbDumpLocal string
+		err         error
+	)
+
+	dumpUri := r.opts.Dump
+
+	if dumpUri, err = filepath.Abs(dumpUri); err != nil {
+		return "", util.NewError(util.ConfigError, "Can't find a full path to dump, please check your --mysql-dump option carefully", err)
+	}
+
+	log.Info("--mysql-dump = ", dumpUri)
+
+	r.println("Using database dump from: ", dumpUri)
+
+	dbDumpLocal = filepath.Join(r.opts.TmpDir, "db.sql")
+	log.Info("save to", dbDumpLocal)
+
+	r.println("Downloading to temp file: ", dbDumpLocal)
+
+	r.temp.add(dbDumpLocal)
+	err = download(r.ctx, dbDumpLocal, dumpUri, getter.ClientModeFile)
+	if err != nil {
+		return "", util.NewError(util.SourceError, "Failed to download database dump", err)
+	}
+
+	r.println("\tOK")
+
+	return dbDumpLocal, nil
+}
+
+// validateAttachments will perform general attachments validation and will return attachUri string which indicates
+// where attachments are stored
+func (r *restorer) validateAttachments(conn *sql.DB) (string, bool, error) {
+	//------------------------------
+	// Attachments
+	//------------------------------
+
+	r.println("==========================================================================================")
+	r.println("Attachments")
+	r.println("==========================================================================================")
+
+	attachUri := r.opts.Attachments
+	tmpdir := r.opts.TmpDir
+	if len(attachUri) < 1 {
+		log.Info("no --attachments specified")
+		return "", false, util.NewError(util.ConfigError, "You must specify a path for attachments with --attachments. See --help for more information.", nil)
+	}
+
+	if attachUri == "none" {
+		r.println("none -- skipping attachments")
+		return "", false, nil
+	}
+
+	var err error
+
+	if attachUri, err = filepath.Abs(attachUri); err != nil {
+		return "", false, util.NewError(util.ConfigError, "Can't find a full path to attachments, please check your --attachments option carefully", err)
+	}
+
+	aUrl, err := url.Parse(attachUri)
+	if err != nil {
+		log.Info("--attachments contains wrong URI")
+		return "", false, util.NewError(util.ConfigError, "You must specify a correct path for attachments with --attachments. See --help for more information.", err)
+	}
+	moveAttachments := false
+	if aUrl.Scheme == "" || aUrl.Scheme == "file" {
+		attachUri = aUrl.Path
+		moveAttachments = r.opts.MoveAttachments
+	}
+
+	archive := r.opts.AttachmentsArchive
+
+	if !archive {
+		// try to detect archive from attachUri
+		// that was copy-pasted directly from go-getter
+		archive = detectArchive(attachUri, tmpdir)
+	}
+
+	if archive {
+		fakename := "attachments" + fmt.Sprintf("%d", time.Now().Unix())
+		r.temp.add(filepath.Join(tmpdir, fakename))
+		err := download(r.ctx, filepath.Join(tmpdir, fakename), attachUri, getter.ClientModeAny)
+		if err != nil {
+			return "", false, util.NewError(util.AttachmentError, "Trying to download attachments archive failed", err)
+		}
+		attachUri = filepath.Join(tmpdir, fakename)
+		// just to save space
+		// anyway we're going to download/copy archive in temp, so why keep files there?
+		moveAttachments = true
+	}
+
+	if _, err := os.Stat(filepath.Join(attachUri, "attachments")); !os.IsNotExist(err) {
+		attachUri = filepath.Join(attachUri, "attachments")
+	}
+
+	attachUri = r.transformAttachUri(attachUri)
+	log.Info("--attachments is ", attachUri)
+
+	// Verify a file just to validate
+	if conn != nil && attachUri != "none" {
+		res, err := conn.Query("SELECT save_path FROM blobs WHERE storage_loc = 'fs' ORDER BY id DESC LIMIT 1")
+		if err != nil {
+			return "", false, util.NewError(util.SourceError, "Trying to select an attachment record from the database failed", err)
+		}
+
+		var savePath string
+
+		if res.Next() {
+			if err := res.Scan(&savePath); err != nil {
+				_ = res.Close()
+				return "", false, util.NewError(util.SourceError, "Trying to select an attachment record from the database failed", err)
+			}
+		}
+
+		_ = res.Close()
+
+		// if there are no fs blobs, then there are no attachments
+		if len(savePath) < 1 {
+			log.Info("no fs blobs, attachUri = none")
+			r.println("We detected no filesystem attachments in the database, so there are no attachments to copy over.")
+			r.println("You can use --attachments=none to skip this step in future.")
+			attachUri = "none"
+
+			// try to download it
+		} else {
+			r.println("Testing attachments option...")
+
+			expectFile := strings.Replace(attachUri, "%PATH%", savePath, 1)
+			tmpFile := filepath.Join(tmpdir, "test_file_dl")
+
+			err := download(r.ctx, tmpFile, expectFile, getter.ClientModeFile)
+			_ = os.Remove(tmpFile)
+			if err != nil {
+				return "", false, util.NewError(util.AttachmentError, "Failed to download test file "+expectFile, err)
+			}
+
+			r.println("\tOK")
+		}
+	}
+
+	return attachUri, moveAttachments, nil
+}
+
+func (r *restorer) transformAttachUri(attachUri string) string {
+	r.println("Attachments will be loaded from: ", attachUri)
+
+	return transformAttachUri(attachUri)
+}
+
+func transformAttachUri(attachUri string) string {
+	// turns a path into a suitable uri with placeholder string
+	// e.g. C:\foo\bar?some_option=value -> C:/foo/bar/%PATH%?some_option
+	// so we can have a single string and get the path easily with a string replace
+
+	attachUri = strings.Replace(attachUri, "\\", "/", -1)
+
+	if !strings.Contains(attachUri, "%PATH%") {
+		re := regexp.MustCompile("/?(\\?.*)?$")
+		attachUri = re.ReplaceAllString(attachUri, "/%PATH%$1")
+	}
+
+	return attachUri
+}
+
+func detectArchive(uri string, tmpdir string) bool {
+	c := getter.Client{
+		Src:  uri,
+		Dst:  filepath.Join(tmpdir, "fake"),
+		Mode: getter.ClientModeAny,
+	}
+	c.Decompressors = getter.Decompressors
+
+	u, err := urlhelper.Parse(uri)
+	if err != nil {
+		return false
+	}
+	archive := ""
+	matchingLen := 0
+	for k := range c.Decompressors {
+		if strings.HasSuffix(u.Path, "."+k) && len(k) > matchingLen {
+			archive = k
+			matchingLen = len(k)
+		}
+	}
+
+	return archive != ""
+}
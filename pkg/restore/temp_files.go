@@ -0,0 +1,30 @@
+package restore
+
+import (
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// tempFiles are the files a restore downloads or extracts into the tmpdir, they are removed when the restore fails
+type tempFiles struct {
+	paths []string
+}
+
+func (t *tempFiles) add(path string) {
+	t.paths = append(t.paths, path)
+}
+
+// keep forgets the files added so far, so they stay after a failure
+func (t *tempFiles) keep() {
+	t.paths = nil
+}
+
+func (t *tempFiles) remove() {
+	for _, path := range t.paths {
+		if err := os.RemoveAll(path); err != nil {
+			log.Warning("Failed to remove temporary file ", path, ": ", err)
+		}
+	}
+	t.paths = nil
+}
@@ -0,0 +1,244 @@
+package restore
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alexmullins/zip"
+	"github.com/deskpro/dputils/util"
+	"github.com/hashicorp/go-getter"
+)
+
+// extractVolumes extracts an archive split with --volume-size into the dir. src is the first or any other volume
+// of the archive, the other volumes are listed by the manifest in the first volume and expected next to it. Their
+// checksums are checked, then the archive is read across the volumes without joining them. Remote volumes are
+// downloaded into the tmpdir first and removed once the archive is extracted.
+func (r *restorer) extractVolumes(src string, dir string) error {
+	archive, _, _ := util.VolumeArchive(src)
+	firstVolume := util.VolumeName(archive, 1)
+
+	firstPath, err := r.fetchVolume(firstVolume)
+	if err != nil {
+		return util.NewError(util.SourceError, "Failed to get the first volume of the backup archive "+firstVolume, err)
+	}
+	manifest, err := readVolumeManifest(firstPath)
+	if firstPath != firstVolume {
+		_ = os.Remove(firstPath)
+	}
+	if err != nil {
+		return util.NewError(util.SourceError, "Failed to read the manifest of the first volume", err)
+	}
+	if len(manifest.Volumes) == 0 {
+		return util.NewError(util.SourceError, firstVolume+" doesn't list the volumes of the backup archive", nil)
+	}
+
+	volumes := &volumeReader{}
+	defer volumes.Close()
+
+	r.printf("Reading %d volumes of the backup archive\n", len(manifest.Volumes)+1)
+	for i, volume := range manifest.Volumes {
+		r.println("\t" + volume.Name)
+		if err = r.openVolume(volumes, util.VolumeName(archive, i+2), volume); err != nil {
+			return err
+		}
+	}
+
+	reader, err := zip.NewReader(volumes, volumes.size)
+	if err != nil {
+		return util.NewError(util.SourceError, "The volumes of the backup archive aren't a zip archive", err)
+	}
+	if err = r.extractZip(reader, dir); err != nil {
+		return util.NewError(util.SourceError, "Failed to extract the volumes of the backup archive", err)
+	}
+
+	return nil
+}
+
+// readVolumeManifest reads the manifest of the first volume, which is a zip archive whatever its file name is
+func readVolumeManifest(path string) (*util.Manifest, error) {
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	manifest, err := util.ReadZipManifest(&reader.Reader)
+	if err == nil && manifest == nil {
+		err = fmt.Errorf("%s doesn't contain a %s", path, util.ManifestName)
+	}
+
+	return manifest, err
+}
+
+// openVolume adds the volume to the reader after checking its size and checksum
+func (r *restorer) openVolume(volumes *volumeReader, src string, volume util.ManifestVolume) error {
+	volumePath, err := r.fetchVolume(src)
+	if err != nil {
+		return util.NewError(util.SourceError, "Failed to get volume "+volume.Name, err)
+	}
+
+	f, err := os.Open(volumePath)
+	if err != nil {
+		return util.NewError(util.SourceError, "Failed to read volume "+volume.Name, err)
+	}
+	volumes.add(f, volume.Size, volumePath != src)
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return util.NewError(util.SourceError, "Failed to read volume "+volume.Name, err)
+	}
+	if size != volume.Size || fmt.Sprintf("%x", hash.Sum(nil)) != volume.Sha256 {
+		return util.NewError(util.SourceError, "Volume "+volume.Name+" is incomplete or corrupted", nil)
+	}
+
+	return nil
+}
+
+// fetchVolume returns the path of a local volume as it is, remote volumes are downloaded into the tmpdir
+func (r *restorer) fetchVolume(src string) (string, error) {
+	if _, err := os.Stat(src); err == nil {
+		return src, nil
+	}
+
+	// a volume isn't a complete zip, go-getter must not try to extract it
+	separator := "?"
+	if strings.Contains(src, "?") {
+		separator = "&"
+	}
+	dst := filepath.Join(r.opts.TmpDir, fmt.Sprintf("backup_volume%d_%s", time.Now().UnixNano(), util.VolumeBaseName(src)))
+	r.temp.add(dst)
+	if err := download(r.ctx, dst, src+separator+"archive=false", getter.ClientModeFile); err != nil {
+		return "", err
+	}
+
+	return dst, nil
+}
+
+// extractZip writes every entry of the archive into dir, entries which would be written outside of it fail
+func (r *restorer) extractZip(reader *zip.Reader, dir string) error {
+	dir = filepath.Clean(dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for _, f := range reader.File {
+		if err := r.ctx.Err(); err != nil {
+			return err
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(f.Name))
+		if !strings.HasPrefix(target, dir+string(filepath.Separator)) {
+			return fmt.Errorf("the entry %s is outside of the archive", f.Name)
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := extractZipFile(f, target); err != nil {
+			return fmt.Errorf("can't extract %s: %s", f.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func extractZipFile(f *zip.File, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	in, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	mode := f.Mode().Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err = io.Copy(out, in); err != nil {
+		return err
+	}
+
+	return out.Close()
+}
+
+// volumeReader reads the volumes of an archive as if they were joined, so the zip can be read without
+// concatenating them on disk
+type volumeReader struct {
+	files   []*os.File
+	offsets []int64
+	sizes   []int64
+	// downloaded volumes are removed when the reader is closed
+	downloaded []bool
+	size       int64
+}
+
+func (v *volumeReader) add(f *os.File, size int64, downloaded bool) {
+	v.files = append(v.files, f)
+	v.offsets = append(v.offsets, v.size)
+	v.sizes = append(v.sizes, size)
+	v.downloaded = append(v.downloaded, downloaded)
+	v.size += size
+}
+
+func (v *volumeReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+
+	total := 0
+	for len(p) > 0 && off < v.size {
+		// the last volume which starts at or before the offset
+		i := sort.Search(len(v.offsets), func(i int) bool { return v.offsets[i] > off }) - 1
+		chunk := p
+		if rest := v.offsets[i] + v.sizes[i] - off; int64(len(chunk)) > rest {
+			chunk = chunk[:rest]
+		}
+
+		n, err := v.files[i].ReadAt(chunk, off-v.offsets[i])
+		total += n
+		off += int64(n)
+		p = p[n:]
+		if n < len(chunk) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return total, err
+		}
+	}
+	if len(p) > 0 {
+		return total, io.EOF
+	}
+
+	return total, nil
+}
+
+// Close closes the volumes and removes the downloaded ones
+func (v *volumeReader) Close() error {
+	for i, f := range v.files {
+		_ = f.Close()
+		if v.downloaded[i] {
+			_ = os.Remove(f.Name())
+		}
+	}
+	v.files = nil
+
+	return nil
+}
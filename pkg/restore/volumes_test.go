@@ -0,0 +1,156 @@
+package restore
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alexmullins/zip"
+	"github.com/deskpro/dputils/util"
+)
+
+// splitVolumes splits the archive into volumes of size bytes the same way the backup does and returns the first
+// volume
+func splitVolumes(t *testing.T, archivePath string, dir string, size int) string {
+	data, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(dir, "deskpro-backup.zip")
+	manifest := util.NewManifest("v0.1", "", false)
+	for i := 0; i*size < len(data); i++ {
+		chunk := data[i*size:]
+		if len(chunk) > size {
+			chunk = chunk[:size]
+		}
+		name := util.VolumeName(archive, i+2)
+		_ = os.WriteFile(name, chunk, 0644)
+		manifest.Volumes = append(manifest.Volumes, util.ManifestVolume{
+			Name:   filepath.Base(name),
+			Size:   int64(len(chunk)),
+			Sha256: fmt.Sprintf("%x", sha256.Sum256(chunk)),
+		})
+	}
+
+	f, _ := os.Create(util.VolumeName(archive, 1))
+	w := zip.NewWriter(f)
+	_ = manifest.Save(w)
+	_ = w.Close()
+	_ = f.Close()
+
+	return util.VolumeName(archive, 1)
+}
+
+func Test_checkFullBackupVolumes(t *testing.T) {
+	volumesDir := t.TempDir()
+	firstVolume := splitVolumes(t, filepath.Join("..", "..", "test_mocks", "backup.zip"), volumesDir, 200)
+	archive := filepath.Join(volumesDir, "deskpro-backup.zip")
+
+	// the restore can start from the first or any other volume
+	for _, src := range []string{firstVolume, util.VolumeName(archive, 3)} {
+		r := newTestRestorer(Options{FullBackup: src, TmpDir: t.TempDir()})
+		fullBackup, backupDir, err := r.checkFullBackup()
+		if err != nil || !fullBackup {
+			t.Fatalf("Backup checking failed: %v", err)
+		}
+		if _, err = os.Stat(filepath.Join(backupDir, "attachments")); err != nil {
+			t.Error("The archive wasn't extracted from the volumes")
+		}
+		if files, _ := os.ReadDir(r.opts.TmpDir); len(files) != 1 {
+			t.Errorf("Expected only the extracted archive in the tmpdir, found %d files", len(files))
+		}
+	}
+
+	// a corrupted volume is detected
+	_ = os.WriteFile(util.VolumeName(archive, 3), []byte("broken"), 0644)
+	r := newTestRestorer(Options{FullBackup: firstVolume, TmpDir: t.TempDir()})
+	if _, _, err := r.checkFullBackup(); util.ErrorKindOf(err) != util.SourceError {
+		t.Errorf("Expected a source error for a corrupted volume, got %v", err)
+	}
+}
+
+func Test_checkFullBackupRemoteVolumes(t *testing.T) {
+	volumesDir := t.TempDir()
+	firstVolume := splitVolumes(t, filepath.Join("..", "..", "test_mocks", "backup.zip"), volumesDir, 200)
+	server := httptest.NewServer(http.FileServer(http.Dir(volumesDir)))
+	defer server.Close()
+
+	r := newTestRestorer(Options{FullBackup: server.URL + "/" + filepath.Base(firstVolume), TmpDir: t.TempDir()})
+	fullBackup, backupDir, err := r.checkFullBackup()
+	if err != nil || !fullBackup {
+		t.Fatalf("Backup checking failed: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(backupDir, "attachments")); err != nil {
+		t.Error("The archive wasn't extracted from the downloaded volumes")
+	}
+	// the downloaded volumes are removed once the archive is extracted
+	if files, _ := os.ReadDir(r.opts.TmpDir); len(files) != 1 {
+		t.Errorf("Expected only the extracted archive in the tmpdir, found %d files", len(files))
+	}
+}
+
+func Test_volumeReader(t *testing.T) {
+	dir := t.TempDir()
+	volumes := &volumeReader{}
+	defer volumes.Close()
+	for i, content := range []string{"0123", "4567", "89"} {
+		name := filepath.Join(dir, fmt.Sprintf("volume%d", i))
+		_ = os.WriteFile(name, []byte(content), 0644)
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		volumes.add(f, int64(len(content)), false)
+	}
+
+	tests := []struct {
+		off      int64
+		length   int
+		expected string
+		eof      bool
+	}{
+		{0, 4, "0123", false},
+		{2, 7, "2345678", false},
+		{6, 4, "6789", false},
+		{8, 4, "89", true},
+		{10, 1, "", true},
+	}
+	for _, test := range tests {
+		p := make([]byte, test.length)
+		n, err := volumes.ReadAt(p, test.off)
+		if string(p[:n]) != test.expected || (err == io.EOF) != test.eof || (err != nil && err != io.EOF) {
+			t.Errorf("ReadAt(%d, %d): expected %q, got %q (%v)", test.length, test.off, test.expected, p[:n], err)
+		}
+	}
+}
+
+func Test_extractZipOutside(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "evil.zip")
+	f, _ := os.Create(archive)
+	w := zip.NewWriter(f)
+	entry, _ := w.Create("../evil.txt")
+	_, _ = entry.Write([]byte("evil"))
+	_ = w.Close()
+	_ = f.Close()
+
+	reader, err := zip.OpenReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	dir := filepath.Join(t.TempDir(), "extracted")
+	r := newTestRestorer(Options{TmpDir: t.TempDir()})
+	if err = r.extractZip(&reader.Reader, dir); err == nil {
+		t.Error("An entry outside of the dir was extracted")
+	}
+	if _, err = os.Stat(filepath.Join(filepath.Dir(dir), "evil.txt")); !os.IsNotExist(err) {
+		t.Error("The entry was written outside of the dir")
+	}
+}
@@ -0,0 +1,266 @@
+// Package schedule runs backups on cron style schedules and rotates the old archives of the backup target. It's
+// what the 'dputils schedule' command runs.
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/deskpro/dputils/pkg/backup"
+	"github.com/deskpro/dputils/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// Job is a backup run on a schedule
+type Job struct {
+	// Name tells the jobs apart in the output, e.g. "full" or "database"
+	Name     string
+	Schedule *util.CronSchedule
+	// Backup are the options of the backup, its Target must be a dir. FilePrefix should be different for every
+	// job, so the retention is applied to every kind of backup separately.
+	Backup backup.Options
+}
+
+// Options of the scheduler
+type Options struct {
+	Jobs []Job
+	// Retention is applied to the archives of a job after every successful backup
+	Retention util.RetentionPolicy
+	// LockPath is the lock file held while a backup runs, a backup is skipped if the lock is held by another
+	// process. Defaults to dputils_backup.lock in the system temp dir.
+	LockPath string
+
+	// Out receives the human readable progress, it's discarded if nil
+	Out io.Writer
+}
+
+type scheduler struct {
+	opts Options
+	out  io.Writer
+}
+
+// Run runs the jobs on their schedules until the context is canceled. A failed backup doesn't stop the scheduler,
+// it's run again on its next schedule. Run returns nil when it's canceled between backups and a
+// util.CanceledError when a running backup was interrupted.
+func Run(ctx context.Context, opts Options) error {
+	s := &scheduler{opts: opts, out: opts.Out}
+	if s.out == nil {
+		s.out = ioutil.Discard
+	}
+	if s.opts.LockPath == "" {
+		s.opts.LockPath = filepath.Join(os.TempDir(), "dputils_backup.lock")
+	}
+
+	if err := s.validate(); err != nil {
+		return err
+	}
+
+	for {
+		next, due := s.nextRun(time.Now())
+		if len(due) == 0 {
+			return util.NewError(util.ConfigError, "The schedules never match a date", nil)
+		}
+		fmt.Fprintf(s.out, "Next %s backup at %s\n", jobNames(due), next.Format(time.RFC1123))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		for _, job := range due {
+			err := s.runJob(ctx, job)
+			if ctx.Err() != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *scheduler) validate() error {
+	if len(s.opts.Jobs) == 0 {
+		return util.NewError(util.ConfigError, "Nothing to schedule, specify the --full or the --database schedule", nil)
+	}
+
+	for _, job := range s.opts.Jobs {
+		target := job.Backup.Target
+		if target == "" || target == "public" || strings.EqualFold(filepath.Ext(target), ".zip") {
+			return util.NewError(util.ConfigError, "The target of scheduled backups must be a dir, so every backup gets its own archive", nil)
+		}
+		if !util.IsRemoteTarget(target) {
+			if info, err := os.Stat(target); err != nil || !info.IsDir() {
+				return util.NewError(util.ConfigError, "Can't find the target dir "+target, err)
+			}
+		}
+
+		if !s.opts.Retention.IsZero() {
+			// the dir is listed once, so a target which can't be rotated fails before the first backup
+			if _, err := listArchives(target, filePrefix(job)); err != nil {
+				return util.NewError(util.ConfigError, "Can't list the archives of the target to apply the retention", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// nextRun returns the time of the next run and the jobs scheduled at that time
+func (s *scheduler) nextRun(now time.Time) (time.Time, []Job) {
+	var (
+		next time.Time
+		due  []Job
+	)
+
+	for _, job := range s.opts.Jobs {
+		at := job.Schedule.Next(now)
+		if at.IsZero() {
+			continue
+		}
+		if next.IsZero() || at.Before(next) {
+			next, due = at, nil
+		}
+		if at.Equal(next) {
+			due = append(due, job)
+		}
+	}
+
+	return next, due
+}
+
+// runJob runs the backup unless another backup holds the lock, then applies the retention to the target
+func (s *scheduler) runJob(ctx context.Context, job Job) error {
+	fmt.Fprintln(s.out, "==========================================================================================")
+	fmt.Fprintln(s.out, "Scheduled "+job.Name+" backup, "+time.Now().Format(time.RFC1123))
+	fmt.Fprintln(s.out, "==========================================================================================")
+
+	lock, err := util.AcquireLock(s.opts.LockPath)
+	if err != nil {
+		log.Warning("Skipped scheduled backup ", err)
+		fmt.Fprintln(s.out, "Another backup is still running, skipping this one")
+		fmt.Fprintln(s.out, err)
+		return nil
+	}
+	defer func() {
+		if err := lock.Release(); err != nil {
+			log.Warning("Failed to remove lock file ", err)
+		}
+	}()
+
+	result, err := backup.Run(ctx, job.Backup)
+	if err != nil {
+		log.Error("Scheduled backup failed ", err)
+		fmt.Fprintln(s.out, "The "+job.Name+" backup failed, it runs again on its next schedule")
+		fmt.Fprintln(s.out, err)
+		return err
+	}
+	fmt.Fprintln(s.out, "Backup saved to "+result.Location)
+
+	if !s.opts.Retention.IsZero() {
+		s.rotate(job)
+	}
+
+	return nil
+}
+
+// rotate removes the archives of the job which aren't kept by the retention policy
+func (s *scheduler) rotate(job Job) {
+	removed, err := Rotate(job.Backup.Target, filePrefix(job), s.opts.Retention)
+	for _, name := range removed {
+		fmt.Fprintln(s.out, "\tRemoved old backup "+name)
+	}
+	if err != nil {
+		log.Warning("Failed to remove old backups ", err)
+		fmt.Fprintln(s.out, "Failed to remove old backups")
+		fmt.Fprintln(s.out, err)
+	}
+}
+
+// Rotate removes the archives named with the prefix from the target dir which aren't kept by the policy, and
+// returns their names
+func Rotate(target string, prefix string, policy util.RetentionPolicy) ([]string, error) {
+	dir, err := util.OpenTargetDir(target)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	archives, err := listTargetDir(dir, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, archive := range util.ExpiredArchives(archives, policy) {
+		for _, name := range archive.Files {
+			if err = dir.Remove(name); err != nil {
+				return removed, err
+			}
+			removed = append(removed, name)
+		}
+	}
+
+	return removed, nil
+}
+
+func listArchives(target string, prefix string) ([]util.BackupArchive, error) {
+	dir, err := util.OpenTargetDir(target)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	return listTargetDir(dir, prefix)
+}
+
+func listTargetDir(dir util.TargetDir, prefix string) ([]util.BackupArchive, error) {
+	names, err := dir.List()
+	if err != nil {
+		return nil, err
+	}
+
+	// the volumes of an archive are kept or removed together
+	var (
+		archives []util.BackupArchive
+		index    = map[string]int{}
+	)
+	for _, name := range names {
+		created, ok := util.ParseBackupArchiveName(prefix, name)
+		if !ok {
+			continue
+		}
+		archive := util.BackupArchiveName(prefix, created)
+		if i, ok := index[archive]; ok {
+			archives[i].Files = append(archives[i].Files, name)
+			continue
+		}
+		index[archive] = len(archives)
+		archives = append(archives, util.BackupArchive{Name: archive, Created: created, Files: []string{name}})
+	}
+
+	return archives, nil
+}
+
+func filePrefix(job Job) string {
+	if job.Backup.FilePrefix == "" {
+		return util.BackupArchivePrefix
+	}
+
+	return job.Backup.FilePrefix
+}
+
+func jobNames(jobs []Job) string {
+	var names []string
+	for _, job := range jobs {
+		names = append(names, job.Name)
+	}
+
+	return strings.Join(names, " and ")
+}
@@ -0,0 +1,89 @@
+package schedule
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deskpro/dputils/pkg/backup"
+	"github.com/deskpro/dputils/util"
+)
+
+func TestRotate(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for day := 0; day < 5; day++ {
+		created := now.AddDate(0, 0, -day)
+		_ = os.WriteFile(filepath.Join(dir, util.BackupArchiveName(util.BackupArchivePrefix, created)), nil, 0644)
+		_ = os.WriteFile(filepath.Join(dir, util.BackupArchiveName("deskpro-backup-database", created)), nil, 0644)
+	}
+	_ = os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0644)
+
+	removed, err := Rotate(dir, util.BackupArchivePrefix, util.RetentionPolicy{Daily: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 3 || removed[0] != util.BackupArchiveName(util.BackupArchivePrefix, now.AddDate(0, 0, -4)) {
+		t.Errorf("Unexpected removed archives %v", removed)
+	}
+
+	files, _ := os.ReadDir(dir)
+	if len(files) != 8 {
+		t.Errorf("Expected 2 full backups, 5 database backups and the notes, got %d files", len(files))
+	}
+}
+
+func TestNextRun(t *testing.T) {
+	daily, _ := util.ParseCronSchedule("0 2 * * *")
+	hourly, _ := util.ParseCronSchedule("@hourly")
+	s := &scheduler{opts: Options{Jobs: []Job{{Name: "full", Schedule: daily}, {Name: "database", Schedule: hourly}}}}
+
+	next, due := s.nextRun(time.Date(2021, 2, 1, 1, 30, 0, 0, time.UTC))
+	if !next.Equal(time.Date(2021, 2, 1, 2, 0, 0, 0, time.UTC)) || jobNames(due) != "full and database" {
+		t.Errorf("Unexpected next run of %s at %s", jobNames(due), next)
+	}
+
+	next, due = s.nextRun(next)
+	if next.Hour() != 3 || jobNames(due) != "database" {
+		t.Errorf("Unexpected next run of %s at %s", jobNames(due), next)
+	}
+}
+
+func TestRunJobLocked(t *testing.T) {
+	out := &bytes.Buffer{}
+	lockPath := filepath.Join(t.TempDir(), "backup.lock")
+	lock, err := util.AcquireLock(lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release()
+
+	if _, err = util.AcquireLock(lockPath); err == nil {
+		t.Error("The lock was acquired twice")
+	}
+
+	s := &scheduler{opts: Options{LockPath: lockPath}, out: out}
+	if err = s.runJob(context.Background(), Job{Name: "full", Backup: backup.Options{}}); err != nil {
+		t.Errorf("Locked backup wasn't skipped: %s", err)
+	}
+	if !strings.Contains(out.String(), "Another backup is still running") {
+		t.Errorf("Unexpected output %s", out.String())
+	}
+}
+
+func TestRunValidatesOptions(t *testing.T) {
+	schedule, _ := util.ParseCronSchedule("@daily")
+
+	if err := Run(context.Background(), Options{}); util.ErrorKindOf(err) != util.ConfigError {
+		t.Errorf("Expected a config error without jobs, got %v", err)
+	}
+
+	err := Run(context.Background(), Options{Jobs: []Job{{Name: "full", Schedule: schedule, Backup: backup.Options{Target: "backup.zip"}}}})
+	if util.ErrorKindOf(err) != util.ConfigError {
+		t.Errorf("Expected a config error for a zip target, got %v", err)
+	}
+}
@@ -0,0 +1,284 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BinlogTimeLayout is the format of the --until time and of mysqlbinlog --stop-datetime
+const BinlogTimeLayout = "2006-01-02 15:04:05"
+
+var (
+	binlogNameRe = regexp.MustCompile(`^(.+)\.(\d+)$`)
+	gtidRe       = regexp.MustCompile(`^([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}):(\d+)$`)
+	// the statement mysqlbinlog writes before every transaction of a server with GTIDs enabled
+	gtidNextRe = regexp.MustCompile(`^SET @@SESSION\.GTID_NEXT\s*=\s*'([^']*)'`)
+)
+
+// BinlogStop is where a replay of binary logs stops: before the first event logged at or after Time, or right
+// after the transaction with the GTID, e.g. "3e11fa47-71ca-11e1-9e33-c80aa9429562:23"
+type BinlogStop struct {
+	Time time.Time
+	Gtid string
+}
+
+// ParseBinlogStop parses a local time in the BinlogTimeLayout or a single GTID
+func ParseBinlogStop(value string) (BinlogStop, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if gtidRe.MatchString(value) {
+		return BinlogStop{Gtid: value}, nil
+	}
+
+	t, err := time.ParseInLocation(BinlogTimeLayout, value, time.Local)
+	if err != nil {
+		return BinlogStop{}, fmt.Errorf("%q is neither a time like \"2026-10-18 09:30:00\" nor a GTID like \"3e11fa47-71ca-11e1-9e33-c80aa9429562:23\"", value)
+	}
+
+	return BinlogStop{Time: t}, nil
+}
+
+func (s BinlogStop) IsZero() bool {
+	return s.Time.IsZero() && s.Gtid == ""
+}
+
+func (s BinlogStop) String() string {
+	if s.Gtid != "" {
+		return "GTID " + s.Gtid
+	}
+
+	return s.Time.Format(BinlogTimeLayout)
+}
+
+// BinlogSequence splits a binary log name like "mysql-bin.000123" into its base name and sequence number
+func BinlogSequence(name string) (string, int, bool) {
+	match := binlogNameRe.FindStringSubmatch(name)
+	if match == nil {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(match[2])
+	if err != nil {
+		return "", 0, false
+	}
+
+	return match[1], n, true
+}
+
+// ListBinaryLogs returns the names of the binary logs of the server, oldest first. The last one is the log the
+// server is writing to.
+func ListBinaryLogs(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SHOW BINARY LOGS")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// the columns depend on the server version, the name is always the first one
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	var names []string
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		names = append(names, string(values[0]))
+	}
+
+	return names, rows.Err()
+}
+
+// mysqlbinlogBinary returns the mysqlbinlog binary installed next to the mysql binary configured in Deskpro
+// (paths.mysql_path), or the one in the PATH
+func mysqlbinlogBinary(dpConfig map[string]string) (string, error) {
+	bin := "mysqlbinlog"
+	if mysqlBin := dpConfig["paths.mysql_path"]; mysqlBin != "" {
+		sibling := filepath.Join(filepath.Dir(mysqlBin), "mysqlbinlog"+filepath.Ext(mysqlBin))
+		if _, err := exec.LookPath(sibling); err == nil {
+			bin = sibling
+		}
+	}
+
+	if _, err := exec.LookPath(bin); err != nil {
+		return "", fmt.Errorf("mysqlbinlog can't be executed: %s. Install the MySQL client, binary logs are read and replayed with mysqlbinlog", err)
+	}
+
+	return bin, nil
+}
+
+// FetchBinaryLog copies the binary log from the server at murl into dir with mysqlbinlog and returns its path.
+// Reading binary logs requires the REPLICATION SLAVE privilege.
+func FetchBinaryLog(ctx context.Context, dpConfig map[string]string, murl url.URL, name string, dir string) (string, error) {
+	bin, err := mysqlbinlogBinary(dpConfig)
+	if err != nil {
+		return "", err
+	}
+
+	args := append(mysqlConnectionArgs(murl), "--read-from-remote-server", "--raw", "--result-file="+dir+string(filepath.Separator), name)
+
+	var output bytes.Buffer
+	fetchCmd := exec.CommandContext(ctx, bin, args...)
+	fetchCmd.Stdout = &output
+	fetchCmd.Stderr = &output
+
+	path := filepath.Join(dir, name)
+	if err = fetchCmd.Run(); err != nil {
+		_ = os.Remove(path)
+		return "", fmt.Errorf("%s\nError output for mysqlbinlog:\n%s", err, output.String())
+	}
+
+	return path, nil
+}
+
+// BinlogReplay are the events of archived binary logs to replay
+type BinlogReplay struct {
+	// Files are local copies of consecutive binary logs, the replay starts at Start.Position of the first one
+	Files []string
+	Start BinlogCoordinates
+	Stop  BinlogStop
+	// Database is the name of the database the events were logged for, they're replayed into the database at the
+	// URL given to ReplayBinaryLogs
+	Database string
+}
+
+// ReplayBinaryLogs executes the events of the binary logs on the database at murl with mysqlbinlog and the mysql
+// binary configured in Deskpro. The events of other databases are skipped and the GTIDs of the original server
+// aren't kept, the server assigns new ones. It returns false if the replay stops at a GTID the logs end before.
+func ReplayBinaryLogs(ctx context.Context, dpConfig map[string]string, murl url.URL, replay BinlogReplay) (bool, error) {
+	bin, err := mysqlbinlogBinary(dpConfig)
+	if err != nil {
+		return false, err
+	}
+
+	dbName := strings.TrimLeft(murl.Path, "/")
+	args := []string{"--start-position=" + strconv.FormatInt(replay.Start.Position, 10), "--database=" + dbName}
+	if replay.Database != "" && replay.Database != dbName {
+		args = append(args, "--rewrite-db="+replay.Database+"->"+dbName)
+	}
+	if !replay.Stop.Time.IsZero() {
+		args = append(args, "--stop-datetime="+replay.Stop.Time.Format(BinlogTimeLayout))
+	}
+	args = append(args, replay.Files...)
+
+	// mysqlbinlog is killed once the replay stopped at the GTID
+	replayCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var errBuff bytes.Buffer
+	replayCmd := exec.CommandContext(replayCtx, bin, args...)
+	replayCmd.Stderr = &errBuff
+	stdout, err := replayCmd.StdoutPipe()
+	if err != nil {
+		return false, err
+	}
+	if err = replayCmd.Start(); err != nil {
+		return false, err
+	}
+
+	filter := &binlogFilter{stopGtid: replay.Stop.Gtid, done: make(chan struct{})}
+	events := filter.reader(stdout)
+	importErr := ImportDatabase(ctx, dpConfig, murl, nil, DumpOptions{Engine: EngineMysqldump}, events)
+	_ = events.Close()
+	if importErr != nil {
+		cancel()
+	}
+	<-filter.done
+	if filter.stopped {
+		cancel()
+	}
+	waitErr := replayCmd.Wait()
+
+	if importErr != nil {
+		return false, importErr
+	}
+	if waitErr != nil && !filter.stopped {
+		return false, fmt.Errorf("%s\nError output for mysqlbinlog:\n%s", waitErr, errBuff.String())
+	}
+
+	return replay.Stop.Gtid == "" || filter.reached, nil
+}
+
+// binlogFilter removes the GTIDs from the output of mysqlbinlog and stops it after the transaction with stopGtid
+type binlogFilter struct {
+	stopGtid string
+	// reached is set once the transaction with stopGtid is seen, stopped when the output was cut after it
+	reached bool
+	stopped bool
+	done    chan struct{}
+}
+
+// reader returns the filtered output, closing it stops the filter
+func (f *binlogFilter) reader(r io.Reader) io.ReadCloser {
+	reader, writer := io.Pipe()
+	go func() {
+		_ = writer.CloseWithError(f.filter(r, writer))
+		close(f.done)
+	}()
+
+	return reader
+}
+
+func (f *binlogFilter) filter(r io.Reader, w io.Writer) error {
+	var (
+		in        = bufio.NewReaderSize(r, 64*1024)
+		out       = bufio.NewWriterSize(w, 64*1024)
+		lineStart = true
+		stopUuid  string
+		stopN     int
+	)
+	if match := gtidRe.FindStringSubmatch(f.stopGtid); match != nil {
+		stopUuid = match[1]
+		stopN, _ = strconv.Atoi(match[2])
+	}
+
+	for {
+		chunk, err := in.ReadSlice('\n')
+		if err != nil && err != bufio.ErrBufferFull && err != io.EOF {
+			return err
+		}
+
+		skip := false
+		if lineStart && bytes.HasPrefix(chunk, []byte("SET @@SESSION.GTID_NEXT")) {
+			skip = true
+			if match := gtidNextRe.FindSubmatch(chunk); match != nil && stopUuid != "" {
+				gtid := gtidRe.FindStringSubmatch(strings.ToLower(string(match[1])))
+				if gtid != nil && gtid[1] == stopUuid {
+					n, _ := strconv.Atoi(gtid[2])
+					if n > stopN {
+						f.stopped = true
+						return out.Flush()
+					}
+					f.reached = f.reached || n == stopN
+				}
+			}
+		}
+
+		if !skip {
+			if _, writeErr := out.Write(chunk); writeErr != nil {
+				return writeErr
+			}
+		}
+		if err == io.EOF {
+			return out.Flush()
+		}
+		lineStart = err == nil
+	}
+}
@@ -0,0 +1,98 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestParseBinlogStop(t *testing.T) {
+	stop, err := ParseBinlogStop("2026-10-18 09:30:00")
+	if err != nil || stop.Time.Hour() != 9 || stop.Time.Minute() != 30 || stop.Gtid != "" {
+		t.Errorf("Unexpected stop %v (%v)", stop, err)
+	}
+
+	stop, err = ParseBinlogStop("3E11FA47-71CA-11E1-9E33-C80AA9429562:23")
+	if err != nil || stop.Gtid != "3e11fa47-71ca-11e1-9e33-c80aa9429562:23" || !stop.Time.IsZero() {
+		t.Errorf("Unexpected stop %v (%v)", stop, err)
+	}
+
+	for _, value := range []string{"", "yesterday", "2026-10-18", "3e11fa47:23"} {
+		if _, err = ParseBinlogStop(value); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
+
+func TestBinlogSequence(t *testing.T) {
+	if base, n, ok := BinlogSequence("mysql-bin.000123"); !ok || base != "mysql-bin" || n != 123 {
+		t.Errorf("Unexpected sequence %s %d", base, n)
+	}
+	if _, _, ok := BinlogSequence("mysql-bin.index"); ok {
+		t.Error("The index was parsed as a binary log")
+	}
+}
+
+func TestListBinaryLogs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SHOW BINARY LOGS").WillReturnRows(
+		sqlmock.NewRows([]string{"Log_name", "File_size", "Encrypted"}).
+			AddRow("mysql-bin.000001", 1024, "No").
+			AddRow("mysql-bin.000002", 157, "No"),
+	)
+
+	logs, err := ListBinaryLogs(context.Background(), db)
+	if err != nil || strings.Join(logs, ",") != "mysql-bin.000001,mysql-bin.000002" {
+		t.Errorf("Unexpected logs %v (%v)", logs, err)
+	}
+}
+
+func TestBinlogFilter(t *testing.T) {
+	events := `DELIMITER /*!*/;
+SET @@SESSION.GTID_NEXT= '3e11fa47-71ca-11e1-9e33-c80aa9429562:22'/*!*/;
+INSERT INTO tickets VALUES (22)
+/*!*/;
+COMMIT/*!*/;
+SET @@SESSION.GTID_NEXT= '3e11fa47-71ca-11e1-9e33-c80aa9429562:23'/*!*/;
+INSERT INTO tickets VALUES (23)
+/*!*/;
+COMMIT/*!*/;
+SET @@SESSION.GTID_NEXT= '3e11fa47-71ca-11e1-9e33-c80aa9429562:24'/*!*/;
+INSERT INTO tickets VALUES (24)
+/*!*/;
+COMMIT/*!*/;
+SET @@SESSION.GTID_NEXT= 'AUTOMATIC' /* added by mysqlbinlog */ /*!*/;
+`
+
+	var out bytes.Buffer
+	filter := &binlogFilter{stopGtid: "3e11fa47-71ca-11e1-9e33-c80aa9429562:23"}
+	if err := filter.filter(strings.NewReader(events), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !filter.reached || !filter.stopped {
+		t.Error("The replay didn't stop after the GTID")
+	}
+	if !strings.Contains(out.String(), "VALUES (23)") || strings.Contains(out.String(), "VALUES (24)") {
+		t.Errorf("Unexpected events:\n%s", out.String())
+	}
+	if strings.Contains(out.String(), "GTID_NEXT") {
+		t.Error("GTIDs weren't removed")
+	}
+
+	out.Reset()
+	filter = &binlogFilter{}
+	if err := filter.filter(strings.NewReader(events), &out); err != nil {
+		t.Fatal(err)
+	}
+	if filter.stopped || !strings.Contains(out.String(), "VALUES (24)") || strings.Contains(out.String(), "GTID_NEXT") {
+		t.Errorf("Unexpected events:\n%s", out.String())
+	}
+}
@@ -0,0 +1,356 @@
+package util
+
+import (
+	"bytes"
+	"crypto/md5"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Blob is a row of the Deskpro blobs table
+type Blob struct {
+	Id       int64
+	SavePath string
+	Hash     string
+}
+
+// BlobStore reads and writes blob content in one of the storages Deskpro supports. The storage_loc column of
+// the blobs table tells which store has the content of a blob.
+//
+// The transaction is the one which updates the blob row, stores which keep the content in the database must use it.
+type BlobStore interface {
+	// Location is the storage_loc value of the store
+	Location() string
+	Get(tx *sql.Tx, blob Blob) ([]byte, error)
+	// Put saves the content and returns the save_path of the blob in this store
+	Put(tx *sql.Tx, blob Blob, data []byte) (string, error)
+	Delete(tx *sql.Tx, blob Blob) error
+}
+
+// blobSavePath keeps the path of a blob when it's moved between stores, so it can be moved back to the same place.
+// Blobs which never were in the filesystem get a path derived from their id.
+func blobSavePath(blob Blob) string {
+	if blob.SavePath != "" {
+		return blob.SavePath
+	}
+
+	return fmt.Sprintf("migrated/%d/%d", blob.Id/1000, blob.Id)
+}
+
+// FsBlobStore keeps blobs in the attachments dir, save_path is relative to it
+type FsBlobStore struct {
+	Dir string
+}
+
+func (s *FsBlobStore) Location() string {
+	return "fs"
+}
+
+func (s *FsBlobStore) Get(_ *sql.Tx, blob Blob) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(s.Dir, filepath.FromSlash(blob.SavePath)))
+}
+
+func (s *FsBlobStore) Put(_ *sql.Tx, blob Blob, data []byte) (string, error) {
+	savePath := blobSavePath(blob)
+	target := filepath.Join(s.Dir, filepath.FromSlash(savePath))
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return "", err
+	}
+
+	return savePath, ioutil.WriteFile(target, data, 0644)
+}
+
+func (s *FsBlobStore) Delete(_ *sql.Tx, blob Blob) error {
+	err := os.Remove(filepath.Join(s.Dir, filepath.FromSlash(blob.SavePath)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// DbBlobStore keeps blob content in the blobs_storage table of the Deskpro database
+type DbBlobStore struct{}
+
+func (s *DbBlobStore) Location() string {
+	return "db"
+}
+
+func (s *DbBlobStore) Get(tx *sql.Tx, blob Blob) ([]byte, error) {
+	var data []byte
+	err := tx.QueryRow("SELECT data FROM blobs_storage WHERE blob_id = ?", blob.Id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("blob %d has no content in blobs_storage", blob.Id)
+	}
+
+	return data, err
+}
+
+func (s *DbBlobStore) Put(tx *sql.Tx, blob Blob, data []byte) (string, error) {
+	// content left by an interrupted migration is replaced
+	if _, err := tx.Exec("DELETE FROM blobs_storage WHERE blob_id = ?", blob.Id); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec("INSERT INTO blobs_storage (blob_id, data) VALUES (?, ?)", blob.Id, data); err != nil {
+		return "", err
+	}
+
+	return blobSavePath(blob), nil
+}
+
+func (s *DbBlobStore) Delete(tx *sql.Tx, blob Blob) error {
+	_, err := tx.Exec("DELETE FROM blobs_storage WHERE blob_id = ?", blob.Id)
+	return err
+}
+
+// S3BlobStore keeps blobs in an S3 compatible bucket, save_path is the object key relative to the prefix
+type S3BlobStore struct {
+	client *s3.S3
+	bucket string
+	prefix string
+}
+
+// NewS3BlobStore connects to the bucket of an s3://bucket/prefix URL, which supports the same query parameters
+// as backup targets (region, endpoint, path_style and credentials)
+func NewS3BlobStore(target string) (*S3BlobStore, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return nil, errors.New("s3 storage must be in the form of s3://bucket/prefix")
+	}
+
+	sess, err := newS3Session(u)
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3BlobStore{
+		client: s3.New(sess),
+		bucket: u.Host,
+		prefix: strings.Trim(u.Path, "/"),
+	}, nil
+}
+
+func (s *S3BlobStore) Location() string {
+	return "s3"
+}
+
+func (s *S3BlobStore) key(savePath string) string {
+	return path.Join(s.prefix, savePath)
+}
+
+func (s *S3BlobStore) Get(_ *sql.Tx, blob Blob) ([]byte, error) {
+	out, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(blob.SavePath)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+
+	return ioutil.ReadAll(out.Body)
+}
+
+func (s *S3BlobStore) Put(_ *sql.Tx, blob Blob, data []byte) (string, error) {
+	savePath := blobSavePath(blob)
+	_, err := s.client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(savePath)),
+		Body:   bytes.NewReader(data),
+	})
+
+	return savePath, err
+}
+
+func (s *S3BlobStore) Delete(_ *sql.Tx, blob Blob) error {
+	_, err := s.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(blob.SavePath)),
+	})
+
+	return err
+}
+
+// BlobMigration moves blob content from one store to another in batches. Each batch updates the blobs table in a
+// single transaction, so an interrupted migration continues with the blobs which are still in the source store
+// when it's run again.
+type BlobMigration struct {
+	Db        *sql.DB
+	From      BlobStore
+	To        BlobStore
+	BatchSize int
+	// KeepSource leaves the content in the source store after the blob is moved
+	KeepSource bool
+}
+
+// BlobMigrationResult is the summary of a migration, or of a single batch
+type BlobMigrationResult struct {
+	Migrated int64
+	Failed   int64
+	Bytes    int64
+	Errors   []string
+}
+
+func (r *BlobMigrationResult) add(other BlobMigrationResult) {
+	r.Migrated += other.Migrated
+	r.Failed += other.Failed
+	r.Bytes += other.Bytes
+	r.Errors = append(r.Errors, other.Errors...)
+}
+
+func (r *BlobMigrationResult) fail(blob Blob, err error) {
+	r.Failed++
+	r.Errors = append(r.Errors, fmt.Sprintf("blob %d (%s): %s", blob.Id, blob.SavePath, err))
+}
+
+// Run migrates every blob of the source store, progress is called after each batch
+func (m *BlobMigration) Run(progress func(batch BlobMigrationResult)) (BlobMigrationResult, error) {
+	var (
+		total  BlobMigrationResult
+		lastId int64
+	)
+
+	for {
+		blobs, err := m.nextBatch(lastId)
+		if err != nil {
+			return total, err
+		}
+		if len(blobs) == 0 {
+			return total, nil
+		}
+
+		batch, err := m.migrateBatch(blobs)
+		total.add(batch)
+		if err != nil {
+			return total, err
+		}
+		if progress != nil {
+			progress(batch)
+		}
+
+		// failed blobs stay in the source store, so they are skipped by the id
+		lastId = blobs[len(blobs)-1].Id
+	}
+}
+
+func (m *BlobMigration) nextBatch(lastId int64) ([]Blob, error) {
+	rows, err := m.Db.Query(
+		"SELECT id, save_path, blob_hash FROM blobs WHERE id > ? AND storage_loc = ? ORDER BY id ASC LIMIT ?",
+		lastId, m.From.Location(), m.BatchSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blobs []Blob
+	for rows.Next() {
+		var (
+			blob     Blob
+			savePath sql.NullString
+		)
+		if err = rows.Scan(&blob.Id, &savePath, &blob.Hash); err != nil {
+			return nil, err
+		}
+		blob.SavePath = savePath.String
+		blobs = append(blobs, blob)
+	}
+
+	return blobs, rows.Err()
+}
+
+func (m *BlobMigration) migrateBatch(blobs []Blob) (BlobMigrationResult, error) {
+	var result BlobMigrationResult
+
+	tx, err := m.Db.Begin()
+	if err != nil {
+		return result, err
+	}
+
+	var moved []Blob
+	for _, blob := range blobs {
+		size, err := m.migrateBlob(tx, blob)
+		if err != nil {
+			result.fail(blob, err)
+			continue
+		}
+		result.Migrated++
+		result.Bytes += size
+		moved = append(moved, blob)
+	}
+
+	if err = tx.Commit(); err != nil {
+		// the blob rows still point to the source store, so the content written to the target is unused
+		return BlobMigrationResult{}, err
+	}
+
+	if !m.KeepSource {
+		for _, blob := range moved {
+			if _, ok := m.From.(*DbBlobStore); ok {
+				continue
+			}
+			if err = m.From.Delete(nil, blob); err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("blob %d (%s): migrated, but not deleted from %s: %s", blob.Id, blob.SavePath, m.From.Location(), err))
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// migrateBlob copies a blob into the target store, checks the copy against blob_hash and points the blob row
+// to the target store
+func (m *BlobMigration) migrateBlob(tx *sql.Tx, blob Blob) (int64, error) {
+	data, err := m.From.Get(tx, blob)
+	if err != nil {
+		return 0, err
+	}
+	sum := fmt.Sprintf("%x", md5.Sum(data))
+	if blob.Hash != "" && sum != blob.Hash {
+		return 0, fmt.Errorf("content in %s doesn't match the blob hash (expected %s, got %s)", m.From.Location(), blob.Hash, sum)
+	}
+
+	savePath, err := m.To.Put(tx, blob, data)
+	if err != nil {
+		return 0, err
+	}
+
+	written, err := m.To.Get(tx, Blob{Id: blob.Id, SavePath: savePath, Hash: blob.Hash})
+	if err != nil {
+		return 0, err
+	}
+	if writtenSum := fmt.Sprintf("%x", md5.Sum(written)); writtenSum != sum {
+		return 0, fmt.Errorf("content written to %s doesn't match (expected %s, got %s)", m.To.Location(), sum, writtenSum)
+	}
+
+	_, err = tx.Exec(
+		"UPDATE blobs SET storage_loc = ?, save_path = ? WHERE id = ? AND storage_loc = ?",
+		m.To.Location(), savePath, blob.Id, m.From.Location(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	// content in the database is removed together with the blob row update
+	if _, ok := m.From.(*DbBlobStore); ok && !m.KeepSource {
+		if err = m.From.Delete(tx, blob); err != nil {
+			return 0, err
+		}
+	}
+
+	return int64(len(data)), nil
+}
@@ -0,0 +1,113 @@
+package util
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestBlobMigration(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	dir := t.TempDir()
+	_ = os.MkdirAll(filepath.Join(dir, "1"), 0755)
+	_ = ioutil.WriteFile(filepath.Join(dir, "1", "ok"), []byte("hello"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, "1", "bad"), []byte("changed"), 0644)
+
+	batchSql := `SELECT id, save_path, blob_hash FROM blobs WHERE id > \? AND storage_loc = \? ORDER BY id ASC LIMIT \?`
+	mock.ExpectQuery(batchSql).WithArgs(0, "fs", 10).WillReturnRows(sqlmock.NewRows([]string{"id", "save_path", "blob_hash"}).
+		AddRow(1, "1/ok", "5d41402abc4b2a76b9719d911017c592").
+		AddRow(2, "1/bad", "5d41402abc4b2a76b9719d911017c592"))
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM blobs_storage WHERE blob_id = ?").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO blobs_storage \(blob_id, data\) VALUES \(\?, \?\)`).WithArgs(1, []byte("hello")).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT data FROM blobs_storage WHERE blob_id = ?").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"data"}).AddRow([]byte("hello")))
+	mock.ExpectExec(`UPDATE blobs SET storage_loc = \?, save_path = \? WHERE id = \? AND storage_loc = \?`).WithArgs("db", "1/ok", 1, "fs").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(batchSql).WithArgs(2, "fs", 10).WillReturnRows(sqlmock.NewRows([]string{"id", "save_path", "blob_hash"}))
+
+	migration := &BlobMigration{Db: db, From: &FsBlobStore{Dir: dir}, To: &DbBlobStore{}, BatchSize: 10}
+	result, err := migration.Run(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Migrated != 1 || result.Failed != 1 || result.Bytes != 5 {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "1", "ok")); !os.IsNotExist(err) {
+		t.Error("Migrated blob wasn't deleted from the filesystem")
+	}
+	if _, err = os.Stat(filepath.Join(dir, "1", "bad")); err != nil {
+		t.Error("Blob with a wrong hash should stay in the filesystem")
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestS3BlobStore(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		objects = map[string][]byte{}
+	)
+	// a minimal stand-in for an S3 compatible storage with path style requests
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.Method {
+		case http.MethodPut:
+			objects[r.URL.Path], _ = ioutil.ReadAll(r.Body)
+		case http.MethodGet:
+			data, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(data)
+		case http.MethodDelete:
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	store, err := NewS3BlobStore("s3://bucket/attachments?endpoint=" + server.URL + "&path_style=true&aws_access_key_id=id&aws_access_key_secret=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	savePath, err := store.Put(nil, Blob{Id: 1234}, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if savePath != "migrated/1/1234" {
+		t.Errorf("Unexpected save path %s", savePath)
+	}
+	if _, ok := objects["/bucket/attachments/migrated/1/1234"]; !ok {
+		t.Errorf("Object wasn't uploaded with the expected key: %v", objects)
+	}
+
+	data, err := store.Get(nil, Blob{Id: 1234, SavePath: savePath})
+	if err != nil || string(data) != "hello" {
+		t.Errorf("Unexpected content %q: %v", data, err)
+	}
+
+	if err = store.Delete(nil, Blob{Id: 1234, SavePath: savePath}); err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 0 {
+		t.Error("Object wasn't deleted")
+	}
+}
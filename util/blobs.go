@@ -0,0 +1,112 @@
+package util
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// LastBlobId returns the id of the last blob stored in the filesystem, or 0 if there are none
+func LastBlobId(ctx context.Context, db *sql.DB) (int64, error) {
+	res, err := db.QueryContext(ctx, "SELECT id FROM blobs WHERE storage_loc = 'fs' ORDER BY id DESC LIMIT 1 ")
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer res.Close()
+
+	if res.Next() {
+		var id int64
+		err = res.Scan(&id)
+
+		return id, err
+	}
+
+	return 0, res.Err()
+}
+
+// NextBlobBatch returns up to 100 blobs stored in the filesystem with an id greater than startId, in id order.
+// An empty batch means there are no more blobs.
+func NextBlobBatch(ctx context.Context, db *sql.DB, startId int64) ([]Blob, error) {
+
+	res, err := db.QueryContext(ctx, `SELECT id, save_path, blob_hash FROM blobs WHERE id > ? AND storage_loc = 'fs' ORDER BY id ASC LIMIT 100`, startId)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Close()
+
+	var recs []Blob
+
+	for res.Next() {
+		var r Blob
+		err = res.Scan(&r.Id, &r.SavePath, &r.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		recs = append(recs, r)
+	}
+
+	return recs, res.Err()
+}
+
+// FileMd5 returns the hex MD5 of a file, which is what the blobs table stores in blob_hash
+func FileMd5(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// AttachmentsPath returns the attachments dir of the Deskpro instance
+func AttachmentsPath(dpConfig map[string]string, dpPath string) string {
+	if val, ok := dpConfig["paths.dp_paths.attachments"]; ok {
+		return val
+	}
+
+	return filepath.Join(dpPath, "attachments")
+}
+
+// CopyFile copies the src file into dst with the same permissions, creating the dirs of dst
+func CopyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err = io.Copy(out, in); err != nil {
+		return err
+	}
+
+	return out.Close()
+}
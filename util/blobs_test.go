@@ -0,0 +1,50 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestLastBlobId(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	expectedSqlLastBlobSql := "SELECT id FROM blobs WHERE storage_loc = 'fs' ORDER BY id DESC LIMIT 1 "
+	rows := sqlmock.NewRows([]string{"id"}).AddRow("1")
+	mock.ExpectQuery(expectedSqlLastBlobSql).WillReturnRows(rows)
+	if id, err := LastBlobId(context.Background(), db); err != nil || id != 1 {
+		t.Errorf("Expected the last blob 1, got %d (%v)", id, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	mock.ExpectQuery("SELECT id FROM blobs").WillReturnError(errors.New("table blobs doesn't exist"))
+	if _, err := LastBlobId(context.Background(), db); err == nil {
+		t.Error("Expected the query error to be returned")
+	}
+}
+
+func TestNextBlobBatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	expectedSql := `SELECT id, save_path, blob_hash FROM blobs WHERE id > \? AND storage_loc = 'fs' ORDER BY id ASC LIMIT 100`
+	mock.ExpectQuery(expectedSql).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "save_path", "blob_hash"}).AddRow(2, "1/test", "hash"))
+
+	batch, err := NextBlobBatch(context.Background(), db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 1 || batch[0] != (Blob{Id: 2, SavePath: "1/test", Hash: "hash"}) {
+		t.Errorf("Unexpected batch %+v", batch)
+	}
+}
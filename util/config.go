@@ -54,18 +54,18 @@ func (config *Config) GetDeskproConfig() (map[string]string, error) {
 	return config.config, err
 }
 
-func  (config *Config) ValidateDeskproConfig(cmd *cobra.Command) map[string]string {
+// LoadDeskproConfig reads the Deskpro config and describes the Deskpro instance the command runs for
+func (config *Config) LoadDeskproConfig(cmd *cobra.Command) (map[string]string, error) {
 
 	dpConfig, err := config.GetDeskproConfig()
 
 	if err != nil {
-		fmt.Println("We failed to read the Deskpro config files. Are they there?")
 		fmt.Println("To start fresh, you can install clean config files with this command:")
 		fmt.Println("")
 		fmt.Println(config.PhpPath(), " ", filepath.Join(config.DpPath(), "bin", "console"), " install:fresh-config")
 		fmt.Println("")
 		fmt.Println("After config files are inserted, you will need to modify the config.database.php file with your database details.")
-		os.Exit(1)
+		return nil, NewError(ConfigError, "We failed to read the Deskpro config files. Are they there?", err)
 	}
 
 	fmt.Println("==========================================================================================")
@@ -76,5 +76,16 @@ func  (config *Config) ValidateDeskproConfig(cmd *cobra.Command) map[string]stri
 	fmt.Println("\tDeskpro Path: ", config.dpPath)
 	fmt.Println("\tConfig Path: ", filepath.Join(config.dpPath, "config"))
 
+	return dpConfig, nil
+}
+
+// ValidateDeskproConfig is LoadDeskproConfig for commands which exit as soon as something fails
+func (config *Config) ValidateDeskproConfig(cmd *cobra.Command) map[string]string {
+	dpConfig, err := config.LoadDeskproConfig(cmd)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(ErrorKindOf(err).ExitCode())
+	}
+
 	return dpConfig
 }
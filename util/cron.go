@@ -0,0 +1,149 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a cron style schedule with the minute, hour, day of month, month and day of week fields, e.g.
+// "30 2 * * *" for every day at 2:30 or "0 */6 * * 1-5" for every 6 hours on weekdays. Fields accept *, numbers,
+// ranges (1-5), lists (1,15) and steps (*/15). @hourly, @daily, @weekly and @monthly are accepted too.
+type CronSchedule struct {
+	spec    string
+	minutes []bool
+	hours   []bool
+	days    []bool
+	months  []bool
+	weekday []bool
+	// like in cron, a day matches either the day of month or the day of week when both are restricted
+	anyDay     bool
+	anyWeekday bool
+}
+
+var cronAliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// ParseCronSchedule parses a cron expression
+func ParseCronSchedule(spec string) (*CronSchedule, error) {
+	expr := strings.TrimSpace(spec)
+	if alias, ok := cronAliases[expr]; ok {
+		expr = alias
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron schedule %q must have 5 fields: minute hour day-of-month month day-of-week", spec)
+	}
+
+	schedule := &CronSchedule{spec: spec, anyDay: fields[2] == "*", anyWeekday: fields[4] == "*"}
+	var err error
+	if schedule.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute: %s", err)
+	}
+	if schedule.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour: %s", err)
+	}
+	if schedule.days, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("day of month: %s", err)
+	}
+	if schedule.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("month: %s", err)
+	}
+	// 7 is Sunday too
+	if schedule.weekday, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("day of week: %s", err)
+	}
+	schedule.weekday[0] = schedule.weekday[0] || schedule.weekday[7]
+
+	return schedule, nil
+}
+
+func parseCronField(field string, min int, max int) ([]bool, error) {
+	values := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return nil, fmt.Errorf("wrong step in %q", part)
+			}
+			part = part[:i]
+		}
+
+		from, to := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("wrong value %q", part)
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("wrong range %q", part)
+				}
+			} else if step > 1 {
+				// "5/15" means from 5 to the end every 15
+				to = max
+			}
+			if from < min || to > max || from > to {
+				return nil, fmt.Errorf("%q is out of the range %d-%d", part, min, max)
+			}
+		}
+
+		for v := from; v <= to; v += step {
+			values[v] = true
+		}
+	}
+
+	return values, nil
+}
+
+// Next returns the first time after t which matches the schedule, in the location of t
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// every schedule matches at least once in 4 years (e.g. on Feb 29th)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !s.months[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.hours[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.minutes[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+func (s *CronSchedule) matchDay(t time.Time) bool {
+	day, weekday := s.days[t.Day()], s.weekday[int(t.Weekday())]
+	if s.anyDay || s.anyWeekday {
+		return day && weekday
+	}
+
+	return day || weekday
+}
+
+func (s *CronSchedule) String() string {
+	return s.spec
+}
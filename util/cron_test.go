@@ -0,0 +1,45 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	from := time.Date(2021, 2, 1, 10, 30, 0, 0, time.UTC) // a Monday
+
+	tests := []struct {
+		spec string
+		next time.Time
+	}{
+		{"0 2 * * *", time.Date(2021, 2, 2, 2, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2021, 2, 1, 10, 45, 0, 0, time.UTC)},
+		{"30 10 * * *", time.Date(2021, 2, 2, 10, 30, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2021, 2, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2021, 2, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 9-17/4 * * 1-5", time.Date(2021, 2, 1, 13, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2021, 2, 15, 0, 0, 0, 0, time.UTC)},
+		// either the day of month or the day of week
+		{"0 0 15 * 3", time.Date(2021, 2, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		schedule, err := ParseCronSchedule(test.spec)
+		if err != nil {
+			t.Errorf("%s: %s", test.spec, err)
+			continue
+		}
+		if next := schedule.Next(from); !next.Equal(test.next) {
+			t.Errorf("%s: expected %s, got %s", test.spec, test.next, next)
+		}
+	}
+}
+
+func TestParseCronScheduleErrors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "0 0 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseCronSchedule(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
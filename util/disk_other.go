@@ -0,0 +1,28 @@
+//go:build !windows
+
+package util
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// FreeDiskSpace returns the bytes available to this user on the filesystem of the path
+func FreeDiskSpace(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
+
+// DiskId identifies the filesystem of the path, paths with the same id share their free space
+func DiskId(path string) (string, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprint(stat.Dev), nil
+}
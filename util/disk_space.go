@@ -0,0 +1,243 @@
+package util
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alexmullins/zip"
+)
+
+// SpaceRequirement is an estimate of the bytes a command writes into Dir, Purpose tells what for
+type SpaceRequirement struct {
+	Dir     string
+	Purpose string
+	Bytes   int64
+}
+
+// DiskUsage are the requirements of the dirs on one filesystem and the free space of that filesystem
+type DiskUsage struct {
+	Dirs     []string
+	Purposes []string
+	Required int64
+	Free     int64
+}
+
+// Fits tells if the filesystem has enough free space for the requirements
+func (u DiskUsage) Fits() bool {
+	return u.Required <= u.Free
+}
+
+func (u DiskUsage) String() string {
+	return fmt.Sprintf("%s: about %s needed for the %s, %s free",
+		strings.Join(u.Dirs, ", "), FormatSize(u.Required), strings.Join(u.Purposes, ", "), FormatSize(u.Free))
+}
+
+// EstimateDiskUsage adds up the requirements of the dirs on the same filesystem and reads its free space, in the
+// order of the requirements. A dir which doesn't exist yet is checked on its nearest existing parent, requirements
+// of 0 bytes are left out.
+func EstimateDiskUsage(requirements []SpaceRequirement) ([]DiskUsage, error) {
+	var (
+		usages []*DiskUsage
+		disks  = map[string]*DiskUsage{}
+	)
+	for _, requirement := range requirements {
+		if requirement.Bytes <= 0 {
+			continue
+		}
+
+		dir := existingParent(requirement.Dir)
+		disk, err := DiskId(dir)
+		if err != nil {
+			return nil, err
+		}
+		usage, ok := disks[disk]
+		if !ok {
+			usage = &DiskUsage{}
+			if usage.Free, err = FreeDiskSpace(dir); err != nil {
+				return nil, err
+			}
+			disks[disk] = usage
+			usages = append(usages, usage)
+		}
+
+		if !containsString(usage.Dirs, requirement.Dir) {
+			usage.Dirs = append(usage.Dirs, requirement.Dir)
+		}
+		usage.Purposes = append(usage.Purposes, requirement.Purpose)
+		usage.Required += requirement.Bytes
+	}
+
+	result := make([]DiskUsage, len(usages))
+	for i, usage := range usages {
+		result[i] = *usage
+	}
+
+	return result, nil
+}
+
+// DiskSpaceError returns a ConfigError describing the filesystems without enough free space, nil if they all fit
+func DiskSpaceError(usages []DiskUsage) error {
+	var short []string
+	for _, usage := range usages {
+		if !usage.Fits() {
+			short = append(short, usage.String())
+		}
+	}
+	if len(short) == 0 {
+		return nil
+	}
+
+	return NewError(ConfigError, "Not enough free disk space ("+strings.Join(short, "; ")+
+		"). Free some space or use other dirs, or use --force to start anyway", nil)
+}
+
+// SameDisk tells if both paths are on the same filesystem, so files can be moved between them without copying
+func SameDisk(a string, b string) bool {
+	aDisk, err := DiskId(existingParent(a))
+	if err != nil {
+		return false
+	}
+	bDisk, err := DiskId(existingParent(b))
+
+	return err == nil && aDisk == bDisk
+}
+
+func existingParent(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+func containsString(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SourceSize returns the size of a local file or dir, or the Content-Length of an http(s) URL from a HEAD request.
+// It returns false if the size can't be found out without downloading the source, e.g. for other go-getter URLs.
+func SourceSize(ctx context.Context, src string) (int64, bool) {
+	if u, err := url.Parse(src); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, src, nil)
+		if err != nil {
+			return 0, false
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0, false
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.ContentLength < 0 {
+			return 0, false
+		}
+		return resp.ContentLength, true
+	} else if err == nil && u.Scheme == "file" {
+		src = u.Path
+	}
+
+	info, err := os.Stat(src)
+	if err != nil {
+		return 0, false
+	}
+	if !info.IsDir() {
+		return info.Size(), true
+	}
+	size, err := DirSize(src)
+
+	return size, err == nil
+}
+
+// DirSize returns the total size of the files in the dir and its subdirs
+func DirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+
+	return size, err
+}
+
+// ZipEntriesSize returns the uncompressed size of the entries of a zip archive whose name starts with prefix,
+// which is what the archive takes once extracted if prefix is empty
+func ZipEntriesSize(path string, prefix string) (int64, error) {
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	return ZipFilesSize(reader.File, prefix), nil
+}
+
+// ZipFilesSize is ZipEntriesSize for the entries of an open zip archive
+func ZipFilesSize(files []*zip.File, prefix string) int64 {
+	var size int64
+	for _, f := range files {
+		if strings.HasPrefix(f.Name, prefix) {
+			size += int64(f.UncompressedSize64)
+		}
+	}
+
+	return size
+}
+
+// DatabaseSize estimates the size of a dump of the database from the size of its tables and indexes
+func DatabaseSize(ctx context.Context, db *sql.DB, dbName string) (int64, error) {
+	var size int64
+	err := db.QueryRowContext(ctx,
+		"SELECT COALESCE(SUM(data_length + index_length), 0) FROM information_schema.tables WHERE table_schema = ?",
+		dbName,
+	).Scan(&size)
+
+	return size, err
+}
+
+// DeskproDatabasesSize estimates the size of the dumps of the default database and of every configured advanced
+// connection (audit, voice, system)
+func DeskproDatabasesSize(ctx context.Context, dpConfig map[string]string) (int64, error) {
+	var total int64
+	for _, prefix := range []string{"database", "database_advanced.audit", "database_advanced.voice", "database_advanced.system"} {
+		murl := GetMysqlUrlFromConfig(dpConfig, prefix)
+		if murl.User.Username() == "" {
+			continue
+		}
+
+		db, err := GetMysqlConnection(murl)
+		if err != nil {
+			return 0, err
+		}
+		size, err := DatabaseSize(ctx, db, strings.TrimLeft(murl.Path, "/"))
+		_ = db.Close()
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+
+	return total, nil
+}
@@ -0,0 +1,71 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alexmullins/zip"
+)
+
+func TestEstimateDiskUsage(t *testing.T) {
+	dir := t.TempDir()
+	usages, err := EstimateDiskUsage([]SpaceRequirement{
+		{Dir: dir, Purpose: "backup archive", Bytes: 1024},
+		{Dir: filepath.Join(dir, "missing", "dir"), Purpose: "safety snapshot", Bytes: 2048},
+		{Dir: dir, Purpose: "attachments", Bytes: 0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usages) != 1 || usages[0].Required != 3072 || len(usages[0].Dirs) != 2 || len(usages[0].Purposes) != 2 {
+		t.Fatalf("Expected the dirs to be checked together, got %+v", usages)
+	}
+	if !usages[0].Fits() || DiskSpaceError(usages) != nil {
+		t.Errorf("Expected 3K to fit into %s", FormatSize(usages[0].Free))
+	}
+
+	usages[0].Required = usages[0].Free + 1
+	if err = DiskSpaceError(usages); ErrorKindOf(err) != ConfigError {
+		t.Errorf("Expected a config error, got %v", err)
+	}
+}
+
+func TestSourceSize(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	_ = os.WriteFile(filepath.Join(dir, "a"), make([]byte, 10), 0644)
+	_ = os.WriteFile(filepath.Join(dir, "sub", "b"), make([]byte, 5), 0644)
+
+	if size, ok := SourceSize(context.Background(), dir); !ok || size != 15 {
+		t.Errorf("Expected 15 bytes in the dir, got %d", size)
+	}
+	if size, ok := SourceSize(context.Background(), "file://"+filepath.ToSlash(filepath.Join(dir, "a"))); !ok || size != 10 {
+		t.Errorf("Expected 10 bytes in the file, got %d", size)
+	}
+	if _, ok := SourceSize(context.Background(), "s3://bucket/backup.zip"); ok {
+		t.Error("The size of an s3 object can't be known")
+	}
+}
+
+func TestZipEntriesSize(t *testing.T) {
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for name, size := range map[string]int{"database.sql": 100, "attachments/01/a": 30, "attachments/01/b": 20} {
+		w, _ := writer.Create(name)
+		_, _ = w.Write(make([]byte, size))
+	}
+	_ = writer.Close()
+
+	path := filepath.Join(t.TempDir(), "backup.zip")
+	_ = os.WriteFile(path, buf.Bytes(), 0644)
+
+	if size, err := ZipEntriesSize(path, ""); err != nil || size != 150 {
+		t.Errorf("Expected 150 bytes, got %d %v", size, err)
+	}
+	if size, err := ZipEntriesSize(path, "attachments/"); err != nil || size != 50 {
+		t.Errorf("Expected 50 bytes of attachments, got %d %v", size, err)
+	}
+}
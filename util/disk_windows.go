@@ -0,0 +1,33 @@
+package util
+
+import (
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// FreeDiskSpace returns the bytes available to this user on the filesystem of the path
+func FreeDiskSpace(path string) (int64, error) {
+	dir, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var available, total, free uint64
+	if err = windows.GetDiskFreeSpaceEx(dir, &available, &total, &free); err != nil {
+		return 0, err
+	}
+
+	return int64(available), nil
+}
+
+// DiskId identifies the filesystem of the path, paths with the same id share their free space
+func DiskId(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ToUpper(filepath.VolumeName(path)), nil
+}
@@ -0,0 +1,130 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DumpInfo describes what was found while scanning an SQL dump produced by mysqldump
+type DumpInfo struct {
+	Size      int64
+	Tables    int
+	Header    bool
+	Completed bool
+}
+
+var (
+	dumpHeaderPrefix = []byte("-- MySQL dump")
+	dumpFooterPrefix = []byte("-- Dump completed")
+	createTable      = []byte("CREATE TABLE")
+)
+
+// CountingReader counts the bytes read through it
+type CountingReader struct {
+	Reader io.Reader
+	Count  int64
+}
+
+func (r *CountingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.Count += int64(n)
+	return n, err
+}
+
+// ScanDump reads an SQL dump to the end and reports whether it has the mysqldump header, how many tables it
+// creates and whether it ends with the "Dump completed" footer. A missing footer means the dump was truncated.
+func ScanDump(r io.Reader) (DumpInfo, error) {
+	var (
+		info      DumpInfo
+		lineNo    int
+		lineStart = true
+		lastLine  []byte
+	)
+
+	counter := &CountingReader{Reader: r}
+	reader := bufio.NewReaderSize(counter, 64*1024)
+
+	for {
+		// INSERT lines may be megabytes long, only the beginning of each line is interesting here
+		chunk, isPrefix, err := reader.ReadLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			info.Size = counter.Count
+			return info, err
+		}
+
+		if lineStart {
+			lineNo++
+			if lineNo == 1 {
+				info.Header = bytes.HasPrefix(chunk, dumpHeaderPrefix)
+			}
+			if bytes.HasPrefix(chunk, createTable) {
+				info.Tables++
+			}
+			if len(bytes.TrimSpace(chunk)) > 0 {
+				lastLine = append(lastLine[:0], chunk...)
+			}
+		}
+
+		lineStart = !isPrefix
+	}
+
+	info.Size = counter.Count
+	info.Completed = bytes.HasPrefix(lastLine, dumpFooterPrefix)
+
+	return info, nil
+}
+
+// BinlogCoordinates is the position in the binary log of the server at the moment a consistent dump was taken
+type BinlogCoordinates struct {
+	File     string `json:"file"`
+	Position int64  `json:"position"`
+	GtidSet  string `json:"gtid_set,omitempty"`
+}
+
+var (
+	changeMasterRe = regexp.MustCompile(`CHANGE (?:MASTER|REPLICATION SOURCE) TO (?:MASTER|SOURCE)_LOG_FILE='([^']+)', (?:MASTER|SOURCE)_LOG_POS=(\d+)`)
+	gtidPurgedRe   = regexp.MustCompile(`(?s)GTID_PURGED=(?:/\*!80000 '\+'\*/ )?'([^']*)'`)
+)
+
+// ParseBinlogCoordinates finds the binary log coordinates written by mysqldump --source-data (or --master-data)
+// in the beginning of a dump. It returns nil if the dump doesn't record them.
+func ParseBinlogCoordinates(head []byte) *BinlogCoordinates {
+	match := changeMasterRe.FindSubmatch(head)
+	if match == nil {
+		return nil
+	}
+
+	position, _ := strconv.ParseInt(string(match[2]), 10, 64)
+	coordinates := &BinlogCoordinates{File: string(match[1]), Position: position}
+
+	if gtid := gtidPurgedRe.FindSubmatch(head); gtid != nil {
+		coordinates.GtidSet = strings.Replace(string(gtid[1]), "\n", "", -1)
+	}
+
+	return coordinates
+}
+
+// HeadWriter passes everything through to the writer and keeps the first Limit bytes
+type HeadWriter struct {
+	Writer io.Writer
+	Limit  int
+	Head   []byte
+}
+
+func (w *HeadWriter) Write(p []byte) (int, error) {
+	if missing := w.Limit - len(w.Head); missing > 0 {
+		if missing > len(p) {
+			missing = len(p)
+		}
+		w.Head = append(w.Head, p[:missing]...)
+	}
+
+	return w.Writer.Write(p)
+}
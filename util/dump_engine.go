@@ -0,0 +1,306 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/url"
+	"os/exec"
+	"strings"
+)
+
+const (
+	EngineMysqldump = "mysqldump"
+	EngineNative    = "native"
+
+	ProfileConsistent = "consistent"
+	ProfileLegacy     = "legacy"
+)
+
+// DumpOptions controls how databases are dumped and imported by the backup and restore
+type DumpOptions struct {
+	// Engine is EngineMysqldump (mysqldump and mysql binaries configured in Deskpro) or EngineNative
+	Engine string
+	// Profile is ProfileConsistent or ProfileLegacy
+	Profile string
+	// ExtraArgs are passed to mysqldump, only used with EngineMysqldump
+	ExtraArgs []string
+	// Tables selects the tables which are dumped and imported, every table if it's zero
+	Tables TableFilter
+	// Warning receives the problems which don't fail the dump, e.g. binary log coordinates which can't be
+	// recorded. Warnings are discarded if it's nil.
+	Warning func(message string)
+}
+
+// mysqlConnectionArgs returns the connection arguments for mysql and mysqldump binaries
+func mysqlConnectionArgs(murl url.URL) []string {
+	pass, _ := murl.User.Password()
+	port := murl.Port()
+	if len(port) < 1 {
+		port = "3306"
+	}
+
+	args := []string{
+		"-h", murl.Hostname(),
+		"--port", port,
+		"-u", murl.User.Username(),
+	}
+	if pass != "" {
+		args = append(args, "--password="+pass)
+	}
+
+	return args
+}
+
+// checkMysqlBinary makes sure a MySQL client binary configured in Deskpro can be executed
+func checkMysqlBinary(bin string, configKey string) error {
+	if bin == "" {
+		return fmt.Errorf("%s is not set in the Deskpro config. Install the MySQL client or use --engine=native", configKey)
+	}
+	if _, err := exec.LookPath(bin); err != nil {
+		return fmt.Errorf("%s (%s) can't be executed: %s. Install the MySQL client or use --engine=native", configKey, bin, err)
+	}
+
+	return nil
+}
+
+// MysqlBinaryVersion returns the version line of a MySQL client binary configured in Deskpro, configKey is
+// "paths.mysql_path" or "paths.mysqldump_path"
+func MysqlBinaryVersion(dpConfig map[string]string, configKey string) (string, error) {
+	bin := dpConfig[configKey]
+	if err := checkMysqlBinary(bin, configKey); err != nil {
+		return "", err
+	}
+
+	out, err := exec.Command(bin, "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("%s (%s) --version failed: %s", configKey, bin, err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// DumpDatabase writes an SQL dump of the database at murl into w. If the dump was made from a consistent snapshot
+// with binary logging enabled on the server, the binary log coordinates of the snapshot are returned.
+func DumpDatabase(ctx context.Context, dpConfig map[string]string, murl url.URL, opts DumpOptions, w io.Writer) (*BinlogCoordinates, error) {
+	dbName := strings.TrimLeft(murl.Path, "/")
+
+	// mysqldump writes the coordinates in the beginning of the dump, right after the header
+	head := &HeadWriter{Writer: w, Limit: 64 * 1024}
+
+	if opts.Engine == EngineNative {
+		conn, err := GetMysqlConnection(murl)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+
+		nativeOpts := NativeDumpOptions{
+			Consistent: opts.Profile == ProfileConsistent,
+			Routines:   opts.Profile == ProfileConsistent,
+			Tables:     opts.Tables,
+			Warning:    opts.Warning,
+		}
+		if err = NativeDump(ctx, conn, dbName, nativeOpts, head); err != nil {
+			return nil, err
+		}
+
+		return ParseBinlogCoordinates(head.Head), nil
+	}
+
+	mysqlDumpBin := dpConfig["paths.mysqldump_path"]
+	if err := checkMysqlBinary(mysqlDumpBin, "paths.mysqldump_path"); err != nil {
+		return nil, err
+	}
+
+	args := append(mysqlConnectionArgs(murl), "-C")
+	if opts.Profile == ProfileConsistent {
+		consistentArgs, warning := consistentDumpArgs(mysqlDumpBin, murl)
+		if warning != "" && opts.Warning != nil {
+			opts.Warning(warning)
+		}
+		args = append(args, consistentArgs...)
+	}
+	args = append(args, opts.ExtraArgs...)
+	if !opts.Tables.IsZero() {
+		ignored, err := ignoredTableArgs(ctx, murl, dbName, opts.Tables)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, ignored...)
+	}
+	args = append(args, dbName)
+
+	var dumpBuff bytes.Buffer
+	dumpCmd := exec.CommandContext(ctx, mysqlDumpBin, args...)
+	dumpCmd.Stdout = head
+	dumpCmd.Stderr = &dumpBuff
+
+	if err := dumpCmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s\nError output for dump command:\n%s", err, dumpBuff.String())
+	}
+
+	return ParseBinlogCoordinates(head.Head), nil
+}
+
+// ignoredTableArgs returns the mysqldump options which skip the tables and views the filter doesn't select
+func ignoredTableArgs(ctx context.Context, murl url.URL, dbName string, filter TableFilter) ([]string, error) {
+	db, err := GetMysqlConnection(murl)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	tables, views, err := listTables(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var args []string
+	for _, table := range append(tables, views...) {
+		if !filter.Match(table) {
+			args = append(args, "--ignore-table="+dbName+"."+table)
+		}
+	}
+
+	return args, nil
+}
+
+// consistentDumpArgs returns the mysqldump options of the "consistent" dump profile. Binary log coordinates are
+// only requested when binary logging is enabled and the user has the privileges to read them, otherwise mysqldump
+// refuses to dump. The returned warning says why the coordinates are skipped.
+func consistentDumpArgs(mysqlDumpBin string, murl url.URL) ([]string, string) {
+	args := []string{
+		"--single-transaction",
+		"--quick",
+		"--routines",
+		"--triggers",
+		"--events",
+		"--hex-blob",
+	}
+
+	conn, err := GetMysqlConnection(murl)
+	if err != nil {
+		return args, ""
+	}
+	defer conn.Close()
+
+	enabled, missing, err := binlogDumpPrivileges(conn)
+	if err != nil || !enabled {
+		return args, ""
+	}
+	if len(missing) > 0 {
+		return args, "The binary log coordinates are not recorded in the dump, the MySQL user " +
+			murl.User.Username() + " lacks the " + strings.Join(missing, " and ") + " privilege"
+	}
+
+	// --master-data was renamed to --source-data in MySQL 8.0.26, the old name prints a deprecation warning
+	help, _ := exec.Command(mysqlDumpBin, "--help").Output()
+	if strings.Contains(string(help), "--source-data") {
+		return append(args, "--source-data=2"), ""
+	}
+
+	return append(args, "--master-data=2"), ""
+}
+
+// binlogPrivileges are the global privileges mysqldump needs to record the binary log coordinates
+var binlogPrivileges = []string{"RELOAD", "REPLICATION CLIENT"}
+
+// binlogDumpPrivileges tells if binary logging is enabled and which of the binlogPrivileges the current user
+// lacks. Privileges granted through roles aren't seen, those users are reported as lacking them.
+func binlogDumpPrivileges(conn *sql.DB) (bool, []string, error) {
+	var name, value string
+	if err := conn.QueryRow("SHOW VARIABLES LIKE 'log_bin'").Scan(&name, &value); err != nil {
+		return false, nil, err
+	}
+	if !strings.EqualFold(value, "ON") {
+		return false, nil, nil
+	}
+
+	rows, err := conn.Query("SHOW GRANTS FOR CURRENT_USER()")
+	if err != nil {
+		return true, nil, err
+	}
+	defer rows.Close()
+
+	var grants []string
+	for rows.Next() {
+		var grant string
+		if err = rows.Scan(&grant); err != nil {
+			return true, nil, err
+		}
+		grants = append(grants, grant)
+	}
+	if err = rows.Err(); err != nil {
+		return true, nil, err
+	}
+
+	return true, missingGlobalPrivileges(grants, binlogPrivileges), nil
+}
+
+// missingGlobalPrivileges returns the privileges which aren't granted ON *.* by the SHOW GRANTS statements
+func missingGlobalPrivileges(grants []string, privileges []string) []string {
+	granted := map[string]bool{}
+	for _, grant := range grants {
+		on := strings.Index(grant, " ON *.* TO ")
+		if !strings.HasPrefix(grant, "GRANT ") || on < 0 {
+			continue
+		}
+		for _, privilege := range strings.Split(grant[len("GRANT "):on], ",") {
+			granted[strings.ToUpper(strings.TrimSpace(privilege))] = true
+		}
+	}
+	if granted["ALL PRIVILEGES"] || granted["ALL"] {
+		return nil
+	}
+
+	var missing []string
+	for _, privilege := range privileges {
+		if !granted[privilege] {
+			missing = append(missing, privilege)
+		}
+	}
+
+	return missing
+}
+
+// ImportDatabase executes an SQL dump read from r on the database at murl. conn must be connected to the same
+// database, it's used by the native engine. Only the statements of the tables selected by opts.Tables are executed.
+func ImportDatabase(ctx context.Context, dpConfig map[string]string, murl url.URL, conn *sql.DB, opts DumpOptions, r io.Reader) error {
+	if !opts.Tables.IsZero() {
+		filtered := FilterDump(r, opts.Tables)
+		defer filtered.Close()
+		r = filtered
+	}
+
+	if opts.Engine == EngineNative {
+		return NativeImport(ctx, conn, r)
+	}
+
+	mysqlBin := dpConfig["paths.mysql_path"]
+	if err := checkMysqlBinary(mysqlBin, "paths.mysql_path"); err != nil {
+		return err
+	}
+
+	args := append(mysqlConnectionArgs(murl), strings.TrimLeft(murl.Path, "/"))
+
+	var importBuff bytes.Buffer
+	importCmd := exec.CommandContext(ctx, mysqlBin, args...)
+	importCmd.Stdin = r
+	importCmd.Stdout = &importBuff
+	importCmd.Stderr = &importBuff
+
+	if err := importCmd.Run(); err != nil {
+		return fmt.Errorf("%s\nImport command error output:\n%s", err, importBuff.String())
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package util
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func Test_mysqlConnectionArgs(t *testing.T) {
+	murl := url.URL{
+		Scheme: "mysql",
+		User:   url.UserPassword("deskpro", "secret"),
+		Host:   "localhost",
+		Path:   "/deskpro",
+	}
+
+	expected := []string{"-h", "localhost", "--port", "3306", "-u", "deskpro", "--password=secret"}
+	if actual := mysqlConnectionArgs(murl); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v was %v", expected, actual)
+	}
+}
+
+func Test_checkMysqlBinary(t *testing.T) {
+	if err := checkMysqlBinary("", "paths.mysqldump_path"); err == nil {
+		t.Error("Expected an error for a missing binary path")
+	}
+	if err := checkMysqlBinary("/path/to/missing/mysqldump", "paths.mysqldump_path"); err == nil {
+		t.Error("Expected an error for a binary which doesn't exist")
+	}
+}
+
+func Test_binlogDumpPrivileges(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SHOW VARIABLES LIKE 'log_bin'").
+		WillReturnRows(sqlmock.NewRows([]string{"Variable_name", "Value"}).AddRow("log_bin", "ON"))
+	mock.ExpectQuery(`SHOW GRANTS FOR CURRENT_USER\(\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"Grants"}).
+			AddRow("GRANT USAGE ON *.* TO `deskpro`@`%`").
+			AddRow("GRANT ALL PRIVILEGES ON `deskpro`.* TO `deskpro`@`%`"))
+
+	enabled, missing, err := binlogDumpPrivileges(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !enabled {
+		t.Error("Expected binary logging to be enabled")
+	}
+	if expected := []string{"RELOAD", "REPLICATION CLIENT"}; !reflect.DeepEqual(expected, missing) {
+		t.Errorf("Expected %v was %v", expected, missing)
+	}
+}
+
+func Test_binlogDumpPrivilegesDisabled(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SHOW VARIABLES LIKE 'log_bin'").
+		WillReturnRows(sqlmock.NewRows([]string{"Variable_name", "Value"}).AddRow("log_bin", "OFF"))
+
+	enabled, missing, err := binlogDumpPrivileges(db)
+	if err != nil || enabled || missing != nil {
+		t.Errorf("Expected binary logging to be disabled, was %v %v %v", enabled, missing, err)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_missingGlobalPrivileges(t *testing.T) {
+	tests := []struct {
+		grants   []string
+		expected []string
+	}{
+		{[]string{"GRANT RELOAD, REPLICATION CLIENT ON *.* TO `backup`@`localhost`"}, nil},
+		{[]string{"GRANT ALL PRIVILEGES ON *.* TO `root`@`localhost` WITH GRANT OPTION"}, nil},
+		{[]string{"GRANT SELECT, RELOAD ON *.* TO `backup`@`%`"}, []string{"REPLICATION CLIENT"}},
+		{[]string{"GRANT RELOAD, REPLICATION CLIENT ON `deskpro`.* TO `deskpro`@`%`"}, []string{"RELOAD", "REPLICATION CLIENT"}},
+	}
+
+	for _, test := range tests {
+		if actual := missingGlobalPrivileges(test.grants, binlogPrivileges); !reflect.DeepEqual(test.expected, actual) {
+			t.Errorf("Expected %v for %v was %v", test.expected, test.grants, actual)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScanDump(t *testing.T) {
+	dump := "-- MySQL dump 10.13\n" +
+		"CREATE TABLE `people` (`id` int);\n" +
+		"INSERT INTO `people` VALUES " + strings.Repeat("(1),", 100000) + "(2);\n" +
+		"CREATE TABLE `tickets` (`id` int);\n" +
+		"-- Dump completed on 2021-01-01 10:00:00\n"
+
+	info, err := ScanDump(strings.NewReader(dump))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !info.Header || !info.Completed || info.Tables != 2 || info.Size != int64(len(dump)) {
+		t.Errorf("Unexpected dump info %+v", info)
+	}
+
+	info, _ = ScanDump(strings.NewReader(dump[:len(dump)-60]))
+	if info.Completed {
+		t.Error("Truncated dump was reported as completed")
+	}
+}
+
+func TestParseBinlogCoordinates(t *testing.T) {
+	head := "-- MySQL dump 10.13\n" +
+		"--\n-- Position to start replication or point-in-time recovery from\n--\n\n" +
+		"-- CHANGE REPLICATION SOURCE TO SOURCE_LOG_FILE='binlog.000042', SOURCE_LOG_POS=1337;\n\n" +
+		"SET @@GLOBAL.GTID_PURGED=/*!80000 '+'*/ '3E11FA47-71CA-11E1-9E33-C80AA9429562:1-5,\n" +
+		"4E11FA47-71CA-11E1-9E33-C80AA9429562:1-3';\n"
+
+	coordinates := ParseBinlogCoordinates([]byte(head))
+	if coordinates == nil {
+		t.Fatal("Binlog coordinates were not found")
+	}
+	if coordinates.File != "binlog.000042" || coordinates.Position != 1337 {
+		t.Errorf("Unexpected binlog coordinates %+v", coordinates)
+	}
+	if coordinates.GtidSet != "3E11FA47-71CA-11E1-9E33-C80AA9429562:1-5,4E11FA47-71CA-11E1-9E33-C80AA9429562:1-3" {
+		t.Errorf("Unexpected GTID set %q", coordinates.GtidSet)
+	}
+
+	legacy := "-- CHANGE MASTER TO MASTER_LOG_FILE='mysql-bin.000003', MASTER_LOG_POS=154;\n"
+	if coordinates = ParseBinlogCoordinates([]byte(legacy)); coordinates == nil || coordinates.Position != 154 {
+		t.Errorf("Unexpected binlog coordinates %+v", coordinates)
+	}
+
+	if ParseBinlogCoordinates([]byte("-- MySQL dump 10.13\n")) != nil {
+		t.Error("Binlog coordinates were found in a dump without them")
+	}
+}
+
+func TestHeadWriter(t *testing.T) {
+	var out strings.Builder
+	w := &HeadWriter{Writer: &out, Limit: 5}
+
+	_, _ = w.Write([]byte("abc"))
+	_, _ = w.Write([]byte("defgh"))
+
+	if out.String() != "abcdefgh" || string(w.Head) != "abcde" {
+		t.Errorf("Unexpected output %q and head %q", out.String(), w.Head)
+	}
+}
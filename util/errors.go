@@ -0,0 +1,76 @@
+package util
+
+import (
+	"errors"
+)
+
+// ErrorKind tells which part of a command failed, every kind has its own exit code
+type ErrorKind int
+
+const (
+	GeneralError ErrorKind = iota
+	// ConfigError is a wrong option or a problem with the Deskpro config or the local database
+	ConfigError
+	// SourceError means the database or the files to restore from can't be reached
+	SourceError
+	// DumpError is a failure to dump or import a database
+	DumpError
+	// AttachmentError is a failure to read or write attachments
+	AttachmentError
+	// UpgradeError means the Deskpro upgrade after a restore failed
+	UpgradeError
+	// CanceledError means the command was interrupted with Ctrl-C or its context was canceled
+	CanceledError
+)
+
+var errorCodes = map[ErrorKind]string{
+	GeneralError:    "error",
+	ConfigError:     "config_error",
+	SourceError:     "source_error",
+	DumpError:       "dump_error",
+	AttachmentError: "attachment_error",
+	UpgradeError:    "upgrade_error",
+	CanceledError:   "canceled",
+}
+
+// ExitCode is the exit code of dputils when a command fails with this kind of error
+func (k ErrorKind) ExitCode() int {
+	return int(k) + 1
+}
+
+func (k ErrorKind) String() string {
+	return errorCodes[k]
+}
+
+// Error is a failure of a command. Message is what failed, in the words of the user, Err is the cause.
+type Error struct {
+	Kind    ErrorKind
+	Message string
+	Err     error
+}
+
+func NewError(kind ErrorKind, message string, err error) *Error {
+	return &Error{Kind: kind, Message: message, Err: err}
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+
+	return e.Message + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorKindOf returns the kind of the first Error in the chain, errors which aren't an Error are general errors
+func ErrorKindOf(err error) ErrorKind {
+	var dpErr *Error
+	if errors.As(err, &dpErr) {
+		return dpErr.Kind
+	}
+
+	return GeneralError
+}
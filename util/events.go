@@ -0,0 +1,23 @@
+package util
+
+// EventType is the type of a progress Event
+type EventType string
+
+const (
+	EventPhaseStarted  EventType = "phase_started"
+	EventPhaseFinished EventType = "phase_finished"
+	EventProgress      EventType = "progress"
+	EventWarning       EventType = "warning"
+)
+
+// Event reports the progress of a backup or a restore to the Progress callback of their options. Files and Bytes
+// are the totals processed so far in the phase, TotalFiles and TotalBytes are zero when they aren't known.
+type Event struct {
+	Type       EventType
+	Phase      string
+	Message    string
+	Files      int64
+	Bytes      int64
+	TotalFiles int64
+	TotalBytes int64
+}
@@ -0,0 +1,77 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// Lock is a lock file which stops two backups from running at the same time
+type Lock struct {
+	path string
+}
+
+// AcquireLock creates the lock file, it fails if the file exists. The file holds the pid of the process which
+// holds the lock, a lock left behind by a process which isn't running anymore is taken over.
+func AcquireLock(path string) (*Lock, error) {
+	lock, err := createLock(path)
+	if !os.IsExist(err) {
+		return lock, err
+	}
+
+	owner, _ := ioutil.ReadFile(path)
+	if pid, ok := lockOwner(owner); ok && !processRunning(pid) {
+		// another process may have taken over the stale lock meanwhile, only remove the lock which was read
+		if current, _ := ioutil.ReadFile(path); bytes.Equal(current, owner) {
+			if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+		lock, err = createLock(path)
+		if !os.IsExist(err) {
+			return lock, err
+		}
+		owner, _ = ioutil.ReadFile(path)
+	}
+
+	return nil, fmt.Errorf(
+		"%s is locked by %s, remove the file if that process isn't running anymore",
+		path,
+		strings.TrimSpace(string(owner)),
+	)
+}
+
+func createLock(path string) (*Lock, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = fmt.Fprintf(f, "pid %d since %s\n", os.Getpid(), time.Now().Format(time.RFC3339))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return nil, err
+	}
+
+	return &Lock{path: path}, nil
+}
+
+// lockOwner returns the pid written into a lock file by createLock
+func lockOwner(content []byte) (int, bool) {
+	var pid int
+	if _, err := fmt.Sscanf(string(content), "pid %d", &pid); err != nil || pid <= 0 {
+		return 0, false
+	}
+
+	return pid, true
+}
+
+func (l *Lock) Release() error {
+	return os.Remove(l.path)
+}
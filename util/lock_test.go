@@ -0,0 +1,65 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAcquireLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.lock")
+	lock, err := AcquireLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = AcquireLock(path); err == nil || !strings.Contains(err.Error(), fmt.Sprintf("pid %d", os.Getpid())) {
+		t.Errorf("Expected the lock to be held by this process, got %v", err)
+	}
+
+	if err = lock.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Error("The lock file wasn't removed")
+	}
+}
+
+func TestAcquireLockStale(t *testing.T) {
+	// the pid of a process which has exited
+	exited := exec.Command(os.Args[0], "-test.run=^$")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "backup.lock")
+	stale := fmt.Sprintf("pid %d since 2020-01-01T02:00:00Z\n", exited.ProcessState.Pid())
+	if err := os.WriteFile(path, []byte(stale), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lock, err := AcquireLock(path)
+	if err != nil {
+		t.Fatalf("The stale lock wasn't taken over: %s", err)
+	}
+	defer lock.Release()
+
+	content, _ := os.ReadFile(path)
+	if pid, ok := lockOwner(content); !ok || pid != os.Getpid() {
+		t.Errorf("Expected the lock to be held by this process, got %q", content)
+	}
+}
+
+func TestAcquireLockUnknownOwner(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.lock")
+	if err := os.WriteFile(path, []byte("locked by hand\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := AcquireLock(path); err == nil {
+		t.Error("A lock without a pid mustn't be taken over")
+	}
+}
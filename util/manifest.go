@@ -0,0 +1,220 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alexmullins/zip"
+)
+
+// ManifestName is the name of the archive entry describing a backup archive
+const ManifestName = "manifest.json"
+
+// Manifest describes the content of a backup archive. It's written as the last entry of the archive,
+// so it can record the size and checksum of every other entry.
+type Manifest struct {
+	Version     string               `json:"version"`
+	CreatedAt   time.Time            `json:"created_at"`
+	Host        string               `json:"host"`
+	DeskproPath string               `json:"deskpro_path"`
+	Encrypted   bool                 `json:"encrypted"`
+	Databases   []ManifestDatabase   `json:"databases"`
+	Attachments ManifestAttachments  `json:"attachments"`
+	Incremental *ManifestIncremental `json:"incremental,omitempty"`
+	Entries     []*ManifestEntry     `json:"entries"`
+	// Volumes are the parts of an archive split with --volume-size, they're only listed in the first volume
+	Volumes []ManifestVolume `json:"volumes,omitempty"`
+
+	current *manifestEntryWriter
+}
+
+// ManifestDatabase is a database dump stored in the archive. Type is "default" for the main Deskpro database
+// or the name of the advanced connection ("audit", "voice", "system").
+type ManifestDatabase struct {
+	Type    string             `json:"type"`
+	Name    string             `json:"name"`
+	Entry   string             `json:"entry"`
+	Profile string             `json:"profile,omitempty"`
+	Binlog  *BinlogCoordinates `json:"binlog,omitempty"`
+	// Tables is set if only the tables selected by the filter were dumped
+	Tables *TableFilter `json:"tables,omitempty"`
+}
+
+// ManifestAttachments describes attachments stored in the archive. LastBlobId is the last filesystem blob
+// that existed when the backup started, incremental backups include blobs added after it.
+type ManifestAttachments struct {
+	Included   bool  `json:"included"`
+	Files      int   `json:"files"`
+	Size       int64 `json:"size"`
+	LastBlobId int64 `json:"last_blob_id"`
+}
+
+// ManifestIncremental is set for archives which only contain attachments with ids above SinceBlobId
+type ManifestIncremental struct {
+	SinceBlobId int64 `json:"since_blob_id"`
+}
+
+type ManifestEntry struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+type manifestEntryWriter struct {
+	writer io.Writer
+	entry  *ManifestEntry
+	hash   hash.Hash
+}
+
+func (w *manifestEntryWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.hash.Write(p[:n])
+	w.entry.Size += int64(n)
+	return n, err
+}
+
+func NewManifest(version string, dpPath string, encrypted bool) *Manifest {
+	host, _ := os.Hostname()
+
+	return &Manifest{
+		Version:     version,
+		CreatedAt:   time.Now().UTC(),
+		Host:        host,
+		DeskproPath: dpPath,
+		Encrypted:   encrypted,
+		Databases:   []ManifestDatabase{},
+		Entries:     []*ManifestEntry{},
+	}
+}
+
+// CreateEntry adds a new entry to the archive, the same way as ZipCreate does, and records its size and
+// checksum into the manifest as the data is written
+func (m *Manifest) CreateEntry(writer *zip.Writer, name string, secret string) (io.Writer, error) {
+	m.finishEntry()
+
+	zipWriter, err := ZipCreate(writer, name, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &ManifestEntry{Name: name}
+	m.Entries = append(m.Entries, entry)
+	m.current = &manifestEntryWriter{writer: zipWriter, entry: entry, hash: sha256.New()}
+
+	return m.current, nil
+}
+
+func (m *Manifest) finishEntry() {
+	if m.current != nil {
+		m.current.entry.Sha256 = fmt.Sprintf("%x", m.current.hash.Sum(nil))
+		m.current = nil
+	}
+}
+
+// Entry returns the manifest record for the archive entry or nil if the archive doesn't contain it
+func (m *Manifest) Entry(name string) *ManifestEntry {
+	for _, entry := range m.Entries {
+		if entry.Name == name {
+			return entry
+		}
+	}
+
+	return nil
+}
+
+// Database returns the dump record for the database type or nil if the database wasn't dumped
+func (m *Manifest) Database(dbType string) *ManifestDatabase {
+	for i := range m.Databases {
+		if m.Databases[i].Type == dbType {
+			return &m.Databases[i]
+		}
+	}
+
+	return nil
+}
+
+// Save finalizes the manifest and stores it into the archive. The manifest itself is never encrypted so
+// an archive can be inspected without knowing the secret.
+func (m *Manifest) Save(writer *zip.Writer) error {
+	m.finishEntry()
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := writer.Create(ManifestName)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	return err
+}
+
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+
+	return &manifest, nil
+}
+
+// ReadZipManifest returns the manifest stored in the archive or nil for archives created by older versions
+func ReadZipManifest(reader *zip.Reader) (*Manifest, error) {
+	for _, f := range reader.File {
+		if f.Name != ManifestName {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+
+		return ReadManifest(rc)
+	}
+
+	return nil, nil
+}
+
+// ReadManifestFile reads a manifest from a backup archive or a manifest.json file, e.g. from an extracted
+// backup archive
+func ReadManifestFile(path string) (*Manifest, error) {
+	if strings.EqualFold(filepath.Ext(path), ".zip") {
+		reader, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+
+		manifest, err := ReadZipManifest(&reader.Reader)
+		if err == nil && manifest == nil {
+			err = fmt.Errorf("%s doesn't contain a %s", path, ManifestName)
+		}
+		return manifest, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadManifest(f)
+}
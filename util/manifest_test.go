@@ -0,0 +1,57 @@
+package util
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/alexmullins/zip"
+)
+
+func TestManifest_CreateEntry(t *testing.T) {
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+
+	manifest := NewManifest("v0.1", "/path/to/dp", false)
+	f, err := manifest.CreateEntry(writer, "database.sql", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte("hello "))
+	_, _ = f.Write([]byte("world"))
+	manifest.Databases = append(manifest.Databases, ManifestDatabase{Type: "default", Name: "deskpro", Entry: "database.sql"})
+
+	if err = manifest.Save(writer); err != nil {
+		t.Fatal(err)
+	}
+	_ = writer.Close()
+
+	reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual *Manifest
+	for _, f := range reader.File {
+		if f.Name == ManifestName {
+			rc, _ := f.Open()
+			actual, err = ReadManifest(rc)
+			_ = rc.Close()
+		}
+	}
+	if err != nil || actual == nil {
+		t.Fatalf("Manifest wasn't stored in the archive: %v", err)
+	}
+
+	entry := actual.Entry("database.sql")
+	expectedHash := "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+	if entry == nil || entry.Size != 11 || entry.Sha256 != expectedHash {
+		t.Errorf("Unexpected manifest entry %+v", entry)
+	}
+
+	if db := actual.Database("default"); db == nil || db.Name != "deskpro" {
+		t.Errorf("Unexpected manifest database %+v", db)
+	}
+	if actual.Database("audit") != nil {
+		t.Error("Audit database wasn't dumped but is listed in the manifest")
+	}
+}
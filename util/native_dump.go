@@ -0,0 +1,456 @@
+package util
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxInsertSize is the size after which an extended INSERT statement is split, mysqldump uses a similar limit
+// so the statements fit into the default max_allowed_packet of the server
+const maxInsertSize = 1024 * 1024
+
+// NativeDumpOptions mirror the mysqldump options used by dump profiles
+type NativeDumpOptions struct {
+	// Consistent dumps every table within a single transaction and records the binary log coordinates,
+	// like mysqldump --single-transaction --source-data=2
+	Consistent bool
+	// Routines includes triggers, stored procedures and functions and events
+	Routines bool
+	// Tables selects the tables and views which are dumped, every one if it's zero
+	Tables TableFilter
+	// Warning receives the problems which don't fail the dump, they're discarded if it's nil
+	Warning func(message string)
+}
+
+func (o NativeDumpOptions) warn(message string) {
+	if o.Warning != nil {
+		o.Warning(message)
+	}
+}
+
+// NativeDump writes an SQL dump of every table and view in the database. The output has the same structure as
+// mysqldump output, so it can be imported with the mysql client as well as with NativeImport. Canceling the
+// context stops the dump.
+func NativeDump(ctx context.Context, db *sql.DB, dbName string, opts NativeDumpOptions, w io.Writer) error {
+	// session variables must be set on the same connection the data is read from
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, query := range []string{"SET NAMES utf8mb4", "SET time_zone = '+00:00'"} {
+		if _, err = conn.ExecContext(ctx, query); err != nil {
+			return err
+		}
+	}
+
+	out := bufio.NewWriterSize(w, 64*1024)
+
+	fmt.Fprintf(out, "-- MySQL dump 10.13  Distrib dputils native engine\n")
+	fmt.Fprintf(out, "--\n-- Database: %s\n", dbName)
+	fmt.Fprintf(out, "-- ------------------------------------------------------\n\n")
+	out.WriteString(nativeDumpHeader)
+
+	if opts.Consistent {
+		coordinates, err := startConsistentSnapshot(ctx, conn, opts)
+		if err != nil {
+			return err
+		}
+		if coordinates != nil {
+			fmt.Fprintf(out, "\n--\n-- Position to start replication or point-in-time recovery from\n--\n\n")
+			fmt.Fprintf(out, "-- CHANGE MASTER TO MASTER_LOG_FILE='%s', MASTER_LOG_POS=%d;\n", coordinates.File, coordinates.Position)
+			if coordinates.GtidSet != "" {
+				fmt.Fprintf(out, "-- SET @@GLOBAL.GTID_PURGED='%s';\n", coordinates.GtidSet)
+			}
+			out.WriteString("\n")
+		}
+	}
+
+	tables, views, err := listTables(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if !opts.Tables.IsZero() {
+		tables, views = opts.Tables.Filter(tables), opts.Tables.Filter(views)
+	}
+
+	for _, table := range tables {
+		if err = dumpTable(ctx, conn, table, out); err != nil {
+			return fmt.Errorf("failed to dump table %s: %s", table, err)
+		}
+		if opts.Routines {
+			if err = dumpTriggers(ctx, conn, table, out); err != nil {
+				return fmt.Errorf("failed to dump triggers of table %s: %s", table, err)
+			}
+		}
+	}
+
+	for _, view := range views {
+		if err = dumpView(ctx, conn, view, out); err != nil {
+			return fmt.Errorf("failed to dump view %s: %s", view, err)
+		}
+	}
+
+	if opts.Routines {
+		// the same section comment as mysqldump, so FilterDump knows the routines don't belong to the last table
+		fmt.Fprintf(out, "--\n-- Dumping routines for database %s\n--\n\n", QuoteString(dbName))
+		if err = dumpRoutines(ctx, conn, out); err != nil {
+			return fmt.Errorf("failed to dump routines: %s", err)
+		}
+	}
+
+	if opts.Consistent {
+		if _, err = conn.ExecContext(ctx, "COMMIT"); err != nil {
+			return err
+		}
+	}
+
+	out.WriteString(nativeDumpFooter)
+	fmt.Fprintf(out, "-- Dump completed on %s\n", time.Now().Format("2006-01-02 15:04:05"))
+
+	return out.Flush()
+}
+
+const nativeDumpHeader = `/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;
+/*!40101 SET @OLD_CHARACTER_SET_RESULTS=@@CHARACTER_SET_RESULTS */;
+/*!40101 SET @OLD_COLLATION_CONNECTION=@@COLLATION_CONNECTION */;
+/*!40101 SET NAMES utf8mb4 */;
+/*!40103 SET @OLD_TIME_ZONE=@@TIME_ZONE */;
+/*!40103 SET TIME_ZONE='+00:00' */;
+/*!40014 SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0 */;
+/*!40014 SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0 */;
+/*!40101 SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='NO_AUTO_VALUE_ON_ZERO' */;
+/*!40111 SET @OLD_SQL_NOTES=@@SQL_NOTES, SQL_NOTES=0 */;
+`
+
+const nativeDumpFooter = `
+/*!40103 SET TIME_ZONE=@OLD_TIME_ZONE */;
+/*!40101 SET SQL_MODE=@OLD_SQL_MODE */;
+/*!40014 SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS */;
+/*!40014 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS */;
+/*!40101 SET CHARACTER_SET_CLIENT=@OLD_CHARACTER_SET_CLIENT */;
+/*!40101 SET CHARACTER_SET_RESULTS=@OLD_CHARACTER_SET_RESULTS */;
+/*!40101 SET COLLATION_CONNECTION=@OLD_COLLATION_CONNECTION */;
+/*!40111 SET SQL_NOTES=@OLD_SQL_NOTES */;
+
+`
+
+// startConsistentSnapshot starts a transaction all tables are read in and returns the binary log coordinates
+// of the snapshot. Tables are briefly locked to read the coordinates, it requires the RELOAD privilege. Without it,
+// or if the coordinates can't be read, the dump is still consistent but the coordinates aren't recorded.
+func startConsistentSnapshot(ctx context.Context, conn *sql.Conn, opts NativeDumpOptions) (*BinlogCoordinates, error) {
+	_, lockErr := conn.ExecContext(ctx, "FLUSH TABLES WITH READ LOCK")
+
+	for _, query := range []string{
+		"SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ",
+		"START TRANSACTION /*!40100 WITH CONSISTENT SNAPSHOT */",
+	} {
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			return nil, err
+		}
+	}
+
+	if lockErr != nil {
+		opts.warn(fmt.Sprintf("Can't lock the tables to read the binary log coordinates, they aren't recorded in the dump: %s", lockErr))
+		return nil, nil
+	}
+
+	coordinates, err := showMasterStatus(ctx, conn)
+	if err != nil {
+		opts.warn(fmt.Sprintf("Can't read the binary log coordinates, they aren't recorded in the dump: %s", err))
+		coordinates = nil
+	}
+
+	_, err = conn.ExecContext(ctx, "UNLOCK TABLES")
+
+	return coordinates, err
+}
+
+// showMasterStatus returns the current binary log coordinates or nil if binary logging is disabled. MySQL 8.4
+// removed SHOW MASTER STATUS in favour of SHOW BINARY LOG STATUS, which older servers don't know.
+func showMasterStatus(ctx context.Context, conn *sql.Conn) (*BinlogCoordinates, error) {
+	status, err := queryRowMap(ctx, conn, "SHOW BINARY LOG STATUS")
+	if err != nil {
+		status, err = queryRowMap(ctx, conn, "SHOW MASTER STATUS")
+	}
+	if err != nil || status == nil {
+		return nil, err
+	}
+
+	position, _ := strconv.ParseInt(status["Position"], 10, 64)
+
+	return &BinlogCoordinates{
+		File:     status["File"],
+		Position: position,
+		GtidSet:  strings.Replace(status["Executed_Gtid_Set"], "\n", "", -1),
+	}, nil
+}
+
+// queryRowMap returns the first row of the result as a map of column names to values. It's used for SHOW
+// statements which return different columns depending on the server version.
+func queryRowMap(ctx context.Context, conn *sql.Conn, query string) (map[string]string, error) {
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	values := make([]sql.NullString, len(columns))
+	scanArgs := make([]interface{}, len(values))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+	if err = rows.Scan(scanArgs...); err != nil {
+		return nil, err
+	}
+
+	row := map[string]string{}
+	for i, column := range columns {
+		row[column] = values[i].String
+	}
+
+	return row, nil
+}
+
+func queryNames(ctx context.Context, conn *sql.Conn, query string) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+func writeRoutine(out *bufio.Writer, kind string, name string, create string) {
+	fmt.Fprintf(out, "--\n-- Dumping %s %s\n--\n\n", kind, QuoteIdentifier(name))
+	fmt.Fprintf(out, "DELIMITER ;;\n%s ;;\nDELIMITER ;\n\n", create)
+}
+
+func dumpTriggers(ctx context.Context, conn *sql.Conn, table string, out *bufio.Writer) error {
+	triggers, err := queryNames(ctx, conn, "SELECT TRIGGER_NAME FROM information_schema.TRIGGERS WHERE EVENT_OBJECT_SCHEMA = DATABASE() AND EVENT_OBJECT_TABLE = "+QuoteString(table))
+	if err != nil {
+		return err
+	}
+
+	for _, trigger := range triggers {
+		create, err := queryRowMap(ctx, conn, "SHOW CREATE TRIGGER "+QuoteIdentifier(trigger))
+		if err != nil {
+			return err
+		}
+		writeRoutine(out, "trigger", trigger, create["SQL Original Statement"])
+	}
+
+	return nil
+}
+
+func dumpRoutines(ctx context.Context, conn *sql.Conn, out *bufio.Writer) error {
+	kinds := []struct {
+		kind   string
+		list   string
+		column string
+	}{
+		{"PROCEDURE", "SELECT ROUTINE_NAME FROM information_schema.ROUTINES WHERE ROUTINE_SCHEMA = DATABASE() AND ROUTINE_TYPE = 'PROCEDURE'", "Create Procedure"},
+		{"FUNCTION", "SELECT ROUTINE_NAME FROM information_schema.ROUTINES WHERE ROUTINE_SCHEMA = DATABASE() AND ROUTINE_TYPE = 'FUNCTION'", "Create Function"},
+		{"EVENT", "SELECT EVENT_NAME FROM information_schema.EVENTS WHERE EVENT_SCHEMA = DATABASE()", "Create Event"},
+	}
+
+	for _, k := range kinds {
+		names, err := queryNames(ctx, conn, k.list)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			create, err := queryRowMap(ctx, conn, "SHOW CREATE "+k.kind+" "+QuoteIdentifier(name))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "/*!50003 DROP %s IF EXISTS %s */;\n", k.kind, QuoteIdentifier(name))
+			writeRoutine(out, strings.ToLower(k.kind), name, create[k.column])
+		}
+	}
+
+	return nil
+}
+
+func listTables(ctx context.Context, conn *sql.Conn) ([]string, []string, error) {
+	rows, err := conn.QueryContext(ctx, "SHOW FULL TABLES")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var tables, views []string
+	for rows.Next() {
+		var name, tableType string
+		if err = rows.Scan(&name, &tableType); err != nil {
+			return nil, nil, err
+		}
+		if tableType == "VIEW" {
+			views = append(views, name)
+		} else {
+			tables = append(tables, name)
+		}
+	}
+
+	return tables, views, rows.Err()
+}
+
+func dumpTable(ctx context.Context, conn *sql.Conn, table string, out *bufio.Writer) error {
+	var name, createTable string
+	if err := conn.QueryRowContext(ctx, "SHOW CREATE TABLE "+QuoteIdentifier(table)).Scan(&name, &createTable); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "--\n-- Table structure for table %s\n--\n\n", QuoteIdentifier(table))
+	fmt.Fprintf(out, "DROP TABLE IF EXISTS %s;\n", QuoteIdentifier(table))
+	fmt.Fprintf(out, "%s;\n\n", createTable)
+
+	rows, err := conn.QueryContext(ctx, "SELECT * FROM "+QuoteIdentifier(table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "--\n-- Dumping data for table %s\n--\n\n", QuoteIdentifier(table))
+	fmt.Fprintf(out, "LOCK TABLES %s WRITE;\n", QuoteIdentifier(table))
+	fmt.Fprintf(out, "/*!40000 ALTER TABLE %s DISABLE KEYS */;\n", QuoteIdentifier(table))
+
+	values := make([]sql.RawBytes, len(columnTypes))
+	scanArgs := make([]interface{}, len(values))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+
+	var (
+		statement strings.Builder
+		row       strings.Builder
+	)
+	for rows.Next() {
+		if err = rows.Scan(scanArgs...); err != nil {
+			return err
+		}
+
+		row.Reset()
+		row.WriteString("(")
+		for i, value := range values {
+			if i > 0 {
+				row.WriteString(",")
+			}
+			row.WriteString(sqlValue(value, columnTypes[i].DatabaseTypeName()))
+		}
+		row.WriteString(")")
+
+		if statement.Len() > 0 && statement.Len()+row.Len() > maxInsertSize {
+			out.WriteString(statement.String())
+			out.WriteString(";\n")
+			statement.Reset()
+		}
+
+		if statement.Len() == 0 {
+			statement.WriteString("INSERT INTO " + QuoteIdentifier(table) + " VALUES ")
+		} else {
+			statement.WriteString(",")
+		}
+		statement.WriteString(row.String())
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if statement.Len() > 0 {
+		out.WriteString(statement.String())
+		out.WriteString(";\n")
+	}
+
+	fmt.Fprintf(out, "/*!40000 ALTER TABLE %s ENABLE KEYS */;\n", QuoteIdentifier(table))
+	out.WriteString("UNLOCK TABLES;\n\n")
+
+	return nil
+}
+
+func dumpView(ctx context.Context, conn *sql.Conn, view string, out *bufio.Writer) error {
+	var name, createView, charset, collation string
+	if err := conn.QueryRowContext(ctx, "SHOW CREATE VIEW "+QuoteIdentifier(view)).Scan(&name, &createView, &charset, &collation); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "--\n-- View structure for view %s\n--\n\n", QuoteIdentifier(view))
+	fmt.Fprintf(out, "DROP VIEW IF EXISTS %s;\n", QuoteIdentifier(view))
+	fmt.Fprintf(out, "%s;\n\n", createView)
+
+	return nil
+}
+
+// QuoteIdentifier quotes a table or column name with backticks
+func QuoteIdentifier(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+// sqlValue formats a column value as an SQL literal. Binary values are written as hex literals,
+// the same way mysqldump does with --hex-blob.
+func sqlValue(value sql.RawBytes, databaseType string) string {
+	if value == nil {
+		return "NULL"
+	}
+
+	switch databaseType {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "DECIMAL", "FLOAT", "DOUBLE", "YEAR",
+		"UNSIGNED TINYINT", "UNSIGNED SMALLINT", "UNSIGNED MEDIUMINT", "UNSIGNED INT", "UNSIGNED BIGINT":
+		return string(value)
+	case "BINARY", "VARBINARY", "TINYBLOB", "BLOB", "MEDIUMBLOB", "LONGBLOB", "BIT", "GEOMETRY":
+		if len(value) == 0 {
+			return "''"
+		}
+		return "0x" + hex.EncodeToString(value)
+	}
+
+	return QuoteString(string(value))
+}
+
+var stringEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"\x00", "\\0",
+	"\n", "\\n",
+	"\r", "\\r",
+	"\x1a", "\\Z",
+	"'", "\\'",
+	"\"", "\\\"",
+)
+
+// QuoteString quotes a string literal, escaping special characters the same way the mysql client does
+func QuoteString(value string) string {
+	return "'" + stringEscaper.Replace(value) + "'"
+}
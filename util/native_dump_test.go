@@ -0,0 +1,164 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestNativeDump(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("SET NAMES utf8mb4").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SET time_zone = '+00:00'").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SHOW FULL TABLES").WillReturnRows(
+		sqlmock.NewRows([]string{"Tables_in_deskpro", "Table_type"}).AddRow("people", "BASE TABLE"),
+	)
+	mock.ExpectQuery("SHOW CREATE TABLE `people`").WillReturnRows(
+		sqlmock.NewRows([]string{"Table", "Create Table"}).AddRow("people", "CREATE TABLE `people` (`id` int)"),
+	)
+	mock.ExpectQuery("SELECT * FROM `people`").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "name"}).AddRow("1", "O'Brien\n").AddRow("2", nil),
+	)
+
+	var out bytes.Buffer
+	if err = NativeDump(context.Background(), db, "deskpro", NativeDumpOptions{}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	dump := out.String()
+	for _, expected := range []string{
+		"-- MySQL dump",
+		"DROP TABLE IF EXISTS `people`;\nCREATE TABLE `people` (`id` int);",
+		"INSERT INTO `people` VALUES ('1','O\\'Brien\\n'),('2',NULL);",
+		"-- Dump completed on ",
+	} {
+		if !strings.Contains(dump, expected) {
+			t.Errorf("Expected dump to contain %q, dump was:\n%s", expected, dump)
+		}
+	}
+
+	info, _ := ScanDump(strings.NewReader(dump))
+	if !info.Header || !info.Completed || info.Tables != 1 {
+		t.Errorf("Native dump isn't recognized as a complete dump: %+v", info)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func Test_startConsistentSnapshot(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var warnings []string
+	opts := NativeDumpOptions{Warning: func(message string) { warnings = append(warnings, message) }}
+	expectSnapshot := func() {
+		mock.ExpectExec("SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("START TRANSACTION /*!40100 WITH CONSISTENT SNAPSHOT */").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+
+	// MySQL 8.4 and later
+	mock.ExpectExec("FLUSH TABLES WITH READ LOCK").WillReturnResult(sqlmock.NewResult(0, 0))
+	expectSnapshot()
+	mock.ExpectQuery("SHOW BINARY LOG STATUS").WillReturnRows(
+		sqlmock.NewRows([]string{"File", "Position", "Executed_Gtid_Set"}).AddRow("binlog.000003", "157", ""),
+	)
+	mock.ExpectExec("UNLOCK TABLES").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	coordinates, err := startConsistentSnapshot(context.Background(), conn, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if coordinates == nil || coordinates.File != "binlog.000003" || coordinates.Position != 157 {
+		t.Errorf("Unexpected coordinates %+v", coordinates)
+	}
+
+	// older servers don't know SHOW BINARY LOG STATUS
+	mock.ExpectExec("FLUSH TABLES WITH READ LOCK").WillReturnResult(sqlmock.NewResult(0, 0))
+	expectSnapshot()
+	mock.ExpectQuery("SHOW BINARY LOG STATUS").WillReturnError(errors.New("You have an error in your SQL syntax"))
+	mock.ExpectQuery("SHOW MASTER STATUS").WillReturnRows(
+		sqlmock.NewRows([]string{"File", "Position"}).AddRow("mysql-bin.000012", "4"),
+	)
+	mock.ExpectExec("UNLOCK TABLES").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	coordinates, err = startConsistentSnapshot(context.Background(), conn, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if coordinates == nil || coordinates.File != "mysql-bin.000012" || coordinates.Position != 4 {
+		t.Errorf("Unexpected coordinates %+v", coordinates)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("Unexpected warnings %v", warnings)
+	}
+
+	// coordinates which can't be read are skipped with a warning
+	mock.ExpectExec("FLUSH TABLES WITH READ LOCK").WillReturnResult(sqlmock.NewResult(0, 0))
+	expectSnapshot()
+	mock.ExpectQuery("SHOW BINARY LOG STATUS").WillReturnError(errors.New("Access denied"))
+	mock.ExpectQuery("SHOW MASTER STATUS").WillReturnError(errors.New("Access denied"))
+	mock.ExpectExec("UNLOCK TABLES").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	coordinates, err = startConsistentSnapshot(context.Background(), conn, opts)
+	if err != nil || coordinates != nil {
+		t.Errorf("Expected the coordinates to be skipped, were %+v, %v", coordinates, err)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("Expected a warning, were %v", warnings)
+	}
+
+	// without the RELOAD privilege the tables can't be locked
+	warnings = nil
+	mock.ExpectExec("FLUSH TABLES WITH READ LOCK").WillReturnError(errors.New("Access denied; you need the RELOAD privilege"))
+	expectSnapshot()
+
+	coordinates, err = startConsistentSnapshot(context.Background(), conn, opts)
+	if err != nil || coordinates != nil {
+		t.Errorf("Expected the coordinates to be skipped, were %+v, %v", coordinates, err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "RELOAD") {
+		t.Errorf("Expected a warning about the lock, were %v", warnings)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSqlValue(t *testing.T) {
+	cases := []struct {
+		value        []byte
+		databaseType string
+		expected     string
+	}{
+		{nil, "VARCHAR", "NULL"},
+		{[]byte("42"), "UNSIGNED INT", "42"},
+		{[]byte{0, 255}, "BLOB", "0x00ff"},
+		{[]byte("a\\b\x00"), "TEXT", `'a\\b\0'`},
+	}
+
+	for _, c := range cases {
+		if actual := sqlValue(c.value, c.databaseType); actual != c.expected {
+			t.Errorf("Expected {%s} was {%s}", c.expected, actual)
+		}
+	}
+}
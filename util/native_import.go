@@ -0,0 +1,219 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// NativeImport executes every statement of an SQL dump (e.g. one created by mysqldump or NativeDump) on a single
+// connection, the same way the mysql client does with "source dump.sql". Canceling the context stops the import
+// before the next statement.
+func NativeImport(ctx context.Context, db *sql.DB, r io.Reader) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	statements := NewStatementReader(r)
+	for {
+		statement, err := statements.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err = conn.ExecContext(ctx, statement); err != nil {
+			if len(statement) > 200 {
+				statement = statement[:200] + "..."
+			}
+			return fmt.Errorf("%s in statement: %s", err, statement)
+		}
+	}
+}
+
+// StatementReader splits an SQL script into statements. It understands quoted strings and identifiers, comments
+// and the DELIMITER command used by mysqldump for stored routines and triggers. Conditional comments
+// (/*!40101 ... */) are kept because the server executes them.
+type StatementReader struct {
+	reader    *bufio.Reader
+	delimiter string
+}
+
+func NewStatementReader(r io.Reader) *StatementReader {
+	return &StatementReader{reader: bufio.NewReaderSize(r, 64*1024), delimiter: ";"}
+}
+
+// Next returns the next statement without the trailing delimiter, or io.EOF when the script ends
+func (s *StatementReader) Next() (string, error) {
+	var (
+		statement bytes.Buffer
+		lineStart = true
+	)
+
+	for {
+		c, err := s.reader.ReadByte()
+		if err == io.EOF {
+			if rest := strings.TrimSpace(statement.String()); rest != "" {
+				return rest, nil
+			}
+			return "", io.EOF
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if lineStart && statement.Len() == 0 && (c == 'D' || c == 'd') {
+			if handled, err := s.readDelimiterCommand(); handled || err != nil {
+				if err != nil {
+					return "", err
+				}
+				continue
+			}
+		}
+
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			statement.WriteByte(c)
+			if err = s.readQuoted(c, &statement); err != nil {
+				return "", err
+			}
+
+		case c == '#' || (c == '-' && s.peekIs("- ", "-\n", "-\r", "-\t")):
+			if err = s.skipLine(); err != nil && err != io.EOF {
+				return "", err
+			}
+			c = '\n'
+			if statement.Len() > 0 {
+				statement.WriteByte(c)
+			}
+
+		case c == '/' && s.peekIs("*"):
+			if s.peekIs("*!") {
+				statement.WriteByte(c)
+				if err = s.readComment(&statement); err != nil {
+					return "", err
+				}
+			} else if err = s.readComment(nil); err != nil {
+				return "", err
+			}
+
+		default:
+			statement.WriteByte(c)
+			if bytes.HasSuffix(statement.Bytes(), []byte(s.delimiter)) {
+				statement.Truncate(statement.Len() - len(s.delimiter))
+				if result := strings.TrimSpace(statement.String()); result != "" {
+					return result, nil
+				}
+				statement.Reset()
+			}
+		}
+
+		lineStart = c == '\n'
+		if lineStart && len(bytes.TrimSpace(statement.Bytes())) == 0 {
+			statement.Reset()
+		}
+	}
+}
+
+// readDelimiterCommand handles a "DELIMITER xx" line. The first character of the line is already consumed.
+func (s *StatementReader) readDelimiterCommand() (bool, error) {
+	peek, err := s.reader.Peek(len("ELIMITER "))
+	if err != nil || !strings.EqualFold(string(peek[:8]), "ELIMITER") || (peek[8] != ' ' && peek[8] != '\t') {
+		return false, nil
+	}
+
+	line, err := s.reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return true, err
+	}
+
+	delimiter := strings.TrimSpace(line[len("ELIMITER "):])
+	if delimiter == "" {
+		return true, fmt.Errorf("empty DELIMITER command")
+	}
+	s.delimiter = delimiter
+
+	return true, nil
+}
+
+func (s *StatementReader) peekIs(prefixes ...string) bool {
+	for _, prefix := range prefixes {
+		peek, err := s.reader.Peek(len(prefix))
+		if err == nil && string(peek) == prefix {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *StatementReader) readQuoted(quote byte, statement *bytes.Buffer) error {
+	for {
+		c, err := s.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		statement.WriteByte(c)
+
+		if c == '\\' && quote != '`' {
+			c, err = s.reader.ReadByte()
+			if err != nil {
+				return err
+			}
+			statement.WriteByte(c)
+			continue
+		}
+
+		if c == quote {
+			// a doubled quote is an escaped quote
+			if s.peekIs(string(quote)) {
+				c, _ = s.reader.ReadByte()
+				statement.WriteByte(c)
+				continue
+			}
+			return nil
+		}
+	}
+}
+
+// readComment reads a /* */ comment, the opening slash is already consumed. The comment is written to the
+// statement if it's not nil.
+func (s *StatementReader) readComment(statement *bytes.Buffer) error {
+	var prev byte
+
+	// the opening asterisk can't be the part of the closing sequence
+	c, err := s.reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	if statement != nil {
+		statement.WriteByte(c)
+	}
+
+	for {
+		c, err := s.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		if statement != nil {
+			statement.WriteByte(c)
+		}
+		if prev == '*' && c == '/' {
+			return nil
+		}
+		prev = c
+	}
+}
+
+func (s *StatementReader) skipLine() error {
+	_, err := s.reader.ReadString('\n')
+	return err
+}
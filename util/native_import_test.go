@@ -0,0 +1,88 @@
+package util
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestStatementReader(t *testing.T) {
+	script := `-- MySQL dump 10.13
+/*!40101 SET NAMES utf8 */;
+/* a comment; with a delimiter */
+CREATE TABLE ` + "`a;b`" + ` (
+  id int -- trailing comment;
+);
+INSERT INTO t VALUES ('it''s; fine','back\'slash;\\'),("double;");
+DELIMITER ;;
+CREATE TRIGGER tr BEFORE INSERT ON t FOR EACH ROW BEGIN SET @a = 1; END ;;
+DELIMITER ;
+SELECT 1`
+
+	expected := []string{
+		"/*!40101 SET NAMES utf8 */",
+		"CREATE TABLE `a;b` (\n  id int \n)",
+		`INSERT INTO t VALUES ('it''s; fine','back\'slash;\\'),("double;")`,
+		"CREATE TRIGGER tr BEFORE INSERT ON t FOR EACH ROW BEGIN SET @a = 1; END",
+		"SELECT 1",
+	}
+
+	var actual []string
+	reader := NewStatementReader(strings.NewReader(script))
+	for {
+		statement, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual = append(actual, statement)
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %q was %q", expected, actual)
+	}
+}
+
+func TestNativeImport(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("DROP TABLE IF EXISTS `people`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO `people` VALUES (1,'a')").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = NativeImport(context.Background(), db, strings.NewReader("DROP TABLE IF EXISTS `people`;\nINSERT INTO `people` VALUES (1,'a');\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestNativeImportCanceled(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err = NativeImport(ctx, db, strings.NewReader("DROP TABLE `people`;\n")); err == nil {
+		t.Error("Expected the canceled import to fail")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
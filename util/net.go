@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"github.com/manifoldco/promptui"
 	"net/url"
-	"os"
 )
 
 type MysqlConn struct {
@@ -13,40 +12,51 @@ type MysqlConn struct {
 	Conn     *sql.DB
 }
 
-func GetMysqlUrlFromUriString(uri string) url.URL {
+// GetMysqlUrlFromUriString parses the user:password@host/dbname string of --mysql-direct, the password is
+// prompted for if it's empty
+func GetMysqlUrlFromUriString(uri string) (url.URL, error) {
+	return GetMysqlUrlFromOption("--mysql-direct", uri)
+}
+
+// GetMysqlUrlFromOption parses a user:password@host/dbname string given with the option, which is named in errors
+func GetMysqlUrlFromOption(option string, uri string) (url.URL, error) {
 	murl, err := url.Parse("mysql://" + uri)
 
 	if err != nil {
-		fmt.Println("--mysql-direct: Invalid MySQL URI string")
-		fmt.Println(err)
-		os.Exit(1)
+		return url.URL{}, NewError(ConfigError, option+": Invalid MySQL URI string", err)
 	}
 
 	if len(murl.User.Username()) < 1 {
-		fmt.Println("--mysql-direct: Username is missing")
-		os.Exit(1)
+		return url.URL{}, NewError(ConfigError, option+": Username is missing", nil)
 	}
 
 	//var pass string
-	pass, _ := murl.User.Password()
+	pass, passSet := murl.User.Password()
+
+	// an empty password can still be given as user:@host
+	if !passSet && NoInput {
+		return url.URL{}, NewError(ConfigError, option+": Password is missing, add it to the URI or use an environment variable in the --config file", nil)
+	}
 
-	if len(pass) < 1 {
+	if len(pass) < 1 && !NoInput {
 		prompt := promptui.Prompt{
 			Label: "MySQL Password (just hit enter if empty)",
 			Mask:  '*',
 		}
 
-		pass, err = prompt.Run()
+		if pass, err = prompt.Run(); err != nil {
+			return url.URL{}, NewError(ConfigError, option+": Password prompt failed", err)
+		}
 	}
 
-	return *(&url.URL{
+	return url.URL{
 		Scheme:   "mysql",
 		User:     url.UserPassword(murl.User.Username(), pass),
 		Host:     murl.Host,
 		Path:     murl.Path,
 		RawPath:  murl.RawPath,
 		RawQuery: murl.RawQuery,
-	})
+	}, nil
 }
 
 func GetMysqlConnection(murl url.URL) (*sql.DB, error) {
@@ -75,18 +85,13 @@ func GetMysqlConnectionFromConfig(dpConfig map[string]string, prefix string) (*s
 	return GetMysqlConnection(GetMysqlUrlFromConfig(dpConfig, prefix))
 }
 
+// GetMysqlUrlFromConfig builds the URL of a database from the Deskpro config, prefix is "database" or
+// "database_advanced.<type>"
 func GetMysqlUrlFromConfig(dpConfig map[string]string, prefix string) url.URL {
-	murl, err := url.Parse(
-		"mysql://" + url.QueryEscape(dpConfig[prefix + ".user"]) +
-			":" + url.QueryEscape(dpConfig[prefix + ".password"]) +
-			"@" + dpConfig[prefix + ".host"] +
-			"/" + dpConfig[prefix + ".dbname"])
-
-	if err != nil {
-		fmt.Println("Database connection in config.database.php is invalid or corrupt")
-		fmt.Println(err)
-		os.Exit(1)
+	return url.URL{
+		Scheme: "mysql",
+		User:   url.UserPassword(dpConfig[prefix+".user"], dpConfig[prefix+".password"]),
+		Host:   dpConfig[prefix+".host"],
+		Path:   "/" + dpConfig[prefix+".dbname"],
 	}
-
-	return *murl
 }
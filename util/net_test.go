@@ -27,7 +27,10 @@ func TestGetMysqlUrlFromUriString(t *testing.T) {
 		"deskpro",
 	)
 
-	actualUrl := GetMysqlUrlFromUriString(mysqlUri)
+	actualUrl, err := GetMysqlUrlFromUriString(mysqlUri)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	fmt.Println(actualUrl.RawQuery, actualUrl.RawPath)
 
@@ -37,6 +40,18 @@ func TestGetMysqlUrlFromUriString(t *testing.T) {
 
 }
 
+func TestGetMysqlUrlFromUriStringErrors(t *testing.T) {
+	if _, err := GetMysqlUrlFromUriString("localhost/deskpro"); ErrorKindOf(err) != ConfigError {
+		t.Errorf("Missing username should be a config error, got %v", err)
+	}
+
+	NoInput = true
+	defer func() { NoInput = false }()
+	if _, err := GetMysqlUrlFromUriString("deskpro@localhost/deskpro"); ErrorKindOf(err) != ConfigError {
+		t.Errorf("Missing password with --no-input should be a config error, got %v", err)
+	}
+}
+
 func TestGetMysqlUrlFromConfig(t *testing.T) {
 
 	dpConfig := map[string]string{
@@ -0,0 +1,12 @@
+//go:build !windows
+
+package util
+
+import "syscall"
+
+// processRunning tells if a process with the pid exists. A process of another user counts as running.
+func processRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+
+	return err == nil || err == syscall.EPERM
+}
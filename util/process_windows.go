@@ -0,0 +1,22 @@
+package util
+
+import "golang.org/x/sys/windows"
+
+// stillActive is the exit code GetExitCodeProcess returns while the process runs
+const stillActive = 259
+
+// processRunning tells if a process with the pid exists. A process which can't be opened counts as running.
+func processRunning(pid int) bool {
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return err == windows.ERROR_ACCESS_DENIED
+	}
+	defer windows.CloseHandle(handle)
+
+	var code uint32
+	if err = windows.GetExitCodeProcess(handle, &code); err != nil {
+		return true
+	}
+
+	return code == stillActive
+}
@@ -0,0 +1,102 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BackupArchivePrefix is the prefix of the name of backup archives saved into a target dir
+const BackupArchivePrefix = "deskpro-backup"
+
+const backupArchiveTimeFormat = "2006-01-02_15-04-05"
+
+// BackupArchiveName is the name of an archive saved into a target dir, e.g. deskpro-backup.2021-02-01_10-00-00.zip
+func BackupArchiveName(prefix string, t time.Time) string {
+	return prefix + "." + t.Format(backupArchiveTimeFormat) + ".zip"
+}
+
+// ParseBackupArchiveName returns the time an archive named by BackupArchiveName with the prefix, or a volume of
+// it, was created at. It returns false for other files.
+func ParseBackupArchiveName(prefix string, name string) (time.Time, bool) {
+	if archive, _, ok := VolumeArchive(name); ok {
+		name = archive
+	}
+	if !strings.HasPrefix(name, prefix+".") || !strings.HasSuffix(name, ".zip") {
+		return time.Time{}, false
+	}
+
+	created, err := time.ParseInLocation(
+		backupArchiveTimeFormat,
+		strings.TrimSuffix(strings.TrimPrefix(name, prefix+"."), ".zip"),
+		time.Local,
+	)
+
+	return created, err == nil
+}
+
+// RetentionPolicy keeps the newest archive of each of the last Daily days, Weekly weeks and Monthly months
+// (grandfather-father-son rotation). A zero policy keeps everything.
+type RetentionPolicy struct {
+	Daily   int
+	Weekly  int
+	Monthly int
+}
+
+func (p RetentionPolicy) IsZero() bool {
+	return p.Daily <= 0 && p.Weekly <= 0 && p.Monthly <= 0
+}
+
+// BackupArchive is an archive found in a target dir, Files are the names of its volumes or just Name
+type BackupArchive struct {
+	Name    string
+	Created time.Time
+	Files   []string
+}
+
+// ExpiredArchives returns the archives which aren't kept by the policy, the oldest first
+func ExpiredArchives(archives []BackupArchive, policy RetentionPolicy) []BackupArchive {
+	if policy.IsZero() {
+		return nil
+	}
+
+	sorted := make([]BackupArchive, len(archives))
+	copy(sorted, archives)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Created.After(sorted[j].Created)
+	})
+
+	kept := map[string]bool{}
+	keep := func(count int, period func(t time.Time) string) {
+		periods := map[string]bool{}
+		for _, archive := range sorted {
+			if len(periods) >= count {
+				return
+			}
+			if key := period(archive.Created); !periods[key] {
+				periods[key] = true
+				kept[archive.Name] = true
+			}
+		}
+	}
+	keep(policy.Daily, func(t time.Time) string {
+		return t.Format("2006-01-02")
+	})
+	keep(policy.Weekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-%02d", year, week)
+	})
+	keep(policy.Monthly, func(t time.Time) string {
+		return t.Format("2006-01")
+	})
+
+	var expired []BackupArchive
+	for i := len(sorted) - 1; i >= 0; i-- {
+		if !kept[sorted[i].Name] {
+			expired = append(expired, sorted[i])
+		}
+	}
+
+	return expired
+}
@@ -0,0 +1,72 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackupArchiveName(t *testing.T) {
+	created := time.Date(2021, 2, 1, 10, 0, 0, 0, time.Local)
+	name := BackupArchiveName(BackupArchivePrefix, created)
+	if name != "deskpro-backup.2021-02-01_10-00-00.zip" {
+		t.Errorf("Unexpected name %s", name)
+	}
+
+	if parsed, ok := ParseBackupArchiveName(BackupArchivePrefix, name); !ok || !parsed.Equal(created) {
+		t.Errorf("Unexpected time %s", parsed)
+	}
+	for _, volume := range []string{VolumeName(name, 1), VolumeName(name, 2)} {
+		if parsed, ok := ParseBackupArchiveName(BackupArchivePrefix, volume); !ok || !parsed.Equal(created) {
+			t.Errorf("Unexpected time %s of %s", parsed, volume)
+		}
+	}
+	for _, other := range []string{"deskpro-backup-database.2021-02-01_10-00-00.zip", "deskpro-backup.zip", "notes.txt"} {
+		if _, ok := ParseBackupArchiveName(BackupArchivePrefix, other); ok {
+			t.Errorf("%s was parsed as a backup archive", other)
+		}
+	}
+}
+
+func TestExpiredArchives(t *testing.T) {
+	// a backup every day at 2:00 and 14:00 for 100 days
+	var archives []BackupArchive
+	last := time.Date(2021, 4, 11, 14, 0, 0, 0, time.UTC) // a Sunday
+	for i := 0; i < 200; i++ {
+		created := last.Add(-time.Duration(i) * 12 * time.Hour)
+		archives = append(archives, BackupArchive{Name: created.Format(time.RFC3339), Created: created})
+	}
+
+	if expired := ExpiredArchives(archives, RetentionPolicy{}); len(expired) != 0 {
+		t.Errorf("Zero policy expired %d archives", len(expired))
+	}
+
+	expired := ExpiredArchives(archives, RetentionPolicy{Daily: 7, Weekly: 4, Monthly: 3})
+	kept := map[string]bool{}
+	for _, archive := range archives {
+		kept[archive.Name] = true
+	}
+	for _, archive := range expired {
+		delete(kept, archive.Name)
+	}
+
+	// 7 days, 3 more weeks (the newest week is the newest day), the newest archives of February and January
+	expected := []time.Time{
+		last,
+		last.AddDate(0, 0, -1), last.AddDate(0, 0, -2), last.AddDate(0, 0, -3),
+		last.AddDate(0, 0, -4), last.AddDate(0, 0, -5), last.AddDate(0, 0, -6),
+		last.AddDate(0, 0, -7), last.AddDate(0, 0, -14), last.AddDate(0, 0, -21),
+		time.Date(2021, 3, 31, 14, 0, 0, 0, time.UTC),
+		time.Date(2021, 2, 28, 14, 0, 0, 0, time.UTC),
+	}
+	if len(kept) != len(expected) {
+		t.Errorf("Expected %d kept archives, got %d: %v", len(expected), len(kept), kept)
+	}
+	for _, created := range expected {
+		if !kept[created.Format(time.RFC3339)] {
+			t.Errorf("Archive of %s wasn't kept", created)
+		}
+	}
+	if !expired[0].Created.Before(expired[len(expired)-1].Created) {
+		t.Error("Expired archives aren't sorted from the oldest")
+	}
+}
@@ -0,0 +1,110 @@
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// NoInput makes every prompt fail instead of waiting for an answer, it's set by the --no-input option
+var NoInput bool
+
+var envReference = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// RunConfig is the --config file with the options of dputils commands, so a restore or a backup can be scripted
+// and kept in git. Every section other than php, deskpro and no-input is named after a command and holds the
+// options of the command, e.g.
+//
+//	deskpro: /var/www/deskpro
+//	restore:
+//	  full-backup: s3://bucket/deskpro-backup.zip?region=eu-west-1
+//	  mysql-direct: deskpro:${SOURCE_DB_PASSWORD}@db.example.com/deskpro
+//	  skip-upgrade: true
+//
+// Options may reference environment variables as ${NAME}, so credentials don't have to be in the file.
+type RunConfig struct {
+	Php      string                            `yaml:"php"`
+	Deskpro  string                            `yaml:"deskpro"`
+	NoInput  bool                              `yaml:"no-input"`
+	Commands map[string]map[string]interface{} `yaml:",inline"`
+}
+
+// ReadRunConfig reads a config file and resolves the environment variable references
+func ReadRunConfig(path string) (*RunConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseRunConfig(data)
+}
+
+func ParseRunConfig(data []byte) (*RunConfig, error) {
+	var config RunConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if config.Php, err = expandEnv(config.Php); err != nil {
+		return nil, fmt.Errorf("php: %s", err)
+	}
+	if config.Deskpro, err = expandEnv(config.Deskpro); err != nil {
+		return nil, fmt.Errorf("deskpro: %s", err)
+	}
+
+	for command, options := range config.Commands {
+		for name, value := range options {
+			switch value.(type) {
+			case string, bool, int, float64:
+			default:
+				return nil, fmt.Errorf("%s: %s must be a string, a number or a boolean", command, name)
+			}
+
+			expanded, err := expandEnv(fmt.Sprint(value))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %s", command, name, err)
+			}
+			options[name] = expanded
+		}
+	}
+
+	return &config, nil
+}
+
+// CommandOptions returns the options of a command (e.g. "restore" or "attachments migrate") sorted by name
+func (c *RunConfig) CommandOptions(command string) ([]string, map[string]string) {
+	options := map[string]string{}
+	var names []string
+	for name, value := range c.Commands[command] {
+		options[name] = value.(string)
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, options
+}
+
+// expandEnv replaces ${NAME} references, a missing variable is an error so a scripted run fails early
+func expandEnv(value string) (string, error) {
+	var missing []string
+	expanded := envReference.ReplaceAllStringFunc(value, func(ref string) string {
+		name := envReference.FindStringSubmatch(ref)[1]
+		env, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return env
+	})
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+
+	return expanded, nil
+}
@@ -0,0 +1,60 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestParseRunConfig(t *testing.T) {
+	t.Setenv("DPUTILS_TEST_PASSWORD", "secret")
+
+	config, err := ParseRunConfig([]byte(`
+php: /usr/bin/php
+deskpro: /var/www/deskpro
+no-input: true
+restore:
+  mysql-direct: root:${DPUTILS_TEST_PASSWORD}@db/deskpro
+  skip-upgrade: true
+  attachment-workers: 8
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Php != "/usr/bin/php" || config.Deskpro != "/var/www/deskpro" || !config.NoInput {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	names, options := config.CommandOptions("restore")
+	if len(names) != 3 || names[0] != "attachment-workers" {
+		t.Errorf("Unexpected options %v", names)
+	}
+	if options["mysql-direct"] != "root:secret@db/deskpro" {
+		t.Errorf("Environment variable wasn't expanded: %s", options["mysql-direct"])
+	}
+	if options["skip-upgrade"] != "true" || options["attachment-workers"] != "8" {
+		t.Errorf("Unexpected options %v", options)
+	}
+
+	if names, _ = config.CommandOptions("backup"); len(names) != 0 {
+		t.Errorf("Unexpected backup options %v", names)
+	}
+}
+
+func TestParseRunConfigMissingEnv(t *testing.T) {
+	_, err := ParseRunConfig([]byte(`
+restore:
+  mysql-direct: root:${DPUTILS_TEST_MISSING}@db/deskpro
+`))
+	if err == nil {
+		t.Error("Missing environment variable should fail")
+	}
+
+	_, err = ParseRunConfig([]byte(`
+restore:
+  mysql-direct:
+    host: db
+`))
+	if err == nil {
+		t.Error("Nested option should fail")
+	}
+}
@@ -0,0 +1,317 @@
+package util
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	_ "embed"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+//go:embed sanitize_rules.json
+var defaultSanitizeRules []byte
+
+// SanitizeRules describes which columns are replaced with fake values. Every column is mapped to the kind of
+// fake value: email, first_name, last_name, name, company, phone, text, secret, url or empty.
+type SanitizeRules struct {
+	Tables []SanitizeTable `json:"tables"`
+}
+
+// SanitizeTable is the rule for a single table. Rows are updated one by one by the key column, which must be
+// unique. Group allows to enable only some rules, e.g. "tickets" rules rewrite the content of tickets.
+type SanitizeTable struct {
+	Table   string            `json:"table"`
+	Key     string            `json:"key"`
+	Group   string            `json:"group"`
+	Where   string            `json:"where,omitempty"`
+	Columns map[string]string `json:"columns"`
+}
+
+// SanitizeResult is what was done with a table
+type SanitizeResult struct {
+	Table   string
+	Rows    int64
+	Skipped string
+}
+
+var fakeKinds = map[string]bool{
+	"email": true, "first_name": true, "last_name": true, "name": true, "company": true,
+	"phone": true, "text": true, "secret": true, "url": true, "empty": true,
+}
+
+// DefaultSanitizeRules returns the built-in rules for the Deskpro schema
+func DefaultSanitizeRules() *SanitizeRules {
+	rules, err := ParseSanitizeRules(defaultSanitizeRules)
+	if err != nil {
+		panic(err)
+	}
+
+	return rules
+}
+
+// ReadSanitizeRules reads a rules file in the same JSON format as the built-in rules
+func ReadSanitizeRules(path string) (*SanitizeRules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseSanitizeRules(data)
+}
+
+func ParseSanitizeRules(data []byte) (*SanitizeRules, error) {
+	var rules SanitizeRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+
+	for _, table := range rules.Tables {
+		if table.Table == "" || table.Key == "" {
+			return nil, fmt.Errorf("every sanitize rule must have a table and a key")
+		}
+		for column, kind := range table.Columns {
+			if !fakeKinds[kind] {
+				return nil, fmt.Errorf("unknown fake value %q for %s.%s", kind, table.Table, column)
+			}
+		}
+	}
+
+	return &rules, nil
+}
+
+// Sanitizer replaces personal data and secrets with fake values. Fake values are derived from the original value
+// and the salt, so the same email is replaced with the same fake email in every table.
+type Sanitizer struct {
+	Db        *sql.DB
+	Salt      string
+	Groups    []string
+	BatchSize int
+}
+
+// Run applies the rules of the enabled groups. Tables or columns which don't exist in the database are skipped.
+func (s *Sanitizer) Run(rules *SanitizeRules, progress func(result SanitizeResult)) ([]SanitizeResult, error) {
+	var results []SanitizeResult
+
+	for _, table := range rules.Tables {
+		if !s.groupEnabled(table.Group) {
+			continue
+		}
+
+		result, err := s.sanitizeTable(table)
+		if err != nil {
+			return results, fmt.Errorf("%s: %s", table.Table, err)
+		}
+		results = append(results, result)
+		if progress != nil {
+			progress(result)
+		}
+	}
+
+	return results, nil
+}
+
+func (s *Sanitizer) groupEnabled(group string) bool {
+	for _, enabled := range s.Groups {
+		if enabled == group {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Sanitizer) sanitizeTable(table SanitizeTable) (SanitizeResult, error) {
+	result := SanitizeResult{Table: table.Table}
+
+	existing, err := s.tableColumns(table.Table)
+	if err != nil {
+		return result, err
+	}
+	if !existing[table.Key] {
+		result.Skipped = "table or key column doesn't exist"
+		return result, nil
+	}
+
+	var columns []string
+	for column := range table.Columns {
+		if existing[column] {
+			columns = append(columns, column)
+		}
+	}
+	if len(columns) == 0 {
+		result.Skipped = "none of the columns exist"
+		return result, nil
+	}
+	sort.Strings(columns)
+
+	quoted := make([]string, len(columns))
+	assignments := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = QuoteIdentifier(column)
+		assignments[i] = QuoteIdentifier(column) + " = ?"
+	}
+
+	where := ""
+	if table.Where != "" {
+		where = " AND (" + table.Where + ")"
+	}
+	selectSql := fmt.Sprintf(
+		"SELECT %s, %s FROM %s WHERE %s > ?%s ORDER BY %s LIMIT %d",
+		QuoteIdentifier(table.Key), strings.Join(quoted, ", "), QuoteIdentifier(table.Table),
+		QuoteIdentifier(table.Key), where, QuoteIdentifier(table.Key), s.BatchSize,
+	)
+	updateSql := fmt.Sprintf(
+		"UPDATE %s SET %s WHERE %s = ?",
+		QuoteIdentifier(table.Table), strings.Join(assignments, ", "), QuoteIdentifier(table.Key),
+	)
+
+	var lastKey interface{} = 0
+	for {
+		rows, err := s.readBatch(selectSql, lastKey, len(columns))
+		if err != nil {
+			if table.Where != "" && result.Rows == 0 {
+				// the built-in rules may filter by columns which only exist in some Deskpro versions
+				result.Skipped = "where condition failed: " + err.Error()
+				return result, nil
+			}
+			return result, err
+		}
+		if len(rows) == 0 {
+			return result, nil
+		}
+
+		tx, err := s.Db.Begin()
+		if err != nil {
+			return result, err
+		}
+		for _, row := range rows {
+			args := make([]interface{}, 0, len(columns)+1)
+			for i, column := range columns {
+				args = append(args, s.fakeNullable(table.Columns[column], row.values[i]))
+			}
+			args = append(args, row.key)
+
+			if _, err = tx.Exec(updateSql, args...); err != nil {
+				_ = tx.Rollback()
+				return result, err
+			}
+		}
+		if err = tx.Commit(); err != nil {
+			return result, err
+		}
+
+		result.Rows += int64(len(rows))
+		lastKey = rows[len(rows)-1].key
+	}
+}
+
+type sanitizeRow struct {
+	key    interface{}
+	values []sql.NullString
+}
+
+func (s *Sanitizer) readBatch(selectSql string, lastKey interface{}, columns int) ([]sanitizeRow, error) {
+	res, err := s.Db.Query(selectSql, lastKey)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var rows []sanitizeRow
+	for res.Next() {
+		var key sql.RawBytes
+		row := sanitizeRow{values: make([]sql.NullString, columns)}
+		dest := []interface{}{&key}
+		for i := range row.values {
+			dest = append(dest, &row.values[i])
+		}
+		if err = res.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row.key = string(key)
+		rows = append(rows, row)
+	}
+
+	return rows, res.Err()
+}
+
+func (s *Sanitizer) tableColumns(table string) (map[string]bool, error) {
+	rows, err := s.Db.Query(
+		"SELECT COLUMN_NAME FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ?",
+		table,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := map[string]bool{}
+	for rows.Next() {
+		var column string
+		if err = rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns[column] = true
+	}
+
+	return columns, rows.Err()
+}
+
+// fakeNullable keeps NULL and empty values, there is nothing personal in them
+func (s *Sanitizer) fakeNullable(kind string, value sql.NullString) interface{} {
+	if !value.Valid {
+		return nil
+	}
+	if value.String == "" {
+		return ""
+	}
+
+	return s.Fake(kind, value.String)
+}
+
+var (
+	fakeFirstNames = []string{"Alex", "Sam", "Jordan", "Taylor", "Morgan", "Casey", "Riley", "Jamie", "Avery", "Quinn", "Robin", "Charlie"}
+	fakeLastNames  = []string{"Smith", "Jones", "Taylor", "Brown", "Wilson", "Evans", "Thomas", "Johnson", "Roberts", "Walker", "Wright", "Hall"}
+)
+
+// Fake returns the fake value of the kind for the original value
+func (s *Sanitizer) Fake(kind string, value string) string {
+	sum := sha256.Sum256([]byte(s.Salt + "\x00" + strings.ToLower(strings.TrimSpace(value))))
+	hash := hex.EncodeToString(sum[:])
+	n := binary.BigEndian.Uint64(sum[:8])
+
+	first := fakeFirstNames[n%uint64(len(fakeFirstNames))]
+	last := fakeLastNames[(n/uint64(len(fakeFirstNames)))%uint64(len(fakeLastNames))]
+
+	switch kind {
+	case "email":
+		return "user-" + hash[:12] + "@example.invalid"
+	case "first_name":
+		return first
+	case "last_name":
+		return last
+	case "name":
+		return first + " " + last
+	case "company":
+		return "Company " + hash[:8]
+	case "phone":
+		return fmt.Sprintf("+1555%07d", n%10000000)
+	case "text":
+		return "Sanitized text " + hash[:8]
+	case "secret":
+		// keep the length, secrets are often stored in fixed size columns
+		if len(value) < len(hash) {
+			return hash[:len(value)]
+		}
+		return hash
+	case "url":
+		return "https://example.invalid/" + hash[:16]
+	}
+
+	return ""
+}
@@ -0,0 +1,101 @@
+package util
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSanitizerFake(t *testing.T) {
+	s := &Sanitizer{Salt: "salt"}
+
+	if s.Fake("email", "John@Example.com") != s.Fake("email", "john@example.com ") {
+		t.Error("The same email should get the same fake value")
+	}
+	if s.Fake("email", "john@example.com") == (&Sanitizer{Salt: "other"}).Fake("email", "john@example.com") {
+		t.Error("Fake values should depend on the salt")
+	}
+	if email := s.Fake("email", "john@example.com"); !strings.HasSuffix(email, "@example.invalid") {
+		t.Errorf("Unexpected fake email %s", email)
+	}
+	if secret := s.Fake("secret", "abcdef"); len(secret) != 6 {
+		t.Errorf("Fake secret %s should keep the length of the original", secret)
+	}
+	if phone := s.Fake("phone", "+44 20 7946 0000"); len(phone) != 12 || !strings.HasPrefix(phone, "+1555") {
+		t.Errorf("Unexpected fake phone %s", phone)
+	}
+	if s.Fake("empty", "Mr") != "" {
+		t.Error("empty should clear the value")
+	}
+}
+
+func TestParseSanitizeRules(t *testing.T) {
+	if len(DefaultSanitizeRules().Tables) == 0 {
+		t.Error("Built-in rules are empty")
+	}
+
+	_, err := ParseSanitizeRules([]byte(`{"tables": [{"table": "people", "key": "id", "columns": {"name": "unknown"}}]}`))
+	if err == nil {
+		t.Error("Unknown fake value should be rejected")
+	}
+
+	_, err = ParseSanitizeRules([]byte(`{"tables": [{"table": "people", "columns": {"name": "name"}}]}`))
+	if err == nil {
+		t.Error("Rule without a key should be rejected")
+	}
+}
+
+func TestSanitizerRun(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rules := &SanitizeRules{Tables: []SanitizeTable{
+		{Table: "people_emails", Key: "id", Group: "people", Columns: map[string]string{"email": "email", "comment": "text"}},
+		{Table: "missing", Key: "id", Group: "people", Columns: map[string]string{"email": "email"}},
+		{Table: "tickets", Key: "id", Group: "tickets", Columns: map[string]string{"subject": "text"}},
+	}}
+	s := &Sanitizer{Db: db, Salt: "salt", Groups: []string{"people"}, BatchSize: 2}
+
+	columnsSql := `SELECT COLUMN_NAME FROM information_schema.columns WHERE table_schema = DATABASE\(\) AND table_name = \?`
+	selectSql := "SELECT `id`, `email` FROM `people_emails` WHERE `id` > \\? ORDER BY `id` LIMIT 2"
+	updateSql := "UPDATE `people_emails` SET `email` = \\? WHERE `id` = \\?"
+
+	mock.ExpectQuery(columnsSql).WithArgs("people_emails").WillReturnRows(sqlmock.NewRows([]string{"COLUMN_NAME"}).AddRow("id").AddRow("email"))
+	mock.ExpectQuery(selectSql).WithArgs(0).WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).
+		AddRow(1, "john@example.com").
+		AddRow(2, nil))
+	mock.ExpectBegin()
+	mock.ExpectExec(updateSql).WithArgs(s.Fake("email", "john@example.com"), "1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(updateSql).WithArgs(nil, "2").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectQuery(selectSql).WithArgs("2").WillReturnRows(sqlmock.NewRows([]string{"id", "email"}))
+	mock.ExpectQuery(columnsSql).WithArgs("missing").WillReturnRows(sqlmock.NewRows([]string{"COLUMN_NAME"}))
+
+	results, err := s.Run(rules, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 || results[0].Rows != 2 || results[1].Skipped == "" {
+		t.Errorf("Unexpected results %+v", results)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSanitizerKeepsNull(t *testing.T) {
+	s := &Sanitizer{Salt: "salt"}
+
+	if s.fakeNullable("email", sql.NullString{}) != nil {
+		t.Error("NULL should stay NULL")
+	}
+	if s.fakeNullable("email", sql.NullString{Valid: true}) != "" {
+		t.Error("Empty value should stay empty")
+	}
+}
@@ -0,0 +1,140 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// TableFilter selects tables by glob patterns like "ticket_*" (see path.Match). A table is selected if it matches
+// one of the Include patterns, or Include is empty, and none of the Exclude patterns. A zero filter selects every
+// table.
+type TableFilter struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+func (f TableFilter) IsZero() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+// Validate checks the syntax of the patterns
+func (f TableFilter) Validate() error {
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("wrong table pattern %q: %s", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// Match tells if the table is selected by the filter
+func (f TableFilter) Match(table string) bool {
+	included := len(f.Include) == 0
+	for _, pattern := range f.Include {
+		if ok, _ := path.Match(pattern, table); ok {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+
+	for _, pattern := range f.Exclude {
+		if ok, _ := path.Match(pattern, table); ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Filter returns the selected tables
+func (f TableFilter) Filter(tables []string) []string {
+	var selected []string
+	for _, table := range tables {
+		if f.Match(table) {
+			selected = append(selected, table)
+		}
+	}
+
+	return selected
+}
+
+// String describes the filter for the output, e.g. "settings, email_* except email_logs"
+func (f TableFilter) String() string {
+	description := "all tables"
+	if len(f.Include) > 0 {
+		description = strings.Join(f.Include, ", ")
+	}
+	if len(f.Exclude) > 0 {
+		description += " except " + strings.Join(f.Exclude, ", ")
+	}
+
+	return description
+}
+
+var (
+	// the comments mysqldump and NativeDump write before the statements of every table and view
+	dumpTableSection = regexp.MustCompile("^-- (?:Table structure|Dumping data|Temporary table structure|Temporary view structure|Final view structure|View structure) for (?:table|view) `(.*)`\\s*$")
+	// the statements after these comments don't belong to a table
+	dumpGlobalSection = regexp.MustCompile(`^-- Dumping (?:routines|events) for database`)
+)
+
+// FilterDump returns a reader of the SQL dump without the statements of the tables and views the filter doesn't
+// select. Statements are assigned to tables by the section comments of mysqldump and NativeDump, the header and
+// the routines of the dump are kept. Closing the reader stops the filter.
+func FilterDump(r io.Reader, filter TableFilter) io.ReadCloser {
+	if filter.IsZero() {
+		return ioutil.NopCloser(r)
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		_ = writer.CloseWithError(filterDump(r, filter, writer))
+	}()
+
+	return reader
+}
+
+func filterDump(r io.Reader, filter TableFilter, w io.Writer) error {
+	var (
+		in        = bufio.NewReaderSize(r, 64*1024)
+		out       = bufio.NewWriterSize(w, 64*1024)
+		selected  = true
+		lineStart = true
+	)
+
+	for {
+		// INSERT lines may be megabytes long, they're copied in chunks
+		chunk, err := in.ReadSlice('\n')
+		if err != nil && err != bufio.ErrBufferFull && err != io.EOF {
+			return err
+		}
+
+		if lineStart && len(chunk) > 3 && chunk[0] == '-' && chunk[1] == '-' {
+			line := strings.TrimRight(string(chunk), "\r\n")
+			if match := dumpTableSection.FindStringSubmatch(line); match != nil {
+				selected = filter.Match(strings.ReplaceAll(match[1], "``", "`"))
+			} else if dumpGlobalSection.MatchString(line) {
+				selected = true
+			}
+		}
+
+		if selected {
+			if _, writeErr := out.Write(chunk); writeErr != nil {
+				return writeErr
+			}
+		}
+		if err == io.EOF {
+			return out.Flush()
+		}
+		lineStart = err == nil
+	}
+}
@@ -0,0 +1,78 @@
+package util
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestTableFilterMatch(t *testing.T) {
+	filter := TableFilter{Include: []string{"ticket*", "people"}, Exclude: []string{"ticket_search*"}}
+	for table, expected := range map[string]bool{
+		"tickets":             true,
+		"ticket_messages":     true,
+		"people":              true,
+		"ticket_search_words": false,
+		"settings":            false,
+	} {
+		if filter.Match(table) != expected {
+			t.Errorf("%s: expected %v", table, expected)
+		}
+	}
+
+	if !(TableFilter{}).Match("settings") {
+		t.Error("A zero filter must select every table")
+	}
+	if err := (TableFilter{Include: []string{"ticket["}}).Validate(); err == nil {
+		t.Error("Expected an error for a wrong pattern")
+	}
+}
+
+func TestFilterDump(t *testing.T) {
+	dump := `-- MySQL dump 10.13
+SET NAMES utf8mb4;
+
+--
+-- Table structure for table ` + "`people`" + `
+--
+
+CREATE TABLE ` + "`people`" + ` (id int);
+
+--
+-- Dumping data for table ` + "`people`" + `
+--
+
+INSERT INTO ` + "`people`" + ` VALUES (1);
+
+--
+-- Table structure for table ` + "`tickets`" + `
+--
+
+CREATE TABLE ` + "`tickets`" + ` (id int);
+INSERT INTO ` + "`tickets`" + ` VALUES (1);
+
+--
+-- Dumping routines for database 'deskpro'
+--
+
+CREATE PROCEDURE p() BEGIN END;
+-- Dump completed
+`
+
+	r := FilterDump(strings.NewReader(dump), TableFilter{Include: []string{"tick*"}})
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filtered := string(b)
+
+	for _, expected := range []string{"SET NAMES", "CREATE TABLE `tickets`", "INSERT INTO `tickets`", "CREATE PROCEDURE", "Dump completed"} {
+		if !strings.Contains(filtered, expected) {
+			t.Errorf("%q was filtered out", expected)
+		}
+	}
+	if strings.Contains(filtered, "`people`") {
+		t.Error("The people table wasn't filtered out")
+	}
+}
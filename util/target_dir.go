@@ -0,0 +1,201 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// TargetDir lists, reads and removes the files of a backup target dir, so old archives can be rotated and
+// archived binary logs read back
+type TargetDir interface {
+	// List returns the names of the files in the dir
+	List() ([]string, error)
+	Open(name string) (io.ReadCloser, error)
+	Remove(name string) error
+	Close() error
+}
+
+// OpenTargetDir opens a local dir or an s3:// or sftp:// target dir in the form accepted by OpenRemoteTarget.
+// HTTP targets can't be listed.
+func OpenTargetDir(target string) (TargetDir, error) {
+	if !IsRemoteTarget(target) {
+		return localTargetDir(target), nil
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "s3":
+		return openS3TargetDir(u)
+	case "sftp":
+		return openSftpTargetDir(u)
+	}
+
+	return nil, fmt.Errorf("files of %s targets can't be listed", u.Scheme)
+}
+
+type localTargetDir string
+
+func (dir localTargetDir) List() ([]string, error) {
+	files, err := ioutil.ReadDir(string(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, f := range files {
+		if !f.IsDir() {
+			names = append(names, f.Name())
+		}
+	}
+
+	return names, nil
+}
+
+func (dir localTargetDir) Open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(dir), name))
+}
+
+func (dir localTargetDir) Remove(name string) error {
+	return os.Remove(filepath.Join(string(dir), name))
+}
+
+func (dir localTargetDir) Close() error {
+	return nil
+}
+
+type s3TargetDir struct {
+	client *s3.S3
+	bucket string
+	prefix string
+}
+
+func openS3TargetDir(u *url.URL) (TargetDir, error) {
+	sess, err := newS3Session(u)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	return &s3TargetDir{client: s3.New(sess), bucket: u.Host, prefix: prefix}, nil
+}
+
+func (dir *s3TargetDir) List() ([]string, error) {
+	var names []string
+	err := dir.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:    aws.String(dir.bucket),
+		Prefix:    aws.String(dir.prefix),
+		Delimiter: aws.String("/"),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			names = append(names, strings.TrimPrefix(aws.StringValue(object.Key), dir.prefix))
+		}
+		return true
+	})
+
+	return names, err
+}
+
+func (dir *s3TargetDir) Open(name string) (io.ReadCloser, error) {
+	object, err := dir.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(dir.bucket),
+		Key:    aws.String(dir.prefix + name),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return object.Body, nil
+}
+
+func (dir *s3TargetDir) Remove(name string) error {
+	_, err := dir.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(dir.bucket),
+		Key:    aws.String(dir.prefix + name),
+	})
+
+	return err
+}
+
+func (dir *s3TargetDir) Close() error {
+	return nil
+}
+
+type sftpTargetDir struct {
+	conn   *ssh.Client
+	client *sftp.Client
+	dir    string
+}
+
+func openSftpTargetDir(u *url.URL) (TargetDir, error) {
+	config, err := sshClientConfig(u)
+	if err != nil {
+		return nil, err
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "22")
+	}
+
+	conn, err := ssh.Dial("tcp", host, config)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return &sftpTargetDir{conn: conn, client: client, dir: u.Path}, nil
+}
+
+func (dir *sftpTargetDir) List() ([]string, error) {
+	files, err := dir.client.ReadDir(dir.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, f := range files {
+		if !f.IsDir() {
+			names = append(names, f.Name())
+		}
+	}
+
+	return names, nil
+}
+
+func (dir *sftpTargetDir) Open(name string) (io.ReadCloser, error) {
+	return dir.client.Open(path.Join(dir.dir, name))
+}
+
+func (dir *sftpTargetDir) Remove(name string) error {
+	return dir.client.Remove(path.Join(dir.dir, name))
+}
+
+func (dir *sftpTargetDir) Close() error {
+	_ = dir.client.Close()
+	return dir.conn.Close()
+}
@@ -0,0 +1,88 @@
+package util
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var volumeSuffix = regexp.MustCompile(`\.(\d{3})\.zip$`)
+
+// ManifestVolume is a part of an archive split into volumes. The first volume is a zip archive which only holds
+// the manifest listing the other volumes. The other volumes aren't zip archives on their own, concatenated in
+// their order they are the backup archive.
+type ManifestVolume struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+// VolumeName returns the name of the nth volume of an archive, starting at 1. The archive is a path or a URL
+// ending with .zip, e.g. deskpro-backup.DATE.zip becomes deskpro-backup.DATE.002.zip. The query of a URL is kept.
+func VolumeName(archive string, n int) string {
+	name, query := cutQuery(archive)
+
+	return strings.TrimSuffix(name, ".zip") + fmt.Sprintf(".%03d.zip", n) + query
+}
+
+// VolumeArchive returns the archive a volume named by VolumeName belongs to and the number of the volume.
+// It returns false if the path or URL isn't a volume.
+func VolumeArchive(volume string) (string, int, bool) {
+	name, query := cutQuery(volume)
+	match := volumeSuffix.FindStringSubmatch(name)
+	if match == nil {
+		return "", 0, false
+	}
+	n, _ := strconv.Atoi(match[1])
+
+	return strings.TrimSuffix(name, match[0]) + ".zip" + query, n, true
+}
+
+// VolumeBaseName is the file name of a volume or an archive, without the dir and the query of a URL
+func VolumeBaseName(volume string) string {
+	name, _ := cutQuery(volume)
+
+	return path.Base(strings.ReplaceAll(name, "\\", "/"))
+}
+
+// cutQuery splits a URL into the part before the query and the query with the "?"
+func cutQuery(target string) (string, string) {
+	if i := strings.Index(target, "?"); i >= 0 {
+		return target[:i], target[i:]
+	}
+
+	return target, ""
+}
+
+// ParseSize parses a size like 5G, 500M or 1024 (bytes), the units are powers of 1024
+func ParseSize(value string) (int64, error) {
+	size := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for i, unit := range []string{"K", "M", "G", "T"} {
+		if strings.HasSuffix(size, unit) || strings.HasSuffix(size, unit+"B") {
+			size = strings.TrimSuffix(strings.TrimSuffix(size, "B"), unit)
+			multiplier = int64(1) << (10 * (i + 1))
+			break
+		}
+	}
+
+	number, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || number <= 0 {
+		return 0, fmt.Errorf("wrong size %q, use a number of bytes or a number with K, M, G or T", value)
+	}
+
+	return number * multiplier, nil
+}
+
+// FormatSize formats a number of bytes like ParseSize accepts them, e.g. 1.5G
+func FormatSize(size int64) string {
+	for i, unit := range []string{"T", "G", "M", "K"} {
+		if multiplier := int64(1) << (10 * (4 - i)); size >= multiplier {
+			return strconv.FormatFloat(float64(size)/float64(multiplier), 'f', 1, 64) + unit
+		}
+	}
+
+	return strconv.FormatInt(size, 10)
+}
@@ -0,0 +1,53 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestVolumeName(t *testing.T) {
+	tests := []struct {
+		archive string
+		volume  string
+	}{
+		{"/backups/deskpro-backup.zip", "/backups/deskpro-backup.002.zip"},
+		{"s3://bucket/deskpro-backup.zip?region=eu-west-1", "s3://bucket/deskpro-backup.002.zip?region=eu-west-1"},
+	}
+	for _, test := range tests {
+		if volume := VolumeName(test.archive, 2); volume != test.volume {
+			t.Errorf("Expected %s, got %s", test.volume, volume)
+		}
+		if archive, n, ok := VolumeArchive(test.volume); !ok || n != 2 || archive != test.archive {
+			t.Errorf("Unexpected archive %s of volume %d", archive, n)
+		}
+	}
+
+	for _, archive := range []string{"/backups/deskpro-backup.zip", "/backups/deskpro-backup.zip.001", "/backups/deskpro-backup.1.zip"} {
+		if _, _, ok := VolumeArchive(archive); ok {
+			t.Errorf("%s was detected as a volume", archive)
+		}
+	}
+	if name := VolumeBaseName("sftp://user@host/backups/deskpro-backup.001.zip?insecure=true"); name != "deskpro-backup.001.zip" {
+		t.Errorf("Unexpected base name %s", name)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for value, expected := range map[string]int64{"1024": 1024, "5G": 5 << 30, "500mb": 500 << 20, "2K": 2048} {
+		if size, err := ParseSize(value); err != nil || size != expected {
+			t.Errorf("%s: expected %d, got %d (%v)", value, expected, size, err)
+		}
+	}
+	for _, value := range []string{"", "G", "-1M", "5X"} {
+		if _, err := ParseSize(value); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	for size, expected := range map[int64]string{512: "512", 1536: "1.5K", 5 << 30: "5.0G"} {
+		if formatted := FormatSize(size); formatted != expected {
+			t.Errorf("%d: expected %s, got %s", size, expected, formatted)
+		}
+	}
+}